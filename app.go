@@ -0,0 +1,413 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/internal/bridge"
+	"github.com/diamondburned/distant-front/internal/config"
+	"github.com/diamondburned/distant-front/internal/flood"
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/frontend/admin"
+	"github.com/diamondburned/distant-front/internal/frontend/api"
+	"github.com/diamondburned/distant-front/internal/frontend/index"
+	"github.com/diamondburned/distant-front/internal/frontend/index/trackmap"
+	"github.com/diamondburned/distant-front/internal/frontend/overview"
+	"github.com/diamondburned/distant-front/internal/history"
+	"github.com/diamondburned/distant-front/internal/metrics"
+	"github.com/diamondburned/distant-front/internal/session"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instance is a single running Distance server.
+type instance struct {
+	cfg      config.Server
+	client   *distance.Client
+	observer *distance.Observer
+	history  *history.DB
+	bridge   *bridge.Bridge
+	irc      *bridge.IRC
+	audit    *audit.Log
+	flood    *flood.Control
+	// listeners counts the live chat streams of the server.
+	listeners prometheus.Gauge
+	trails    *trackmap.Tracker
+	stops     []func()
+}
+
+// newInstance creates the client of the given server. Nothing is started until
+// start is called, so an instance that is never started needs no cleanup.
+func newInstance(cfg config.Server, global *config.Config, auditLog *audit.Log) (*instance, error) {
+	c, err := distance.NewClient(cfg.Endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Distance client")
+	}
+
+	if cfg.Token != "" {
+		c.SetPrivateToken(cfg.Token)
+	}
+
+	c.Retry = distance.RetryPolicy{
+		MaxAttempts: global.Retry.Attempts,
+		BaseDelay:   time.Duration(global.Retry.BaseDelay),
+		MaxDelay:    time.Duration(global.Retry.MaxDelay),
+	}
+
+	return &instance{
+		cfg:    cfg,
+		client: c,
+		audit:  auditLog,
+		flood:  flood.New(floodOpts(global.Chat)),
+	}, nil
+}
+
+// start starts observing the server. If the instance replaces prev, prev's
+// workers must already be stopped, and its history database is taken over
+// instead of being opened again.
+func (inst *instance) start(global *config.Config, reg *prometheus.Registry, prev *instance) {
+	cfg := inst.cfg
+	c := inst.client
+
+	r := prometheus.WrapRegistererWith(prometheus.Labels{"server": cfg.ID}, reg)
+	inst.stops = append(inst.stops, metrics.InstrumentClient(r, c))
+
+	inst.observer = distance.NewObserver(c, time.Duration(global.ObserveFrequency))
+	inst.stops = append(inst.stops, metrics.ObserveServer(r, inst.observer))
+
+	tracker, stopTracker := trackmap.NewTracker(inst.observer)
+	inst.trails = tracker
+	inst.stops = append(inst.stops, stopTracker)
+
+	listeners, unregister := metrics.NewChatListeners(r)
+	inst.listeners = listeners
+	inst.stops = append(inst.stops, unregister)
+
+	if cfg.Bridge.WebhookURL != "" || cfg.Bridge.Token != "" {
+		username := cfg.Bridge.Username
+		if username == "" {
+			username = cfg.Name
+		}
+
+		inst.bridge = bridge.New(c, bridge.Opts{
+			WebhookURL: cfg.Bridge.WebhookURL,
+			Username:   username,
+			Token:      cfg.Bridge.Token,
+			Source:     cfg.Bridge.Source,
+		})
+		inst.bridge.OnRelay = inst.record
+		inst.stops = append(inst.stops, inst.bridge.Start(inst.observer))
+	}
+
+	if cfg.IRC.Addr != "" {
+		inst.irc = bridge.NewIRC(c, bridge.IRCOpts{
+			Addr:     cfg.IRC.Addr,
+			TLS:      cfg.IRC.TLS,
+			Nick:     cfg.IRC.Nick,
+			Password: cfg.IRC.Password,
+			Channel:  cfg.IRC.Channel,
+			Notices:  cfg.IRC.Notices,
+			Source:   cfg.IRC.Source,
+		})
+		inst.irc.OnRelay = inst.record
+		inst.stops = append(inst.stops, inst.irc.Start(inst.observer))
+	}
+
+	if global.History != "" {
+		if prev != nil && prev.history != nil {
+			// The database can only be opened once. The old instance keeps
+			// serving from it until it's stopped, but no longer closes it.
+			inst.history = prev.history
+			prev.history = nil
+		} else {
+			path := global.History
+			if len(global.Servers) > 1 {
+				path = filepath.Join(path, cfg.ID)
+			}
+
+			hist, err := history.Open(path)
+			if err != nil {
+				log.Printf("Warning: server %q: history load error, history disabled: %v", cfg.ID, err)
+			} else {
+				inst.history = hist
+			}
+		}
+
+		if inst.history != nil {
+			inst.stops = append(inst.stops, inst.history.Record(inst.observer))
+		}
+	}
+}
+
+// floodOpts returns the flood control options of the given configuration.
+func floodOpts(cfg config.Chat) flood.Opts {
+	return flood.Opts{
+		MaxLength:    cfg.MaxLength,
+		SessionBurst: cfg.Burst,
+		SessionEvery: time.Duration(cfg.Every),
+		IPBurst:      cfg.IPBurst,
+		IPEvery:      time.Duration(cfg.IPEvery),
+		Duplicate:    time.Duration(cfg.Duplicate),
+	}
+}
+
+// record records the given action on the instance's server in the audit log.
+func (inst *instance) record(entry audit.Entry) {
+	entry.Server = inst.cfg.ID
+	inst.audit.Record(entry)
+}
+
+// stopWorkers stops the metrics, bridges and history recording of the
+// instance. Its Observer and history database are kept for the requests that
+// are still being served.
+func (inst *instance) stopWorkers() {
+	for _, stop := range inst.stops {
+		stop()
+	}
+	inst.stops = nil
+}
+
+// stop stops the instance and releases its resources.
+func (inst *instance) stop() {
+	inst.stopWorkers()
+	inst.observer.Stop()
+
+	if inst.history != nil {
+		inst.history.Close()
+	}
+}
+
+// renderState creates the render state of the instance mounted under prefix.
+func (inst *instance) renderState(prefix string, global *config.Config, sessions *session.Store) frontend.RenderState {
+	// The endpoint is already validated.
+	distanceURL, _ := url.Parse(inst.cfg.Endpoint)
+
+	return frontend.RenderState{
+		Client:        inst.client,
+		Observer:      inst.observer,
+		History:       inst.history,
+		Audit:         inst.audit,
+		Flood:         inst.flood,
+		Pages:         frontend.NewPageCache(),
+		ChatListeners: inst.listeners,
+		Trails:        inst.trails,
+		Sessions:      sessions,
+		ID:            inst.cfg.ID,
+		Prefix:        prefix,
+		SiteName:      inst.cfg.Name,
+		DistanceURL:   distanceURL,
+		Cookie: frontend.CookieOpts{
+			Secure:   global.Cookie.Secure,
+			SameSite: global.Cookie.SameSiteMode(),
+			MaxAge:   time.Duration(global.Cookie.MaxAge),
+		},
+		SessionOpts: session.Opts{
+			TTL:      time.Duration(global.Session.TTL),
+			Rotate:   time.Duration(global.Session.Rotate),
+			Validate: time.Duration(global.Session.Validate),
+		},
+	}
+}
+
+// app is the whole application. Its configuration can be swapped while it is
+// serving without dropping connections.
+type app struct {
+	reg     *prometheus.Registry
+	handler atomic.Value // http.Handler
+
+	// These outlive configuration reloads.
+	adminSessions *session.Store
+	adminLogins   *flood.Control
+	sessions      *session.Store
+	audit         *audit.Log
+
+	mutex     sync.Mutex
+	cfg       *config.Config
+	instances map[string]*instance
+}
+
+func newApp(reg *prometheus.Registry, auditLog *audit.Log, sessions *session.Store) *app {
+	return &app{
+		reg:           reg,
+		adminSessions: session.NewStore(),
+		adminLogins:   admin.NewLogins(),
+		sessions:      sessions,
+		audit:         auditLog,
+		instances:     map[string]*instance{},
+	}
+}
+
+func (a *app) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.handler.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// apply applies the given configuration. Servers whose configuration didn't
+// change, including the retry policy, observe frequency and bridges, keep
+// running, so their connections are kept; other servers are restarted. Requests
+// that are already being served finish with the old configuration.
+func (a *app) apply(cfg *config.Config) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.cfg != nil {
+		warnRestart(a.cfg, cfg)
+	}
+
+	instances := make(map[string]*instance, len(cfg.Servers))
+
+	for _, server := range cfg.Servers {
+		old, ok := a.instances[server.ID]
+		if ok && old.cfg.Endpoint == server.Endpoint && old.cfg.Token == server.Token &&
+			old.cfg.Bridge == server.Bridge && old.cfg.IRC == server.IRC &&
+			a.cfg.Retry == cfg.Retry && a.cfg.ObserveFrequency == cfg.ObserveFrequency {
+			instances[server.ID] = old
+			continue
+		}
+
+		inst, err := newInstance(server, cfg, a.audit)
+		if err != nil {
+			return errors.Wrapf(err, "server %q", server.ID)
+		}
+		instances[server.ID] = inst
+	}
+
+	// Nothing can fail from here on, so the running servers are only touched
+	// now.
+	for _, server := range cfg.Servers {
+		inst := instances[server.ID]
+		old := a.instances[server.ID]
+
+		if inst == old {
+			old.cfg = server
+			old.flood.SetOpts(floodOpts(cfg.Chat))
+			continue
+		}
+
+		// The old instance's metrics and bridges can't run alongside the new
+		// one's, but it keeps serving until the new handler is swapped in.
+		if old != nil {
+			old.stopWorkers()
+		}
+		inst.start(cfg, a.reg, old)
+	}
+
+	r := chi.NewRouter()
+	r.Use(frontend.TrustProxies(cfg.TrustedNets()))
+
+	if len(cfg.Admin.Users) > 0 {
+		r.Mount("/admin", a.mountAdmin(cfg, instances))
+	}
+
+	// A single server is mounted at the root. Multiple servers are each mounted
+	// under their own prefix with an overview at the root.
+	if len(cfg.Servers) == 1 {
+		inst := instances[cfg.Servers[0].ID]
+		mountInstance(r, inst, inst.renderState("", cfg, a.sessions))
+	} else {
+		servers := make([]frontend.RenderState, len(cfg.Servers))
+
+		for i, server := range cfg.Servers {
+			inst := instances[server.ID]
+			rs := inst.renderState("/s/"+server.ID, cfg, a.sessions)
+			servers[i] = rs
+
+			r.Route(rs.Prefix, func(r chi.Router) {
+				mountInstance(r, inst, rs)
+			})
+		}
+
+		r.Mount("/", overview.Mount(cfg.Name, servers))
+	}
+
+	a.handler.Store(http.Handler(r))
+
+	// Stop the servers that were replaced or removed.
+	for id, inst := range a.instances {
+		if instances[id] != inst {
+			inst.stop()
+		}
+	}
+
+	a.cfg = cfg
+	a.instances = instances
+
+	return nil
+}
+
+// mountAdmin mounts the admin panel for all servers.
+func (a *app) mountAdmin(cfg *config.Config, instances map[string]*instance) http.Handler {
+	users := make(map[string]string, len(cfg.Admin.Users))
+	for _, user := range cfg.Admin.Users {
+		users[user.Name] = user.PasswordHash
+	}
+
+	servers := make([]frontend.RenderState, len(cfg.Servers))
+	for i, server := range cfg.Servers {
+		prefix := ""
+		if len(cfg.Servers) > 1 {
+			prefix = "/s/" + server.ID
+		}
+		servers[i] = instances[server.ID].renderState(prefix, cfg, a.sessions)
+	}
+
+	siteName := cfg.Name
+	if siteName == "" {
+		siteName = cfg.Servers[0].Name
+	}
+
+	return admin.Mount(admin.Opts{
+		SiteName:   siteName,
+		Users:      users,
+		Servers:    servers,
+		Sessions:   a.adminSessions,
+		Logins:     a.adminLogins,
+		SessionTTL: time.Duration(cfg.Admin.SessionTTL),
+		Audit:      a.audit,
+		Cookie:     servers[0].Cookie,
+	})
+}
+
+// mountInstance mounts the routes of a single server.
+func mountInstance(r chi.Router, inst *instance, rs frontend.RenderState) {
+	r.Mount("/api", api.Mount(rs))
+	if inst.bridge != nil && inst.cfg.Bridge.Token != "" {
+		r.Mount("/bridge", inst.bridge.Handler())
+	}
+	r.Mount("/", index.Mount(rs))
+}
+
+// warnRestart warns about the fields that cannot be applied without a
+// restart.
+func warnRestart(old, new *config.Config) {
+	warn := func(field string) {
+		log.Printf("Warning: changing %s requires a restart", field)
+	}
+
+	if old.Listen != new.Listen {
+		warn("listen")
+	}
+	if old.WorkshopCache != new.WorkshopCache {
+		warn("workshop_cache")
+	}
+	if old.History != new.History || (len(old.Servers) > 1) != (len(new.Servers) > 1) {
+		warn("history or the number of servers")
+	}
+	if old.Audit != new.Audit {
+		warn("audit")
+	}
+	if old.Session.Path != new.Session.Path {
+		warn("session.path")
+	}
+	if !reflect.DeepEqual(old.Markup, new.Markup) {
+		warn("markup")
+	}
+}
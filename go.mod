@@ -3,6 +3,7 @@ module github.com/diamondburned/distant-front
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.1.0
 	github.com/PuerkitoBio/goquery v1.6.1
 	github.com/andybalholm/cascadia v1.2.0
 	github.com/dgraph-io/badger v1.6.2
@@ -12,4 +13,7 @@ require (
 	github.com/joho/godotenv v1.3.0
 	github.com/lucasb-eyer/go-colorful v1.0.3
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.1
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
 )
@@ -0,0 +1,207 @@
+// Package audit records privileged actions, so that operators can see who did
+// what. The latest actions are kept in memory for querying, and every action is
+// appended to a rotated file as a JSON line.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Entry is a single audited action. It is written to the file as a JSON line.
+type Entry struct {
+	Time time.Time
+	// Actor is who performed the action: an operator's username, "player" for
+	// linked players on the web, or the name of a chat bridge.
+	Actor string
+	// Action is what was done, e.g. "chat" or "announce".
+	Action string
+	// Server is the ID of the server that the action was performed on. It is
+	// empty for actions that aren't specific to a server.
+	Server string
+	// PlayerGUID is the GUID of the player that the action was performed as or
+	// on, if any.
+	PlayerGUID string `json:",omitempty"`
+	// Detail describes the action, e.g. the message that was sent.
+	Detail string `json:",omitempty"`
+	// IP is the client's IP address.
+	IP string
+	// Error is why the action failed. It is empty if the action succeeded.
+	Error string `json:",omitempty"`
+}
+
+// OK returns true if the action succeeded.
+func (e Entry) OK() bool {
+	return e.Error == ""
+}
+
+// Log keeps the latest audited actions in memory and optionally appends every
+// action to a file.
+type Log struct {
+	// OnError is called on a write error. By default, it logs to console.
+	OnError func(error)
+
+	mutex   sync.Mutex
+	entries []Entry // ring buffer
+	next    int
+	full    bool
+	file    *rotatingFile
+}
+
+// New creates a new log that only keeps up to max entries in memory.
+func New(max int) *Log {
+	return &Log{
+		OnError: func(err error) {
+			log.Println("[audit] Write error:", err)
+		},
+		entries: make([]Entry, max),
+	}
+}
+
+// Open opens the log file at the given path, which is created if it doesn't
+// exist. Up to max of the latest entries in the current and the last rotated
+// file are loaded into memory.
+func Open(path string, opts FileOpts, max int) (*Log, error) {
+	l := New(max)
+
+	for _, path := range []string{rotatedPath(path, 1), path} {
+		if err := l.load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := openRotating(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+
+	return l, nil
+}
+
+// load loads the entries in the given file into memory. A missing file is
+// ignored, as are lines that can't be decoded, e.g. a truncated last line.
+func (l *Log) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to open audit log")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			l.push(entry)
+		}
+	}
+
+	return errors.Wrap(scanner.Err(), "failed to read audit log")
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Record records the given entry. Its time is set to now if it's zero.
+func (l *Log) Record(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.push(entry)
+
+	if l.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		l.OnError(errors.Wrap(err, "failed to encode entry"))
+		return
+	}
+
+	if err := l.file.WriteLine(line); err != nil {
+		l.OnError(err)
+	}
+}
+
+func (l *Log) push(entry Entry) {
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Filter filters the entries returned by Query. Empty fields match anything.
+type Filter struct {
+	Actor      string
+	Action     string
+	Server     string
+	PlayerGUID string
+	IP         string
+	// Failed only matches failed actions.
+	Failed bool
+	// Before only matches entries before the given time.
+	Before time.Time
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Actor == "" || f.Actor == e.Actor) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.Server == "" || f.Server == e.Server) &&
+		(f.PlayerGUID == "" || f.PlayerGUID == e.PlayerGUID) &&
+		(f.IP == "" || f.IP == e.IP) &&
+		(!f.Failed || !e.OK()) &&
+		(f.Before.IsZero() || e.Time.Before(f.Before))
+}
+
+// Query returns up to n of the latest entries in memory that match the filter,
+// newest first.
+func (l *Log) Query(f Filter, n int) []Entry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	size := l.next
+	if l.full {
+		size = len(l.entries)
+	}
+
+	var entries []Entry
+
+	for i := 0; i < size && len(entries) < n; i++ {
+		entry := l.entries[(l.next-1-i+len(l.entries))%len(l.entries)]
+		if f.match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Recent returns up to n of the latest entries, newest first.
+func (l *Log) Recent(n int) []Entry {
+	return l.Query(Filter{}, n)
+}
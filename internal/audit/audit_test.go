@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestRecent(t *testing.T) {
+	l := New(3)
+
+	if recent := l.Recent(10); len(recent) != 0 {
+		t.Fatalf("expected no entries, got %v", recent)
+	}
+
+	for i := 0; i < 5; i++ {
+		l.Record(Entry{Action: strconv.Itoa(i)})
+	}
+
+	recent := l.Recent(10)
+	if len(recent) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(recent))
+	}
+	for i, want := range []string{"4", "3", "2"} {
+		if recent[i].Action != want {
+			t.Errorf("entry %d: expected %s, got %s", i, want, recent[i].Action)
+		}
+		if recent[i].Time.IsZero() {
+			t.Errorf("entry %d has no time", i)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	opts := FileOpts{MaxSize: 300, MaxFiles: 2}
+
+	l, err := Open(path, opts, 100)
+	if err != nil {
+		t.Fatal("failed to open:", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		l.Record(Entry{Actor: "op", Action: strconv.Itoa(i), Server: "main"})
+	}
+	l.Record(Entry{Actor: "player", Action: "chat", Error: "invalid session"})
+
+	if err := l.Close(); err != nil {
+		t.Fatal("failed to close:", err)
+	}
+
+	// Each line is about 100 bytes, so only a few fit in each file, and the
+	// oldest files are removed.
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Error("expected 2 rotated files:", err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected no third rotated file, got", err)
+	}
+
+	for _, path := range []string{path, path + ".1", path + ".2"} {
+		if stat, err := os.Stat(path); err == nil && stat.Size() > opts.MaxSize {
+			t.Errorf("%s is larger than the max size: %d", path, stat.Size())
+		}
+	}
+
+	// Reopening loads the entries of the current and the last rotated file.
+	l, err = Open(path, opts, 100)
+	if err != nil {
+		t.Fatal("failed to reopen:", err)
+	}
+	defer l.Close()
+
+	recent := l.Recent(100)
+	if len(recent) < 2 || recent[0].Action != "chat" || recent[1].Action != "9" {
+		t.Fatalf("unexpected entries after reopening: %v", recent)
+	}
+
+	failed := l.Query(Filter{Failed: true}, 100)
+	if len(failed) != 1 || failed[0].Error != "invalid session" {
+		t.Errorf("unexpected failed entries: %v", failed)
+	}
+
+	before := l.Query(Filter{Actor: "op", Before: recent[1].Time}, 1)
+	if len(before) != 1 || before[0].Action != "8" {
+		t.Errorf("unexpected entries before the 9th: %v", before)
+	}
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// FileOpts is the rotation options of the log file.
+type FileOpts struct {
+	// MaxSize is the size in bytes after which the file is rotated.
+	MaxSize int64
+	// MaxFiles is the number of rotated files that are kept, named path.1 to
+	// path.N from newest to oldest.
+	MaxFiles int
+}
+
+// rotatingFile is an append-only file that is rotated once it's too large.
+type rotatingFile struct {
+	path string
+	opts FileOpts
+	file *os.File
+	size int64
+}
+
+func openRotating(path string, opts FileOpts) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit log")
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to stat audit log")
+	}
+
+	f.file = file
+	f.size = stat.Size()
+	return nil
+}
+
+// WriteLine writes the given line and a line break, rotating the file first if
+// the line doesn't fit anymore. If rotating fails, the line is still written.
+func (f *rotatingFile) WriteLine(line []byte) error {
+	var rotateErr error
+	if f.size > 0 && f.size+int64(len(line))+1 > f.opts.MaxSize {
+		rotateErr = f.rotate()
+	}
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+
+	// Write the line at once, so that it can't be interleaved with other
+	// writes.
+	n, err := f.file.Write(append(line, '\n'))
+	f.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "failed to write audit log")
+	}
+
+	return rotateErr
+}
+
+// rotate renames the current file to path.1, shifting the older files, and
+// opens a new one. The oldest file is removed.
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	if err := os.Remove(rotatedPath(f.path, f.opts.MaxFiles)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove oldest audit log")
+	}
+
+	for i := f.opts.MaxFiles - 1; i >= 1; i-- {
+		err := os.Rename(rotatedPath(f.path, i), rotatedPath(f.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to rotate audit log")
+		}
+	}
+
+	var err error
+	if f.opts.MaxFiles > 0 {
+		err = os.Rename(f.path, rotatedPath(f.path, 1))
+	} else {
+		err = os.Remove(f.path)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to rotate audit log")
+	}
+
+	return f.open()
+}
+
+// Close closes the file.
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+func rotatedPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
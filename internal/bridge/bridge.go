@@ -0,0 +1,400 @@
+// Package bridge mirrors the chat of a Distance server to a Discord-compatible
+// webhook or an IRC channel and relays messages from there back into the game.
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/internal/flood"
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/markup"
+	"github.com/lucasb-eyer/go-colorful"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxContent is Discord's limit on the length of a message.
+	maxContent = 2000
+	// maxIncoming is the maximum length of an incoming message in bytes.
+	maxIncoming = 500
+	// maxAttempts is how many times a rate limited message is posted.
+	maxAttempts = 3
+	// maxRetryAfter caps how long a rate limit is waited out.
+	maxRetryAfter = 10 * time.Second
+	// maxSent is how many relayed messages are remembered to skip their
+	// echoes.
+	maxSent = 64
+)
+
+// Each author can relay authorBurst messages at once and one more every
+// authorEvery; on IRC, so can each host. All authors relayed from a client IP
+// address, e.g. a single bot, share ipBurst and ipEvery.
+const (
+	authorBurst = 5
+	authorEvery = 2 * time.Second
+	ipBurst     = 20
+	ipEvery     = 500 * time.Millisecond
+)
+
+// AuthorColor is the color of the author's name in relayed messages.
+var AuthorColor = colorful.Color{R: 0x58 / 255.0, G: 0x65 / 255.0, B: 0xF2 / 255.0}
+
+// Opts is the configuration of a Bridge.
+type Opts struct {
+	// WebhookURL is the webhook that the chat is posted to. An empty string
+	// disables posting.
+	WebhookURL string
+	// Username is the name that the chat is posted as.
+	Username string
+	// Token authenticates incoming messages, which are sent as a Bearer token.
+	// An empty string disables incoming messages.
+	Token string
+	// Source is shown before the author of incoming messages, e.g. "Discord".
+	// It is optional.
+	Source string
+}
+
+// Bridge is a running chat bridge.
+type Bridge struct {
+	// Client is the HTTP client used to post to the webhook.
+	Client *http.Client
+	// OnError is called on a posting error. By default, it logs to console.
+	OnError func(error)
+	// OnRelay, if not nil, is called with every message relayed into the game,
+	// e.g. to audit it.
+	OnRelay func(audit.Entry)
+
+	opts   Opts
+	client *distance.Client
+	flood  *flood.Control
+
+	sentMu sync.Mutex
+	sent   []string // relayed messages whose echoes haven't been seen
+}
+
+// New creates a new bridge between the given Distance server and the webhook.
+// It does nothing until Start is called.
+func New(c *distance.Client, opts Opts) *Bridge {
+	return &Bridge{
+		Client: &http.Client{Timeout: 10 * time.Second},
+		OnError: func(err error) {
+			log.Println("[bridge] Error:", err)
+		},
+		opts:   opts,
+		client: c,
+		flood: flood.New(flood.Opts{
+			MaxLength:    maxIncoming,
+			SessionBurst: authorBurst,
+			SessionEvery: authorEvery,
+			IPBurst:      ipBurst,
+			IPEvery:      ipEvery,
+		}),
+	}
+}
+
+// Start starts posting the observer's new chat messages to the webhook in the
+// background. The returned callback stops posting; posting also stops when the
+// observer is stopped.
+func (b *Bridge) Start(obs *distance.Observer) (stop func()) {
+	if b.opts.WebhookURL == "" {
+		return func() {}
+	}
+
+	evCh, cancelEvents := obs.SubscribeEvents()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		for ev := range evCh {
+			ev, ok := ev.(distance.ChatMessageReceivedEvent)
+			if !ok || !b.shouldPost(ev.Message) {
+				continue
+			}
+
+			if err := b.post(ctx, ev.Message.Chat); err != nil && ctx.Err() == nil {
+				b.OnError(err)
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		cancelEvents()
+	}
+}
+
+// shouldPost returns true if the message should be posted to the webhook.
+func (b *Bridge) shouldPost(msg distance.ChatMessage) bool {
+	if msg.Description == "AutoServer:Tip" {
+		return false
+	}
+
+	// Don't post messages that came from the webhook's side back to it.
+	if msg.Type == distance.ServerCustomMessage && b.takeSent(msg.Chat) {
+		return false
+	}
+
+	return strings.TrimSpace(markup.RenderString(markup.PlainRenderer{}, msg.Chat)) != ""
+}
+
+// webhookMessage is the body of a Discord webhook request.
+type webhookMessage struct {
+	Content         string          `json:"content"`
+	Username        string          `json:"username,omitempty"`
+	AllowedMentions allowedMentions `json:"allowed_mentions"`
+}
+
+type allowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+// post posts the given chat markup to the webhook. Rate limited requests are
+// retried after the time that the webhook asks for.
+func (b *Bridge) post(ctx context.Context, chat string) error {
+	content := markup.RenderString(markup.MarkdownRenderer{Underline: true}, chat)
+
+	body, err := json.Marshal(webhookMessage{
+		Content:  truncate(content, maxContent),
+		Username: b.opts.Username,
+		// Chat shouldn't be able to ping anyone.
+		AllowedMentions: allowedMentions{Parse: []string{}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := b.postOnce(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt == maxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(retryAfter):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// postOnce posts the body once. retryAfter is negative if the request
+// shouldn't be retried.
+func (b *Bridge) postOnce(ctx context.Context, body []byte) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", b.opts.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return -1, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return -1, errors.Wrap(err, "failed to post to webhook")
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so that the connection is reused.
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return parseRetryAfter(resp.Header.Get("Retry-After")),
+			errors.New("webhook rate limited")
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return -1, errors.Errorf("webhook returned unexpected status %s", resp.Status)
+	}
+
+	return 0, nil
+}
+
+// parseRetryAfter parses the Retry-After header in seconds, which may be
+// fractional. It defaults to a second and is capped at maxRetryAfter.
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || secs < 0 {
+		return time.Second
+	}
+
+	d := time.Duration(secs * float64(time.Second))
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d
+}
+
+// Incoming is the body of an incoming message.
+type Incoming struct {
+	Author  string
+	Content string
+}
+
+// Error is the body of an error response.
+type Error struct {
+	Error string
+}
+
+// Handler returns the handler that relays incoming messages into the game. It
+// accepts POST requests with an Incoming body and the Bearer token. The content
+// is Markdown. Authors sending too many messages get 429 with Retry-After.
+func (b *Bridge) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeErr(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		if !b.authorized(r) {
+			writeErr(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+
+		var in Incoming
+		if err := json.NewDecoder(io.LimitReader(r.Body, 16*1024)).Decode(&in); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+
+		in.Author = strings.TrimSpace(in.Author)
+		in.Content = strings.TrimSpace(in.Content)
+
+		switch {
+		case in.Author == "" || in.Content == "":
+			writeErr(w, http.StatusBadRequest, "missing author or content")
+			return
+		case len(in.Content) > maxIncoming:
+			writeErr(w, http.StatusRequestEntityTooLarge, "content too long")
+			return
+		}
+
+		chat := b.Format(in)
+
+		var throttled *flood.ThrottledError
+		if err := b.flood.Check(in.Author, frontend.ClientIP(r), in.Content); errors.As(err, &throttled) {
+			b.relayed(r, chat, err)
+
+			retry := int(throttled.RetryAfter.Round(time.Second) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(retry))
+			writeErr(w, http.StatusTooManyRequests, "too many messages")
+			return
+		}
+
+		// Remember the message before sending it, since the observer might see
+		// it before ServerChat returns.
+		b.addSent(chat)
+
+		err := b.client.ServerChat(chat)
+		b.relayed(r, chat, err)
+
+		if err != nil {
+			b.takeSent(chat)
+			writeErr(w, http.StatusBadGateway, "failed to send message")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (b *Bridge) relayed(r *http.Request, chat string, err error) {
+	if b.OnRelay == nil {
+		return
+	}
+
+	entry := audit.Entry{
+		Actor:  "bridge",
+		Action: "server-chat",
+		Detail: chat,
+		IP:     frontend.ClientIP(r),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	b.OnRelay(entry)
+}
+
+func (b *Bridge) authorized(r *http.Request) bool {
+	if b.opts.Token == "" {
+		return false
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(b.opts.Token)) == 1
+}
+
+// Format formats the incoming message as chat markup with its author.
+func (b *Bridge) Format(in Incoming) string {
+	return formatChat(b.opts.Source, in.Author, markup.FromMarkdown(in.Content))
+}
+
+// formatChat formats a relayed message as chat markup. content is markup.
+func formatChat(source, author, content string) string {
+	var b markup.Builder
+
+	b.Color(AuthorColor, func(b *markup.Builder) {
+		if source != "" {
+			b.Text("[" + source + "] ")
+		}
+		b.Bold(func(b *markup.Builder) { b.Text(author) })
+	})
+	b.Text(": ")
+	b.Markup(content)
+
+	return b.String()
+}
+
+func (b *Bridge) addSent(chat string) {
+	b.sentMu.Lock()
+	defer b.sentMu.Unlock()
+
+	if len(b.sent) == maxSent {
+		b.sent = b.sent[1:]
+	}
+	b.sent = append(b.sent, chat)
+}
+
+// takeSent forgets the given relayed message. False is returned if it wasn't
+// relayed.
+func (b *Bridge) takeSent(chat string) bool {
+	b.sentMu.Lock()
+	defer b.sentMu.Unlock()
+
+	for i, sent := range b.sent {
+		if sent == chat {
+			b.sent = append(b.sent[:i], b.sent[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func writeErr(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(Error{msg})
+}
+
+// truncate truncates s to at most max runes.
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+
+	runes := []rune(s)
+	return string(runes[:max-1]) + "…"
+}
@@ -0,0 +1,169 @@
+package bridge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+)
+
+// webhookReceiver is a stand-in for a Discord webhook.
+type webhookReceiver struct {
+	*httptest.Server
+	messages  chan webhookMessage
+	rateLimit int // number of requests to rate limit
+}
+
+func newWebhookReceiver(t *testing.T) *webhookReceiver {
+	recv := &webhookReceiver{messages: make(chan webhookMessage, 16)}
+
+	recv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if recv.rateLimit > 0 {
+			recv.rateLimit--
+			w.Header().Set("Retry-After", "0.01")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		var msg webhookMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Error("invalid webhook body:", err)
+		}
+		recv.messages <- msg
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(recv.Close)
+
+	return recv
+}
+
+func (recv *webhookReceiver) next(t *testing.T) webhookMessage {
+	t.Helper()
+
+	select {
+	case msg := <-recv.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook message")
+		return webhookMessage{}
+	}
+}
+
+func TestBridge(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "Alice"})
+
+	c := s.NewClient()
+	obs := distance.NewObserver(c, time.Hour)
+	defer obs.Stop()
+	obs.Renew()
+
+	recv := newWebhookReceiver(t)
+	recv.rateLimit = 1
+
+	b := New(c, Opts{
+		WebhookURL: recv.URL,
+		Username:   "Distance",
+		Token:      "hunter2",
+		Source:     "Discord",
+	})
+	b.OnError = func(err error) { t.Error("bridge error:", err) }
+
+	var relayed []audit.Entry
+	b.OnRelay = func(entry audit.Entry) { relayed = append(relayed, entry) }
+	defer b.Start(obs)()
+
+	// Outgoing.
+	s.AddChat(distance.ChatMessage{
+		Sender: "a",
+		Chat:   "[b]Alice[/b]: hi *everyone* @here",
+		Type:   distance.PlayerChatMessage,
+	})
+	obs.Renew()
+
+	msg := recv.next(t)
+	if msg.Content != `**Alice**: hi \*everyone\* @here` {
+		t.Errorf("unexpected content %q", msg.Content)
+	}
+	if msg.Username != "Distance" || msg.AllowedMentions.Parse == nil {
+		t.Errorf("unexpected message %#v", msg)
+	}
+
+	// Incoming.
+	post := func(token, body string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		b.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := post("wrong", `{"Author":"Bob","Content":"hi"}`); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for wrong token, got %d", code)
+	}
+	if code := post("hunter2", `{"Author":"","Content":"hi"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for missing author, got %d", code)
+	}
+	if code := post("hunter2", `{"Author":"[b]Bob","Content":"**hello**"}`); code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", code)
+	}
+
+	chatLog := s.State().Summary.ChatLog
+	last := chatLog[len(chatLog)-1]
+	if want := "[5865F2][Discord] [b][\u200Bb]Bob[/b][-]: [b]hello[/b]"; last.Chat != want {
+		t.Errorf("unexpected relayed chat:\ngot  %q\nwant %q", last.Chat, want)
+	}
+	if len(relayed) != 1 || relayed[0].Detail != last.Chat || relayed[0].IP != "192.0.2.1" {
+		t.Errorf("unexpected relayed audit entries %#v", relayed)
+	}
+
+	// The relayed message must not be echoed back, but the next one must get
+	// through.
+	obs.Renew()
+	s.AddChat(distance.ChatMessage{Sender: "server", Chat: "bye", Type: distance.ServerCustomMessage})
+	obs.Renew()
+
+	if msg := recv.next(t); msg.Content != "bye" {
+		t.Errorf("expected only the next message, got %q", msg.Content)
+	}
+}
+
+func TestBridgeThrottle(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	b := New(s.NewClient(), Opts{Token: "hunter2"})
+
+	post := func(author string) *httptest.ResponseRecorder {
+		body := `{"Author":"` + author + `","Content":"hi"}`
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer hunter2")
+		w := httptest.NewRecorder()
+		b.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < authorBurst; i++ {
+		if w := post("Bob"); w.Code != http.StatusNoContent {
+			t.Fatalf("message %d: expected 204, got %d", i, w.Code)
+		}
+	}
+
+	w := post("Bob")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Other authors have their own limit.
+	if w := post("Carol"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for another author, got %d", w.Code)
+	}
+}
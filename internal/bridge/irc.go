@@ -0,0 +1,508 @@
+package bridge
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/internal/flood"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/markup"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxIRCLine is the maximum length of the text of a single IRC message in
+	// bytes. Lines are limited to 512 bytes including the command and the
+	// prefix that the server adds, so this leaves room for both.
+	maxIRCLine = 400
+	// ircQueue is how many messages are queued while disconnected.
+	ircQueue = 64
+	// ircSendInterval is the delay between messages, so that the server
+	// doesn't kick us for flooding.
+	ircSendInterval = 500 * time.Millisecond
+	// ircReadTimeout is how long the connection may be silent. Servers send a
+	// PING every few minutes.
+	ircReadTimeout = 5 * time.Minute
+	// ircMaxBackoff caps the delay between reconnects.
+	ircMaxBackoff = 2 * time.Minute
+)
+
+// IRCOpts is the configuration of an IRC bridge.
+type IRCOpts struct {
+	// Addr is the host:port of the IRC server.
+	Addr string
+	// TLS connects using TLS.
+	TLS bool
+	// Nick is the nickname of the bridge. An underscore is appended while it
+	// is taken.
+	Nick string
+	// Password is the server password. It is optional.
+	Password string
+	// Channel is the channel that the chat is relayed to and from.
+	Channel string
+	// Notices enables notices about players joining and leaving and level
+	// changes.
+	Notices bool
+	// Source is shown before the author of incoming messages, e.g. "IRC". It
+	// is optional.
+	Source string
+}
+
+// IRC is a chat bridge to an IRC channel. Player chat is relayed to the
+// channel, and messages in the channel are relayed into the game.
+type IRC struct {
+	// OnError is called on a connection or relaying error. By default, it logs
+	// to console.
+	OnError func(error)
+	// OnRelay, if not nil, is called with every message relayed into the game,
+	// e.g. to audit it.
+	OnRelay func(audit.Entry)
+
+	opts   IRCOpts
+	client *distance.Client
+	queue  chan ircLine
+	flood  *flood.Control
+}
+
+// ircLine is a queued message to the channel.
+type ircLine struct {
+	command string // PRIVMSG or NOTICE
+	text    string
+}
+
+// NewIRC creates a new IRC bridge for the given Distance server. It does
+// nothing until Start is called.
+func NewIRC(c *distance.Client, opts IRCOpts) *IRC {
+	return &IRC{
+		OnError: func(err error) {
+			log.Println("[irc] Error:", err)
+		},
+		opts:   opts,
+		client: c,
+		queue:  make(chan ircLine, ircQueue),
+		// Each nick and each host is limited like an author, so that
+		// changing nicks doesn't get around it.
+		flood: flood.New(flood.Opts{
+			MaxLength:    maxIncoming,
+			SessionBurst: authorBurst,
+			SessionEvery: authorEvery,
+			IPBurst:      authorBurst,
+			IPEvery:      authorEvery,
+		}),
+	}
+}
+
+// Start connects to the IRC server and starts relaying the observer's chat in
+// the background. The connection is retried with a backoff until the returned
+// callback is called.
+func (irc *IRC) Start(obs *distance.Observer) (stop func()) {
+	evCh, cancelEvents := obs.SubscribeEvents()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		for ev := range evCh {
+			irc.handle(ev)
+		}
+	}()
+
+	go irc.run(ctx)
+
+	return func() {
+		cancel()
+		cancelEvents()
+	}
+}
+
+// handle queues the messages for the given event.
+func (irc *IRC) handle(ev distance.Event) {
+	switch ev := ev.(type) {
+	case distance.ChatMessageReceivedEvent:
+		if ev.Message.Type == distance.PlayerChatMessage {
+			irc.say("PRIVMSG", renderIRC(ev.Message.Chat))
+		}
+
+	case distance.PlayerJoinedEvent:
+		if irc.opts.Notices {
+			irc.say("NOTICE", renderIRC(ev.Player.Name)+" joined the game")
+		}
+
+	case distance.PlayerLeftEvent:
+		if irc.opts.Notices {
+			irc.say("NOTICE", renderIRC(ev.Player.Name)+" left the game")
+		}
+
+	case distance.LevelChangedEvent:
+		if irc.opts.Notices {
+			irc.say("NOTICE", "Now playing "+renderIRC(ev.Level.Name)+" ("+ev.Level.GameMode+")")
+		}
+	}
+}
+
+// say queues the given text. If the queue is full, the oldest message is
+// dropped.
+func (irc *IRC) say(command, text string) {
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+
+	for _, text := range splitIRC(text, maxIRCLine) {
+		line := ircLine{command, text}
+
+		select {
+		case irc.queue <- line:
+			continue
+		default:
+		}
+
+		select {
+		case <-irc.queue:
+		default:
+		}
+		select {
+		case irc.queue <- line:
+		default:
+		}
+	}
+}
+
+// run keeps a connection to the server until ctx is done.
+func (irc *IRC) run(ctx context.Context) {
+	backoff := time.Second
+
+	for {
+		registered, err := irc.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if registered {
+			backoff = time.Second
+		}
+		irc.OnError(errors.Wrapf(err, "disconnected, reconnecting in %v", backoff))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		if backoff *= 2; backoff > ircMaxBackoff {
+			backoff = ircMaxBackoff
+		}
+	}
+}
+
+// session connects to the server and handles the connection until it breaks.
+// registered is true if the server accepted the connection.
+func (irc *IRC) session(ctx context.Context) (registered bool, err error) {
+	conn, err := irc.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Closing the connection unblocks the reader.
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	c := &ircConn{conn: conn}
+	nick := irc.opts.Nick
+	joined := false
+
+	if irc.opts.Password != "" {
+		c.send("PASS", irc.opts.Password)
+	}
+	c.send("NICK", nick)
+	c.send("USER", nick, "0", "*", "distant-front")
+
+	r := bufio.NewReader(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(ircReadTimeout))
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return registered, errors.Wrap(err, "failed to read")
+		}
+
+		msg := parseIRC(strings.TrimRight(line, "\r\n"))
+
+		switch msg.command {
+		case "PING":
+			c.send("PONG", msg.params...)
+
+		case "001": // RPL_WELCOME
+			registered = true
+			if len(msg.params) > 0 {
+				nick = msg.params[0]
+			}
+			c.send("JOIN", irc.opts.Channel)
+
+		case "433": // ERR_NICKNAMEINUSE
+			if !registered {
+				nick += "_"
+				c.send("NICK", nick)
+			}
+
+		case "JOIN":
+			if !joined && msg.nick() == nick && strings.EqualFold(msg.param(0), irc.opts.Channel) {
+				joined = true
+				go irc.write(ctx, c)
+			}
+
+		case "KICK":
+			if strings.EqualFold(msg.param(0), irc.opts.Channel) && msg.param(1) == nick {
+				c.send("JOIN", irc.opts.Channel)
+			}
+
+		case "PRIVMSG":
+			if strings.EqualFold(msg.param(0), irc.opts.Channel) {
+				irc.relay(msg.nick(), msg.host(), msg.param(1))
+			}
+
+		case "ERROR":
+			return registered, errors.Errorf("server error: %s", msg.param(0))
+		}
+	}
+}
+
+func (irc *IRC) dial(ctx context.Context) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 10 * time.Second}
+
+	if !irc.opts.TLS {
+		conn, err := d.DialContext(ctx, "tcp", irc.opts.Addr)
+		return conn, errors.Wrap(err, "failed to connect")
+	}
+
+	host, _, _ := net.SplitHostPort(irc.opts.Addr)
+	td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host}}
+
+	conn, err := td.DialContext(ctx, "tcp", irc.opts.Addr)
+	return conn, errors.Wrap(err, "failed to connect")
+}
+
+// write sends the queued messages to the channel until ctx is done or the
+// connection breaks.
+func (irc *IRC) write(ctx context.Context, c *ircConn) {
+	for {
+		select {
+		case line := <-irc.queue:
+			if err := c.send(line.command, irc.opts.Channel, line.text); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-time.After(ircSendInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// relay relays a message from the channel into the game. Messages from nicks
+// or hosts that send too many are dropped.
+func (irc *IRC) relay(nick, host, text string) {
+	action := false
+
+	if strings.HasPrefix(text, "\x01") {
+		// Only relay /me; other CTCP requests aren't chat.
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "\x01"), "\x01")
+		if !strings.HasPrefix(text, "ACTION ") {
+			return
+		}
+		text = strings.TrimPrefix(text, "ACTION ")
+		action = true
+	}
+
+	text = strings.TrimSpace(stripIRCFormatting(text))
+	if text == "" {
+		return
+	}
+	text = truncate(text, maxIncoming)
+
+	var content markup.Builder
+	if action {
+		content.Italic(func(b *markup.Builder) { b.Text(text) })
+	} else {
+		content.Text(text)
+	}
+
+	chat := formatChat(irc.opts.Source, nick, content.String())
+
+	err := irc.flood.Check(nick, host, text)
+	if err == nil {
+		err = irc.client.ServerChat(chat)
+	}
+
+	if irc.OnRelay != nil {
+		entry := audit.Entry{Actor: "irc", Action: "server-chat", Detail: chat}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		irc.OnRelay(entry)
+	}
+
+	if err != nil && !flood.Rejected(err) {
+		irc.OnError(errors.Wrap(err, "failed to relay message"))
+	}
+}
+
+// ircConn is a connection to an IRC server that is safe to write to
+// concurrently.
+type ircConn struct {
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// send sends a message with the given command and parameters. Line breaks are
+// removed from the parameters, so they can't inject commands.
+func (c *ircConn) send(command string, params ...string) error {
+	var b strings.Builder
+	b.WriteString(command)
+
+	for i, param := range params {
+		param = strings.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == 0 {
+				return -1
+			}
+			return r
+		}, param)
+
+		b.WriteByte(' ')
+		if i == len(params)-1 && (param == "" || param[0] == ':' || strings.ContainsRune(param, ' ')) {
+			b.WriteByte(':')
+		}
+		b.WriteString(param)
+	}
+
+	b.WriteString("\r\n")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.conn.Write([]byte(b.String()))
+	return errors.Wrap(err, "failed to write")
+}
+
+// ircMessage is a parsed IRC message.
+type ircMessage struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+// parseIRC parses a single IRC line without the line break. Message tags are
+// skipped.
+func parseIRC(line string) ircMessage {
+	var msg ircMessage
+
+	if strings.HasPrefix(line, "@") {
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = strings.TrimLeft(line[i+1:], " ")
+		}
+	}
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return msg
+		}
+		msg.prefix = line[1:i]
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+
+	for line != "" {
+		if line[0] == ':' && msg.command != "" {
+			msg.params = append(msg.params, line[1:])
+			break
+		}
+
+		word := line
+		line = ""
+		if i := strings.IndexByte(word, ' '); i >= 0 {
+			word, line = word[:i], strings.TrimLeft(word[i+1:], " ")
+		}
+
+		if msg.command == "" {
+			msg.command = strings.ToUpper(word)
+		} else {
+			msg.params = append(msg.params, word)
+		}
+	}
+
+	return msg
+}
+
+// nick returns the nickname in the message's prefix.
+func (msg ircMessage) nick() string {
+	if i := strings.IndexByte(msg.prefix, '!'); i >= 0 {
+		return msg.prefix[:i]
+	}
+	return msg.prefix
+}
+
+// host returns the host in the message's prefix, or the whole prefix if it
+// has none.
+func (msg ircMessage) host() string {
+	if i := strings.IndexByte(msg.prefix, '@'); i >= 0 {
+		return msg.prefix[i+1:]
+	}
+	return msg.prefix
+}
+
+// param returns the i-th parameter or an empty string.
+func (msg ircMessage) param(i int) string {
+	if i < len(msg.params) {
+		return msg.params[i]
+	}
+	return ""
+}
+
+var ircFormattingRegex = regexp.MustCompile(
+	`\x03(\d{1,2}(,\d{1,2})?)?|\x04([0-9A-Fa-f]{6}(,[0-9A-Fa-f]{6})?)?|[\x00-\x1F\x7F]`,
+)
+
+// stripIRCFormatting removes formatting codes and other control characters
+// from IRC text.
+func stripIRCFormatting(text string) string {
+	return ircFormattingRegex.ReplaceAllString(text, "")
+}
+
+// renderIRC renders chat markup for IRC.
+func renderIRC(chat string) string {
+	return markup.RenderString(markup.IRCRenderer{}, chat)
+}
+
+// splitIRC splits text into parts of at most max bytes without splitting
+// runes.
+func splitIRC(text string, max int) []string {
+	var parts []string
+
+	for len(text) > max {
+		i := max
+		for i > 0 && !utf8.RuneStart(text[i]) {
+			i--
+		}
+		parts = append(parts, text[:i])
+		text = text[i:]
+	}
+
+	return append(parts, text)
+}
@@ -0,0 +1,166 @@
+package bridge
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+)
+
+// ircServer is a stand-in IRC server that accepts a single client.
+type ircServer struct {
+	net.Listener
+	conn  net.Conn
+	lines *bufio.Reader
+}
+
+func newIRCServer(t *testing.T) *ircServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	return &ircServer{Listener: l}
+}
+
+func (s *ircServer) accept(t *testing.T) {
+	t.Helper()
+
+	conn, err := s.Accept()
+	if err != nil {
+		t.Fatal("failed to accept:", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s.conn = conn
+	s.lines = bufio.NewReader(conn)
+}
+
+// expect reads lines until one has the given prefix.
+func (s *ircServer) expect(t *testing.T, prefix string) string {
+	t.Helper()
+
+	s.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for {
+		line, err := s.lines.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read while expecting %q: %v", prefix, err)
+		}
+		if line = strings.TrimRight(line, "\r\n"); strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+}
+
+func (s *ircServer) send(t *testing.T, line string) {
+	t.Helper()
+
+	if _, err := s.conn.Write([]byte(line + "\r\n")); err != nil {
+		t.Fatal("failed to write:", err)
+	}
+}
+
+func TestIRC(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	c := s.NewClient()
+	obs := distance.NewObserver(c, time.Hour)
+	defer obs.Stop()
+	obs.Renew()
+
+	srv := newIRCServer(t)
+
+	irc := NewIRC(c, IRCOpts{
+		Addr:    srv.Addr().String(),
+		Nick:    "distance",
+		Channel: "#distance",
+		Notices: true,
+		Source:  "IRC",
+	})
+	irc.OnError = func(err error) { t.Log("irc error:", err) }
+	defer irc.Start(obs)()
+
+	srv.accept(t)
+	srv.expect(t, "NICK distance")
+	srv.expect(t, "USER distance")
+	srv.send(t, ":irc.test 433 * distance :Nickname is already in use")
+	srv.expect(t, "NICK distance_")
+	srv.send(t, ":irc.test 001 distance_ :Welcome")
+	srv.expect(t, "JOIN #distance")
+	srv.send(t, ":distance_!u@h JOIN #distance")
+
+	srv.send(t, "PING :irc.test")
+	srv.expect(t, "PONG irc.test")
+
+	// Game to IRC.
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "[00FF00]Alice[-]"})
+	s.AddChat(distance.ChatMessage{
+		Sender: "a",
+		Chat:   "[00FF00]Alice[-]: hi\r\nQUIT",
+		Type:   distance.PlayerChatMessage,
+	})
+	obs.Renew()
+
+	if line := srv.expect(t, "NOTICE"); line != "NOTICE #distance :\x0309Alice\x0f joined the game" {
+		t.Errorf("unexpected notice %q", line)
+	}
+	if line := srv.expect(t, "PRIVMSG"); line != "PRIVMSG #distance :\x0309Alice\x0f: hi  QUIT" {
+		t.Errorf("unexpected message %q", line)
+	}
+
+	// IRC to game.
+	srv.send(t, ":carol!u@h PRIVMSG #distance :hi \x02there\x02 [b]")
+	srv.send(t, ":carol!u@h PRIVMSG #distance :\x01ACTION waves\x01")
+	srv.send(t, ":carol!u@h PRIVMSG #distance :\x01VERSION\x01")
+	srv.send(t, "PING :sync")
+	srv.expect(t, "PONG sync")
+
+	chatLog := s.State().Summary.ChatLog
+	if len(chatLog) < 2 {
+		t.Fatalf("expected relayed messages, got %#v", chatLog)
+	}
+
+	want := []string{
+		"[5865F2][IRC] [b]carol[/b][-]: hi there [\u200Bb]",
+		"[5865F2][IRC] [b]carol[/b][-]: [i]waves[/i]",
+	}
+	for i, msg := range chatLog[len(chatLog)-2:] {
+		if msg.Chat != want[i] {
+			t.Errorf("unexpected relayed chat %d:\ngot  %q\nwant %q", i, msg.Chat, want[i])
+		}
+	}
+
+	// Flooding is dropped, even after changing nicks.
+	for i := 0; i < authorBurst+2; i++ {
+		srv.send(t, ":dave!u@spam PRIVMSG #distance :spam")
+	}
+	srv.send(t, ":dave_!u@spam PRIVMSG #distance :spam")
+	srv.send(t, "PING :flood")
+	srv.expect(t, "PONG flood")
+
+	var spam int
+	for _, msg := range s.State().Summary.ChatLog {
+		if strings.HasSuffix(msg.Chat, ": spam") {
+			spam++
+		}
+	}
+	if spam != authorBurst {
+		t.Errorf("expected %d relayed messages while flooding, got %d", authorBurst, spam)
+	}
+}
+
+func TestParseIRC(t *testing.T) {
+	msg := parseIRC("@time=x :nick!user@host PRIVMSG #chan :hello :world")
+
+	if msg.nick() != "nick" || msg.host() != "host" || msg.command != "PRIVMSG" ||
+		msg.param(0) != "#chan" || msg.param(1) != "hello :world" {
+		t.Errorf("unexpected message %#v", msg)
+	}
+}
@@ -0,0 +1,623 @@
+// Package config provides the typed distant-front configuration, which is
+// either loaded from a TOML file or from the legacy environment variables.
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is the whole configuration.
+type Config struct {
+	// Name is the name of the site. It is shown on the overview page when
+	// there are multiple servers.
+	Name string `toml:"name"`
+	// Listen is the address to listen on.
+	Listen string `toml:"listen"`
+	// TrustedProxies are the addresses or CIDR ranges of the reverse proxies
+	// whose X-Forwarded-For and Forwarded headers are believed. Without any,
+	// the client is whoever connects.
+	TrustedProxies []string `toml:"trusted_proxies"`
+	// ObserveFrequency is how often the servers are observed.
+	ObserveFrequency Duration `toml:"observe_frequency"`
+	// WorkshopCache is the path to the workshop image cache. An empty string
+	// disables caching.
+	WorkshopCache string `toml:"workshop_cache"`
+	// History is the path to the history databases. An empty string, the
+	// default, disables history.
+	History string `toml:"history"`
+	// Audit is the configuration of the audit log of privileged actions.
+	Audit Audit `toml:"audit"`
+
+	Retry   Retry    `toml:"retry"`
+	Chat    Chat     `toml:"chat"`
+	Markup  Markup   `toml:"markup"`
+	Cookie  Cookie   `toml:"cookie"`
+	Session Session  `toml:"session"`
+	Admin   Admin    `toml:"admin"`
+	Servers []Server `toml:"server"`
+}
+
+// Audit is the configuration of the audit log, which records chat sent from
+// the web, linking, unlinking, relayed chat and operator actions.
+type Audit struct {
+	// Path is the path to the log file, which is written as JSON lines. An
+	// empty string, the default, only keeps the latest entries in memory.
+	Path string `toml:"path"`
+	// MaxSize is the size in megabytes after which the file is rotated.
+	MaxSize int `toml:"max_size"`
+	// MaxFiles is the number of rotated files that are kept.
+	MaxFiles int `toml:"max_files"`
+}
+
+// Admin is the configuration of the admin panel at /admin.
+type Admin struct {
+	// Users are the operators that can log in. The panel is disabled if there
+	// are none.
+	Users []AdminUser `toml:"user"`
+	// SessionTTL is how long operators stay logged in.
+	SessionTTL Duration `toml:"session_ttl"`
+}
+
+// AdminUser is an operator of the admin panel.
+type AdminUser struct {
+	Name string `toml:"name"`
+	// PasswordHash is the bcrypt hash of the password, e.g. from
+	// "distant-front -hash-password".
+	PasswordHash string `toml:"password_hash"`
+}
+
+// Server is the configuration of a single Distance server.
+type Server struct {
+	// ID identifies the server in URLs. It must be lower-case alphanumeric with
+	// dashes.
+	ID string `toml:"id"`
+	// Name is the display name of the server.
+	Name string `toml:"name"`
+	// Endpoint is the URL to the server's web API.
+	Endpoint string `toml:"endpoint"`
+	// Token is the server's private token. It is optional.
+	Token string `toml:"token"`
+
+	Bridge Bridge `toml:"bridge"`
+	IRC    IRC    `toml:"irc"`
+}
+
+// Bridge is the configuration of a server's chat bridge, e.g. to Discord.
+type Bridge struct {
+	// WebhookURL is the webhook that the chat is posted to. An empty string
+	// disables posting.
+	WebhookURL string `toml:"webhook_url"`
+	// Username is the name that the chat is posted as. It defaults to the
+	// server's name.
+	Username string `toml:"username"`
+	// Token authenticates messages sent to the server's /bridge endpoint. An
+	// empty string disables the endpoint.
+	Token string `toml:"token"`
+	// Source is shown before the author of incoming messages, e.g. "Discord".
+	Source string `toml:"source"`
+}
+
+// IRC is the configuration of a server's IRC bridge.
+type IRC struct {
+	// Addr is the host:port of the IRC server. An empty string disables the
+	// bridge.
+	Addr string `toml:"addr"`
+	// TLS connects using TLS.
+	TLS bool `toml:"tls"`
+	// Nick is the nickname of the bridge.
+	Nick string `toml:"nick"`
+	// Password is the server password. It is optional.
+	Password string `toml:"password"`
+	// Channel is the channel that the chat is relayed to and from.
+	Channel string `toml:"channel"`
+	// Notices enables notices about players joining and leaving and level
+	// changes.
+	Notices bool `toml:"notices"`
+	// Source is shown before the author of relayed messages, e.g. "IRC".
+	Source string `toml:"source"`
+}
+
+// Retry is the configuration of how failed requests to the servers are retried.
+// Only idempotent requests are retried.
+type Retry struct {
+	// Attempts is the maximum number of attempts per request. 1 disables
+	// retrying.
+	Attempts int `toml:"attempts"`
+	// BaseDelay is the delay before the first retry. It is doubled on each
+	// retry and jittered.
+	BaseDelay Duration `toml:"base_delay"`
+	// MaxDelay caps the delay between retries.
+	MaxDelay Duration `toml:"max_delay"`
+}
+
+// Chat is the configuration of the flood control of chat sent from the web.
+// The limits are token buckets: up to burst messages can be sent at once, and
+// one more every every.
+type Chat struct {
+	// MaxLength is the maximum number of characters in a message, counted after
+	// it is converted to Distance markup.
+	MaxLength int `toml:"max_length"`
+	// Burst and Every limit each linked player.
+	Burst int      `toml:"burst"`
+	Every Duration `toml:"every"`
+	// IPBurst and IPEvery limit each client IP address.
+	IPBurst int      `toml:"ip_burst"`
+	IPEvery Duration `toml:"ip_every"`
+	// Duplicate is how long a player can't send the same message again. 0
+	// allows duplicates.
+	Duplicate Duration `toml:"duplicate"`
+}
+
+// Markup is the configuration of how chat markup is rendered.
+type Markup struct {
+	// Saturate is added to the saturation of every color, from -1 to 1.
+	Saturate float64 `toml:"saturate"`
+	// Value is added to the value (brightness) of every color, from -1 to 1.
+	Value float64 `toml:"value"`
+
+	Links Links `toml:"links"`
+}
+
+// Links is the configuration of how links in chat markup are rendered.
+type Links struct {
+	// Schemes is the list of allowed URL schemes. Other links are shown as
+	// text.
+	Schemes []string `toml:"schemes"`
+	// Rel is the rel attribute of links.
+	Rel string `toml:"rel"`
+	// Target is the target attribute of links, e.g. "_blank".
+	Target string `toml:"target"`
+	// Interstitial sends links through a page that shows where they lead
+	// before leaving the site.
+	Interstitial bool `toml:"interstitial"`
+}
+
+// Cookie is the configuration of the cookies set by the frontend.
+type Cookie struct {
+	// Secure marks cookies as HTTPS-only.
+	Secure bool `toml:"secure"`
+	// SameSite is either "lax", "strict" or "none".
+	SameSite string `toml:"same_site"`
+	// MaxAge is how long cookies are kept. 0 makes them session cookies.
+	MaxAge Duration `toml:"max_age"`
+}
+
+// Session is the configuration of the linked players' sessions. Their Distance
+// session tokens are kept server-side; browsers only get an opaque ID.
+type Session struct {
+	// Path is the path to the database that sessions are saved in, so that
+	// players stay linked across restarts. An empty string only keeps them in
+	// memory.
+	Path string `toml:"path"`
+	// TTL is how long players stay linked.
+	TTL Duration `toml:"ttl"`
+	// Rotate is how often the session ID is replaced with a new one.
+	Rotate Duration `toml:"rotate"`
+	// Validate is how often the session is checked against the server's
+	// links, so that revoked sessions end.
+	Validate Duration `toml:"validate"`
+}
+
+// SameSiteMode returns the http.SameSite value of SameSite. The config must be
+// valid.
+func (c Cookie) SameSiteMode() http.SameSite {
+	switch c.SameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// TrustedNets returns the parsed TrustedProxies. The config must be valid.
+func (cfg *Config) TrustedNets() []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cfg.TrustedProxies))
+	for _, proxy := range cfg.TrustedProxies {
+		if n, err := parseNet(proxy); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// parseNet parses a CIDR range or a single IP address.
+func parseNet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// Duration is a time.Duration that is written as a string, e.g. "500ms".
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Default returns the default configuration without any servers.
+func Default() Config {
+	return Config{
+		Listen:           ":8081",
+		ObserveFrequency: Duration(500 * time.Millisecond),
+		WorkshopCache:    filepath.Join(os.TempDir(), "workshopimg.cache"),
+		Audit: Audit{
+			MaxSize:  10,
+			MaxFiles: 5,
+		},
+		Retry: Retry{
+			Attempts:  3,
+			BaseDelay: Duration(100 * time.Millisecond),
+			MaxDelay:  Duration(2 * time.Second),
+		},
+		Chat: Chat{
+			MaxLength: 300,
+			Burst:     5,
+			Every:     Duration(2 * time.Second),
+			IPBurst:   10,
+			IPEvery:   Duration(time.Second),
+			Duplicate: Duration(30 * time.Second),
+		},
+		Markup: Markup{
+			Saturate: +0.8,
+			Value:    -0.2,
+			Links: Links{
+				Schemes: []string{"http", "https"},
+				Rel:     "nofollow noopener noreferrer",
+			},
+		},
+		Cookie: Cookie{
+			SameSite: "lax",
+		},
+		Session: Session{
+			TTL:      Duration(30 * 24 * time.Hour),
+			Rotate:   Duration(time.Hour),
+			Validate: Duration(time.Minute),
+		},
+		Admin: Admin{
+			SessionTTL: Duration(12 * time.Hour),
+		},
+	}
+}
+
+// Load loads the TOML configuration file at the given path on top of the
+// default configuration. The returned configuration is validated; a
+// ValidationError is returned if it is invalid.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	md, err := toml.DecodeFile(path, &cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode config")
+	}
+
+	var verr ValidationError
+	for _, key := range md.Undecoded() {
+		verr.add(key.String(), "unknown field")
+	}
+
+	verr = append(verr, cfg.validate()...)
+	if len(verr) > 0 {
+		return nil, verr
+	}
+
+	return &cfg, nil
+}
+
+// FromEnv creates the configuration from the legacy environment variables. The
+// returned configuration is validated; a ValidationError is returned if it is
+// invalid.
+//
+// If $DISTANCE_SERVERS is set to a comma-separated list of IDs, then each server
+// is configured using $DISTANCE_<ID>_ENDPOINT, $DISTANCE_<ID>_PRIVTOKEN and
+// $DISTANCE_<ID>_NAME. Otherwise, a single server is configured using
+// $DISTANCE_ENDPOINT, $DISTANCE_PRIVTOKEN and $DISTANCE_NAME.
+//
+// If $DISTANCE_ADMIN_PASSWORD_HASH is set, then the admin panel is enabled for
+// a single operator named $DISTANCE_ADMIN_USER, or "admin" if unset.
+func FromEnv() (*Config, error) {
+	cfg := Default()
+	cfg.Name = os.Getenv("DISTANCE_NAME")
+
+	var verr ValidationError
+
+	if v := os.Getenv("DISTANCE_LISTEN"); v != "" {
+		cfg.Listen = v
+	}
+	if v := os.Getenv("DISTANCE_TRUSTED_PROXIES"); v != "" {
+		for _, proxy := range strings.Split(v, ",") {
+			cfg.TrustedProxies = append(cfg.TrustedProxies, strings.TrimSpace(proxy))
+		}
+	}
+	if v := os.Getenv("DISTANCE_HISTORY"); v != "" {
+		cfg.History = v
+	}
+	if v := os.Getenv("DISTANCE_SESSIONS"); v != "" {
+		cfg.Session.Path = v
+	}
+	if v := os.Getenv("DISTANCE_AUDIT"); v != "" {
+		cfg.Audit.Path = v
+	}
+	if v := os.Getenv("DISTANCE_OBSERVEFQ"); v != "" {
+		if err := cfg.ObserveFrequency.UnmarshalText([]byte(v)); err != nil {
+			verr.add("observe_frequency", err.Error())
+		}
+	}
+
+	if hash := os.Getenv("DISTANCE_ADMIN_PASSWORD_HASH"); hash != "" {
+		name := os.Getenv("DISTANCE_ADMIN_USER")
+		if name == "" {
+			name = "admin"
+		}
+		cfg.Admin.Users = []AdminUser{{Name: name, PasswordHash: hash}}
+	}
+
+	if ids := os.Getenv("DISTANCE_SERVERS"); ids == "" {
+		cfg.Servers = []Server{{
+			ID:       "default",
+			Name:     os.Getenv("DISTANCE_NAME"),
+			Endpoint: os.Getenv("DISTANCE_ENDPOINT"),
+			Token:    os.Getenv("DISTANCE_PRIVTOKEN"),
+		}}
+	} else {
+		for _, id := range strings.Split(ids, ",") {
+			id = strings.TrimSpace(id)
+			env := "DISTANCE_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+
+			cfg.Servers = append(cfg.Servers, Server{
+				ID:       id,
+				Name:     os.Getenv(env + "NAME"),
+				Endpoint: os.Getenv(env + "ENDPOINT"),
+				Token:    os.Getenv(env + "PRIVTOKEN"),
+			})
+		}
+	}
+
+	verr = append(verr, cfg.validate()...)
+	if len(verr) > 0 {
+		return nil, verr
+	}
+
+	return &cfg, nil
+}
+
+// FieldError is an error of a single configuration field.
+type FieldError struct {
+	Field string
+	Err   string
+}
+
+func (err FieldError) Error() string {
+	return err.Field + ": " + err.Err
+}
+
+// ValidationError lists every invalid field of a configuration.
+type ValidationError []FieldError
+
+func (verr *ValidationError) add(field, err string) {
+	*verr = append(*verr, FieldError{field, err})
+}
+
+func (verr ValidationError) Error() string {
+	errs := make([]string, len(verr))
+	for i, err := range verr {
+		errs[i] = err.Error()
+	}
+	return "invalid config:\n\t" + strings.Join(errs, "\n\t")
+}
+
+var (
+	serverIDRegex = regexp.MustCompile(`^[a-z0-9-]+$`)
+	schemeRegex   = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+	ircNickRegex  = regexp.MustCompile(`^[A-Za-z_\[\]\\^{|}][A-Za-z0-9_\[\]\\^{|}-]*$`)
+	ircChanRegex  = regexp.MustCompile(`^[#&][^\x00\x07\r\n ,:]+$`)
+)
+
+func (cfg *Config) validate() ValidationError {
+	var verr ValidationError
+
+	if cfg.Listen == "" {
+		verr.add("listen", "missing address")
+	}
+
+	for i, proxy := range cfg.TrustedProxies {
+		if _, err := parseNet(proxy); err != nil {
+			verr.add(fmt.Sprintf("trusted_proxies[%d]", i), fmt.Sprintf("invalid address or CIDR %q", proxy))
+		}
+	}
+
+	if cfg.ObserveFrequency < Duration(50*time.Millisecond) {
+		verr.add("observe_frequency", "must be at least 50ms")
+	}
+
+	if cfg.Audit.MaxSize < 1 {
+		verr.add("audit.max_size", "must be at least 1")
+	}
+	if cfg.Audit.MaxFiles < 0 {
+		verr.add("audit.max_files", "must not be negative")
+	}
+
+	if cfg.Retry.Attempts < 1 {
+		verr.add("retry.attempts", "must be at least 1")
+	}
+	if cfg.Retry.BaseDelay < 0 {
+		verr.add("retry.base_delay", "must not be negative")
+	}
+	if cfg.Retry.MaxDelay < cfg.Retry.BaseDelay {
+		verr.add("retry.max_delay", "must not be less than retry.base_delay")
+	}
+
+	if cfg.Chat.MaxLength < 1 {
+		verr.add("chat.max_length", "must be at least 1")
+	}
+	if cfg.Chat.Burst < 1 {
+		verr.add("chat.burst", "must be at least 1")
+	}
+	if cfg.Chat.Every <= 0 {
+		verr.add("chat.every", "must be positive")
+	}
+	if cfg.Chat.IPBurst < 1 {
+		verr.add("chat.ip_burst", "must be at least 1")
+	}
+	if cfg.Chat.IPEvery <= 0 {
+		verr.add("chat.ip_every", "must be positive")
+	}
+	if cfg.Chat.Duplicate < 0 {
+		verr.add("chat.duplicate", "must not be negative")
+	}
+
+	if cfg.Markup.Saturate < -1 || cfg.Markup.Saturate > 1 {
+		verr.add("markup.saturate", "must be between -1 and 1")
+	}
+	if cfg.Markup.Value < -1 || cfg.Markup.Value > 1 {
+		verr.add("markup.value", "must be between -1 and 1")
+	}
+
+	if len(cfg.Markup.Links.Schemes) == 0 {
+		verr.add("markup.links.schemes", "no schemes allowed")
+	}
+	for _, scheme := range cfg.Markup.Links.Schemes {
+		if !schemeRegex.MatchString(scheme) {
+			verr.add("markup.links.schemes", fmt.Sprintf("invalid scheme %q", scheme))
+		} else if scheme == "javascript" || scheme == "data" || scheme == "vbscript" {
+			verr.add("markup.links.schemes", fmt.Sprintf("unsafe scheme %q", scheme))
+		}
+	}
+	switch cfg.Markup.Links.Target {
+	case "", "_blank", "_self", "_parent", "_top":
+	default:
+		verr.add("markup.links.target", fmt.Sprintf("unknown target %q", cfg.Markup.Links.Target))
+	}
+
+	switch cfg.Cookie.SameSite {
+	case "lax", "strict", "none":
+	default:
+		verr.add("cookie.same_site", fmt.Sprintf("unknown mode %q", cfg.Cookie.SameSite))
+	}
+	if cfg.Cookie.SameSite == "none" && !cfg.Cookie.Secure {
+		verr.add("cookie.same_site", `"none" requires cookie.secure`)
+	}
+	if cfg.Cookie.MaxAge < 0 {
+		verr.add("cookie.max_age", "must not be negative")
+	}
+
+	if cfg.Session.TTL < Duration(time.Minute) {
+		verr.add("session.ttl", "must be at least 1m")
+	}
+	if cfg.Session.Rotate < Duration(time.Minute) {
+		verr.add("session.rotate", "must be at least 1m")
+	}
+	if cfg.Session.Validate < 0 {
+		verr.add("session.validate", "must not be negative")
+	}
+
+	if cfg.Admin.SessionTTL < Duration(time.Minute) {
+		verr.add("admin.session_ttl", "must be at least 1m")
+	}
+
+	admins := make(map[string]bool, len(cfg.Admin.Users))
+
+	for i, user := range cfg.Admin.Users {
+		field := fmt.Sprintf("admin.user[%d]", i)
+
+		if user.Name == "" {
+			verr.add(field+".name", "missing name")
+		} else if admins[user.Name] {
+			verr.add(field+".name", fmt.Sprintf("duplicate name %q", user.Name))
+		}
+		admins[user.Name] = true
+
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			verr.add(field+".password_hash", "not a bcrypt hash")
+		}
+	}
+
+	if len(cfg.Servers) == 0 {
+		verr.add("server", "no servers configured")
+	}
+
+	seen := make(map[string]bool, len(cfg.Servers))
+
+	for i, server := range cfg.Servers {
+		field := fmt.Sprintf("server[%d]", i)
+
+		if !serverIDRegex.MatchString(server.ID) {
+			verr.add(field+".id", fmt.Sprintf("invalid ID %q: must match %s", server.ID, serverIDRegex))
+		} else if seen[server.ID] {
+			verr.add(field+".id", fmt.Sprintf("duplicate ID %q", server.ID))
+		}
+		seen[server.ID] = true
+
+		u, err := url.Parse(server.Endpoint)
+		switch {
+		case server.Endpoint == "":
+			verr.add(field+".endpoint", "missing endpoint")
+		case err != nil:
+			verr.add(field+".endpoint", err.Error())
+		case u.Scheme != "http" && u.Scheme != "https":
+			verr.add(field+".endpoint", "scheme must be http or https")
+		case u.Host == "":
+			verr.add(field+".endpoint", "missing host")
+		}
+
+		if server.Bridge.WebhookURL != "" {
+			u, err := url.Parse(server.Bridge.WebhookURL)
+			switch {
+			case err != nil:
+				verr.add(field+".bridge.webhook_url", err.Error())
+			case u.Scheme != "http" && u.Scheme != "https":
+				verr.add(field+".bridge.webhook_url", "scheme must be http or https")
+			case u.Host == "":
+				verr.add(field+".bridge.webhook_url", "missing host")
+			}
+		}
+		if server.Bridge.Token != "" && len(server.Bridge.Token) < 16 {
+			verr.add(field+".bridge.token", "must be at least 16 characters")
+		}
+
+		if server.IRC.Addr != "" {
+			if _, port, err := net.SplitHostPort(server.IRC.Addr); err != nil || port == "" {
+				verr.add(field+".irc.addr", fmt.Sprintf("invalid address %q: must be host:port", server.IRC.Addr))
+			}
+			if !ircNickRegex.MatchString(server.IRC.Nick) {
+				verr.add(field+".irc.nick", fmt.Sprintf("invalid nick %q", server.IRC.Nick))
+			}
+			if !ircChanRegex.MatchString(server.IRC.Channel) {
+				verr.add(field+".irc.channel", fmt.Sprintf("invalid channel %q", server.IRC.Channel))
+			}
+		}
+	}
+
+	return verr
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func writeConfig(t *testing.T, src string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+		observe_frequency = "1s"
+		trusted_proxies = ["127.0.0.1", "10.0.0.0/8"]
+
+		[cookie]
+		max_age = "1h"
+
+		[[server]]
+		id = "main"
+		endpoint = "http://localhost:23469"
+	`))
+	if err != nil {
+		t.Fatal("failed to load:", err)
+	}
+
+	if cfg.ObserveFrequency != Duration(time.Second) {
+		t.Errorf("unexpected observe_frequency %v", time.Duration(cfg.ObserveFrequency))
+	}
+	if nets := cfg.TrustedNets(); len(nets) != 2 || nets[0].String() != "127.0.0.1/32" || nets[1].String() != "10.0.0.0/8" {
+		t.Errorf("unexpected trusted_proxies %v", nets)
+	}
+	if cfg.Cookie.MaxAge != Duration(time.Hour) {
+		t.Errorf("unexpected cookie.max_age %v", time.Duration(cfg.Cookie.MaxAge))
+	}
+	if cfg.Listen != Default().Listen {
+		t.Errorf("listen not defaulted, got %q", cfg.Listen)
+	}
+	if cfg.History != "" {
+		t.Errorf("history not disabled by default, got %q", cfg.History)
+	}
+	if cfg.Audit.Path != "" {
+		t.Errorf("audit log written by default to %q", cfg.Audit.Path)
+	}
+	if len(cfg.Servers) != 1 || cfg.Servers[0].ID != "main" {
+		t.Errorf("unexpected servers %#v", cfg.Servers)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	_, err := Load(writeConfig(t, `
+		observe_frequency = "10ms"
+		trusted_proxies = ["localhost"]
+		typo = true
+
+		[markup.links]
+		schemes = ["https", "javascript"]
+
+		[cookie]
+		same_site = "none"
+
+		[[admin.user]]
+		name = "op"
+		password_hash = "hunter2"
+
+		[[server]]
+		id = "Main"
+		endpoint = "localhost:23469"
+
+		[[server]]
+		id = "b"
+		endpoint = "http://a"
+
+		[[server]]
+		id = "b"
+		endpoint = "http://b"
+
+		[server.bridge]
+		webhook_url = "discord.com/api/webhooks/1"
+
+		[server.irc]
+		addr = "irc.libera.chat:6697"
+		nick = "distance"
+		channel = "distance"
+	`))
+
+	var verr ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+
+	fields := map[string]bool{}
+	for _, err := range verr {
+		fields[err.Field] = true
+	}
+
+	for _, field := range []string{
+		"typo",
+		"observe_frequency",
+		"trusted_proxies[0]",
+		"markup.links.schemes",
+		"cookie.same_site",
+		"admin.user[0].password_hash",
+		"server[0].id",
+		"server[0].endpoint",
+		"server[2].id",
+		"server[2].bridge.webhook_url",
+		"server[2].irc.channel",
+	} {
+		if !fields[field] {
+			t.Errorf("missing error for %s in:\n%v", field, err)
+		}
+	}
+
+	if len(verr) != 11 {
+		t.Errorf("expected 11 errors, got:\n%v", err)
+	}
+}
@@ -0,0 +1,215 @@
+// Package flood provides the flood control of chat sent from the web, so that
+// a single browser can't spam the game server.
+package flood
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Opts is the options of the flood control. Each limit is a token bucket: up
+// to Burst messages can be sent at once, and one more every Every.
+type Opts struct {
+	// MaxLength is the maximum number of characters in a message.
+	MaxLength int
+	// SessionBurst and SessionEvery limit each linked player.
+	SessionBurst int
+	SessionEvery time.Duration
+	// IPBurst and IPEvery limit each client IP address, which may be shared
+	// by multiple players.
+	IPBurst int
+	IPEvery time.Duration
+	// Duplicate is how long a player can't send the same message again.
+	Duplicate time.Duration
+}
+
+var (
+	// ErrTooLong is returned if the message is longer than MaxLength.
+	ErrTooLong = errors.New("message too long")
+	// ErrDuplicate is returned if the player just sent the same message.
+	ErrDuplicate = errors.New("duplicate message")
+)
+
+// ThrottledError is returned if the player or their IP address sent too many
+// messages.
+type ThrottledError struct {
+	// RetryAfter is when the next message can be sent.
+	RetryAfter time.Duration
+}
+
+func (err *ThrottledError) Error() string {
+	return "too many messages, try again in " + err.RetryAfter.Round(time.Second).String()
+}
+
+// Rejected returns true if the error is why the flood control rejected a
+// message.
+func Rejected(err error) bool {
+	var throttled *ThrottledError
+	return errors.Is(err, ErrTooLong) || errors.Is(err, ErrDuplicate) || errors.As(err, &throttled)
+}
+
+// sweepEvery is how often idle buckets and expired messages are forgotten.
+const sweepEvery = time.Minute
+
+// Control is the flood control of a single server. It is safe to use
+// concurrently.
+type Control struct {
+	mutex    sync.Mutex
+	opts     Opts
+	sessions map[string]*bucket
+	ips      map[string]*bucket
+	last     map[string]lastMessage
+	swept    time.Time
+}
+
+type lastMessage struct {
+	text string
+	time time.Time
+}
+
+// New creates a new flood control with the given options.
+func New(opts Opts) *Control {
+	return &Control{
+		opts:     opts,
+		sessions: map[string]*bucket{},
+		ips:      map[string]*bucket{},
+		last:     map[string]lastMessage{},
+		swept:    time.Now(),
+	}
+}
+
+// SetOpts replaces the options. The current buckets are kept.
+func (c *Control) SetOpts(opts Opts) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.opts = opts
+}
+
+// MaxLength returns the maximum number of characters in a message.
+func (c *Control) MaxLength() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.opts.MaxLength
+}
+
+// Check checks if the player identified by the given key can send the message
+// from the given IP address. If they can, the message is counted against their
+// limits, and nil is returned. Sent should be called once the message is sent.
+func (c *Control) Check(key, ip, message string) error {
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.swept) >= sweepEvery {
+		c.sweep(now)
+	}
+
+	if utf8.RuneCountInString(message) > c.opts.MaxLength {
+		return ErrTooLong
+	}
+
+	if last, ok := c.last[key]; ok && now.Sub(last.time) < c.opts.Duplicate &&
+		normalize(message) == last.text {
+		return ErrDuplicate
+	}
+
+	session := getBucket(c.sessions, key, c.opts.SessionBurst, now)
+	ipBucket := getBucket(c.ips, ip, c.opts.IPBurst, now)
+
+	// Check both before taking from either, so that a throttled message
+	// doesn't count against the other limit.
+	wait := session.wait(now, c.opts.SessionBurst, c.opts.SessionEvery)
+	if ipWait := ipBucket.wait(now, c.opts.IPBurst, c.opts.IPEvery); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+
+	session.tokens--
+	ipBucket.tokens--
+
+	return nil
+}
+
+// Sent records that the player identified by the given key sent the message,
+// so that it isn't sent again.
+func (c *Control) Sent(key, message string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.last[key] = lastMessage{normalize(message), time.Now()}
+}
+
+// sweep forgets the buckets that are full again and the messages that can be
+// sent again.
+func (c *Control) sweep(now time.Time) {
+	for key, b := range c.sessions {
+		if b.refill(now, c.opts.SessionBurst, c.opts.SessionEvery) {
+			delete(c.sessions, key)
+		}
+	}
+	for ip, b := range c.ips {
+		if b.refill(now, c.opts.IPBurst, c.opts.IPEvery) {
+			delete(c.ips, ip)
+		}
+	}
+	for key, last := range c.last {
+		if now.Sub(last.time) >= c.opts.Duplicate {
+			delete(c.last, key)
+		}
+	}
+
+	c.swept = now
+}
+
+// normalize normalizes the message for duplicate detection, so that changing
+// the case or spacing doesn't get around it.
+func normalize(message string) string {
+	return strings.ToLower(strings.Join(strings.Fields(message), " "))
+}
+
+// bucket is a token bucket.
+type bucket struct {
+	tokens float64
+	time   time.Time
+}
+
+func getBucket(buckets map[string]*bucket, key string, burst int, now time.Time) *bucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), time: now}
+		buckets[key] = b
+	}
+	return b
+}
+
+// refill adds the tokens gained since the last refill. True is returned if the
+// bucket is full.
+func (b *bucket) refill(now time.Time, burst int, every time.Duration) bool {
+	if every > 0 {
+		b.tokens += float64(now.Sub(b.time)) / float64(every)
+	}
+	if b.tokens >= float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.time = now
+
+	return b.tokens == float64(burst)
+}
+
+// wait refills the bucket and returns how long until it has a token.
+func (b *bucket) wait(now time.Time, burst int, every time.Duration) time.Duration {
+	b.refill(now, burst, every)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(every))
+}
@@ -0,0 +1,50 @@
+package flood
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControl(t *testing.T) {
+	c := New(Opts{
+		MaxLength:    10,
+		SessionBurst: 2,
+		SessionEvery: time.Hour,
+		IPBurst:      3,
+		IPEvery:      time.Hour,
+		Duplicate:    time.Hour,
+	})
+
+	if err := c.Check("a", "ip", strings.Repeat("é", 11)); err != ErrTooLong {
+		t.Fatalf("expected ErrTooLong, got %v", err)
+	}
+
+	for _, msg := range []string{"1", "2"} {
+		if err := c.Check("a", "ip", msg); err != nil {
+			t.Fatalf("message %s throttled: %v", msg, err)
+		}
+		c.Sent("a", msg)
+	}
+
+	if err := c.Check("a", "ip", " 2 "); err != ErrDuplicate {
+		t.Fatalf("expected ErrDuplicate, got %v", err)
+	}
+
+	var throttled *ThrottledError
+	if err := c.Check("a", "ip", "3"); !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("expected player to be throttled, got %v", err)
+	}
+
+	// Another player on the same IP has the IP's last token.
+	if err := c.Check("b", "ip", "1"); err != nil {
+		t.Fatalf("other player throttled: %v", err)
+	}
+	if err := c.Check("c", "ip", "1"); !errors.As(err, &throttled) {
+		t.Fatalf("expected IP to be throttled, got %v", err)
+	}
+	if err := c.Check("c", "other ip", "1"); err != nil {
+		t.Fatalf("other IP throttled: %v", err)
+	}
+}
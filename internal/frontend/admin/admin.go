@@ -0,0 +1,515 @@
+// Package admin provides the operator panel. Operators log in with their own
+// credentials, separately from player linking, and can send announcements,
+// see linked sessions and player IP addresses, and see who did what.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/internal/flood"
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/session"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	login     = frontend.Templater.Register("admin-login", "admin/login.html")
+	panel     = frontend.Templater.Register("admin", "admin/admin.html")
+	auditPage = frontend.Templater.Register("admin-audit", "admin/audit.html")
+)
+
+const (
+	cookieName = "AdminSession"
+	// auditSize is how many audit entries are shown on the panel.
+	auditSize = 20
+	// auditQuerySize is the maximum number of audit entries that are queried.
+	auditQuerySize = 200
+	// sessionServer is the server of the operators' sessions. It isn't a
+	// valid server ID, so they can't be mistaken for players' sessions.
+	sessionServer = "/admin"
+)
+
+// Each client IP address can try to log in loginBurst times at once, and once
+// more every loginEvery.
+const (
+	loginBurst = 5
+	loginEvery = time.Minute
+)
+
+// NewLogins creates the flood control of login attempts. It should outlive
+// configuration reloads, so that reloading doesn't reset it.
+func NewLogins() *flood.Control {
+	return flood.New(flood.Opts{
+		SessionBurst: loginBurst,
+		SessionEvery: loginEvery,
+		IPBurst:      loginBurst,
+		IPEvery:      loginEvery,
+	})
+}
+
+// Opts is the configuration of the admin panel.
+type Opts struct {
+	SiteName string
+	// Users maps the operators' usernames to their bcrypt password hashes.
+	Users map[string]string
+	// Servers is the list of servers that can be administered.
+	Servers []frontend.RenderState
+
+	// Sessions keeps the operators' sessions. It should outlive configuration
+	// reloads, so that operators stay logged in.
+	Sessions   *session.Store
+	SessionTTL time.Duration
+	// Logins limits the login attempts of each client IP address. It is
+	// created with NewLogins.
+	Logins *flood.Control
+	Audit  *audit.Log
+	// Cookie is the cookie options. SameSite is always strict.
+	Cookie frontend.CookieOpts
+}
+
+type handler struct {
+	Opts
+}
+
+// Mount mounts the admin panel.
+func Mount(opts Opts) http.Handler {
+	h := handler{opts}
+
+	r := chi.NewRouter()
+	r.Get("/login", h.renderLogin)
+	r.Post("/login", h.postLogin)
+
+	r.Group(func(r chi.Router) {
+		r.Use(h.requireSession)
+		r.Get("/", h.renderPanel)
+		r.Get("/audit", h.renderAudit)
+		r.Get("/audit.json", h.getAudit)
+		r.Post("/logout", h.postLogout)
+		r.Post("/s/{id}/announce", h.postAnnounce)
+	})
+
+	return r
+}
+
+type sessionCtxKey struct{}
+
+// requireSession redirects to the login page unless the request has a valid
+// session. State-changing requests must also have the session's CSRF token.
+func (h handler) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := h.session(r)
+		if !ok {
+			h.writeCookie(w, "", 0)
+			http.Redirect(w, r, "/admin/login", http.StatusFound)
+			return
+		}
+
+		if r.Method != "GET" && r.Method != "HEAD" {
+			csrf := r.FormValue("csrf")
+			if subtle.ConstantTimeCompare([]byte(csrf), []byte(sess.CSRF)) != 1 {
+				w.WriteHeader(403)
+				w.Write([]byte("invalid CSRF token"))
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), sessionCtxKey{}, sess)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// session returns the request's valid session.
+func (h handler) session(r *http.Request) (session.Session, bool) {
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return session.Session{}, false
+	}
+
+	sess, ok := h.Sessions.Get(cookie.Value)
+	if !ok || sess.Server != sessionServer {
+		return session.Session{}, false
+	}
+
+	// Log out operators that were removed or whose password changed.
+	if hash, ok := h.Users[sess.User]; !ok || hash != sess.PasswordHash {
+		h.Sessions.Delete(cookie.Value)
+		return session.Session{}, false
+	}
+
+	return sess, true
+}
+
+func (h handler) writeCookie(w http.ResponseWriter, token string, ttl time.Duration) {
+	cookie := http.Cookie{
+		Name:     cookieName,
+		Value:    token,
+		Path:     "/admin",
+		HttpOnly: true,
+	}
+
+	h.Cookie.Apply(&cookie)
+	cookie.SameSite = http.SameSiteStrictMode
+
+	if token == "" {
+		cookie.Expires = time.Unix(0, 0)
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(ttl / time.Second)
+	}
+
+	http.SetCookie(w, &cookie)
+}
+
+func (h handler) record(r *http.Request, entry audit.Entry) {
+	entry.IP = frontend.ClientIP(r)
+	h.Audit.Record(entry)
+}
+
+type loginData struct {
+	frontend.RenderState
+	Error string
+}
+
+func (h handler) renderLogin(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.session(r); ok {
+		http.Redirect(w, r, "/admin", http.StatusFound)
+		return
+	}
+
+	h.executeLogin(w, "")
+}
+
+func (h handler) executeLogin(w http.ResponseWriter, errMsg string) {
+	err := login.Execute(w, loginData{
+		RenderState: frontend.RenderState{SiteName: h.SiteName},
+		Error:       errMsg,
+	})
+	if err != nil {
+		log.Println("Error rendering:", err)
+	}
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// checkPassword checks the user's password. Unknown users take as long as
+// known ones, so that usernames can't be guessed by timing.
+func (h handler) checkPassword(user, password string) (hash string, ok bool) {
+	hash, known := h.Users[user]
+	if !known {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", false
+	}
+
+	return hash, bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (h handler) postLogin(w http.ResponseWriter, r *http.Request) {
+	user := r.FormValue("user")
+	ip := frontend.ClientIP(r)
+
+	var throttled *flood.ThrottledError
+	if err := h.Logins.Check(ip, ip, ""); errors.As(err, &throttled) {
+		h.record(r, audit.Entry{Actor: user, Action: "login", Error: "throttled"})
+
+		retry := int(throttled.RetryAfter.Round(time.Second) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		w.WriteHeader(http.StatusTooManyRequests)
+		h.executeLogin(w, "too many attempts, try again in "+throttled.RetryAfter.Round(time.Second).String())
+		return
+	}
+
+	hash, ok := h.checkPassword(user, r.FormValue("password"))
+	if !ok {
+		h.record(r, audit.Entry{Actor: user, Action: "login", Error: "invalid credentials"})
+
+		w.WriteHeader(401)
+		h.executeLogin(w, "invalid username or password")
+		return
+	}
+
+	h.record(r, audit.Entry{Actor: user, Action: "login"})
+
+	token, _ := h.Sessions.Create(session.Session{
+		Server:       sessionServer,
+		User:         user,
+		PasswordHash: hash,
+		Expires:      time.Now().Add(h.SessionTTL),
+	})
+	h.writeCookie(w, token, h.SessionTTL)
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+func (h handler) postLogout(w http.ResponseWriter, r *http.Request) {
+	sess := r.Context().Value(sessionCtxKey{}).(session.Session)
+
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		h.Sessions.Delete(cookie.Value)
+	}
+
+	h.record(r, audit.Entry{Actor: sess.User, Action: "logout"})
+	h.writeCookie(w, "", 0)
+	http.Redirect(w, r, "/admin/login", http.StatusFound)
+}
+
+type panelData struct {
+	frontend.RenderState
+	User    string
+	CSRF    string
+	Servers []serverData
+	Audit   []audit.Entry
+	// Announced is the ID of the server that an announcement was just sent
+	// to.
+	Announced string
+	Error     string
+}
+
+type serverData struct {
+	ID      string
+	Name    string
+	State   distance.ObservedState
+	Players []playerData
+	Links   []linkData
+	// LinksError is why the links couldn't be fetched.
+	LinksError string
+}
+
+type playerData struct {
+	distance.Player
+	// Linked is the number of sessions linked to the player.
+	Linked int
+}
+
+type linkData struct {
+	// Session is the shortened session token, which is enough to tell
+	// sessions apart without revealing them.
+	Session string
+	GUID    string
+	// Name is the name of the player if they're on the server.
+	Name string
+}
+
+func (h handler) renderPanel(w http.ResponseWriter, r *http.Request) {
+	h.executePanel(w, r, r.FormValue("announced"), "")
+}
+
+func (h handler) executePanel(w http.ResponseWriter, r *http.Request, announced, errMsg string) {
+	sess := r.Context().Value(sessionCtxKey{}).(session.Session)
+
+	data := panelData{
+		RenderState: frontend.RenderState{SiteName: h.SiteName},
+		User:        sess.User,
+		CSRF:        sess.CSRF,
+		Servers:     make([]serverData, len(h.Servers)),
+		Audit:       h.Audit.Recent(auditSize),
+		Announced:   announced,
+		Error:       errMsg,
+	}
+
+	var wg sync.WaitGroup
+
+	for i, rs := range h.Servers {
+		wg.Add(1)
+		go func(server *serverData, rs frontend.RenderState) {
+			defer wg.Done()
+			*server = fetchServer(r.Context(), rs)
+		}(&data.Servers[i], rs)
+	}
+
+	wg.Wait()
+
+	if err := panel.Execute(w, data); err != nil {
+		log.Println("Error rendering:", err)
+	}
+}
+
+// fetchServer fetches the server's state and links.
+func fetchServer(ctx context.Context, rs frontend.RenderState) serverData {
+	server := serverData{
+		ID:    rs.ID,
+		Name:  rs.SiteName,
+		State: rs.Observer.State(),
+	}
+
+	links, err := rs.Client.WithContext(ctx).Links()
+	if err != nil {
+		server.LinksError = err.Error()
+	}
+
+	linked := map[string]int{}
+	if links != nil {
+		for token, guid := range links.Links {
+			link := linkData{Session: shortToken(token), GUID: guid}
+			if server.State.Summary != nil {
+				if player := server.State.Summary.FindPlayer(guid); player != nil {
+					link.Name = player.Name
+				}
+			}
+
+			server.Links = append(server.Links, link)
+			linked[guid]++
+		}
+
+		sort.Slice(server.Links, func(i, j int) bool {
+			if server.Links[i].GUID != server.Links[j].GUID {
+				return server.Links[i].GUID < server.Links[j].GUID
+			}
+			return server.Links[i].Session < server.Links[j].Session
+		})
+	}
+
+	if server.State.Summary != nil {
+		for _, player := range server.State.Summary.Players {
+			server.Players = append(server.Players, playerData{
+				Player: player,
+				Linked: linked[player.UnityPlayerGUID],
+			})
+		}
+	}
+
+	return server
+}
+
+func shortToken(token string) string {
+	if len(token) <= 8 {
+		return token
+	}
+	return token[:8] + "…"
+}
+
+func (h handler) postAnnounce(w http.ResponseWriter, r *http.Request) {
+	sess := r.Context().Value(sessionCtxKey{}).(session.Session)
+	id := chi.URLParam(r, "id")
+
+	var rs *frontend.RenderState
+	for i := range h.Servers {
+		if h.Servers[i].ID == id {
+			rs = &h.Servers[i]
+			break
+		}
+	}
+	if rs == nil {
+		w.WriteHeader(404)
+		w.Write([]byte("unknown server"))
+		return
+	}
+
+	// Operators are trusted, so announcements may contain Distance markup.
+	message := strings.TrimSpace(r.FormValue("m"))
+	if message == "" {
+		w.WriteHeader(400)
+		h.executePanel(w, r, "", "missing message")
+		return
+	}
+
+	entry := audit.Entry{Actor: sess.User, Action: "announce", Server: id, Detail: message}
+
+	if err := rs.Client.WithContext(r.Context()).ServerChat(message); err != nil {
+		entry.Error = err.Error()
+		h.record(r, entry)
+
+		w.WriteHeader(502)
+		h.executePanel(w, r, "", "failed to send announcement: "+err.Error())
+		return
+	}
+
+	h.record(r, entry)
+	http.Redirect(w, r, "/admin?announced="+id, http.StatusFound)
+}
+
+// parseFilter parses the audit filter from the request's query.
+func parseFilter(r *http.Request) (audit.Filter, int) {
+	filter := audit.Filter{
+		Actor:      r.FormValue("actor"),
+		Action:     r.FormValue("action"),
+		Server:     r.FormValue("server"),
+		PlayerGUID: r.FormValue("guid"),
+		IP:         r.FormValue("ip"),
+		Failed:     r.FormValue("failed") != "",
+	}
+
+	if before, err := time.Parse(time.RFC3339, r.FormValue("before")); err == nil {
+		filter.Before = before
+	}
+
+	limit, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || limit <= 0 || limit > auditQuerySize {
+		limit = auditQuerySize
+	}
+
+	return filter, limit
+}
+
+type auditData struct {
+	frontend.RenderState
+	User    string
+	CSRF    string
+	Filter  audit.Filter
+	Entries []audit.Entry
+	// Older is the URL of the next page. It is empty if there are no older
+	// entries.
+	Older template.URL
+}
+
+func (h handler) renderAudit(w http.ResponseWriter, r *http.Request) {
+	sess := r.Context().Value(sessionCtxKey{}).(session.Session)
+	filter, limit := parseFilter(r)
+
+	// Query one more to know if there are older entries.
+	entries := h.Audit.Query(filter, limit+1)
+
+	data := auditData{
+		RenderState: frontend.RenderState{SiteName: h.SiteName},
+		User:        sess.User,
+		CSRF:        sess.CSRF,
+		Filter:      filter,
+		Entries:     entries,
+	}
+
+	if len(entries) > limit {
+		data.Entries = entries[:limit]
+
+		q := r.URL.Query()
+		q.Set("before", entries[limit-1].Time.Format(time.RFC3339Nano))
+		data.Older = template.URL("/admin/audit?" + q.Encode())
+	}
+
+	if err := auditPage.Execute(w, data); err != nil {
+		log.Println("Error rendering:", err)
+	}
+}
+
+// getAudit writes the queried audit entries as JSON, newest first.
+func (h handler) getAudit(w http.ResponseWriter, r *http.Request) {
+	filter, limit := parseFilter(r)
+	entries := h.Audit.Query(filter, limit)
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		log.Println("Error encoding JSON:", err)
+	}
+}
@@ -0,0 +1,161 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/session"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAdmin(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{
+		UnityPlayerGUID: "a",
+		Name:            "Alice",
+		IPAddress:       "192.0.2.1",
+		Port:            1234,
+	})
+	s.AddLinkCode("123ABC", "a")
+
+	c := s.NewClient()
+	if _, err := c.LinkSession("123ABC"); err != nil {
+		t.Fatal("failed to link:", err)
+	}
+
+	obs := distance.NewObserver(c, time.Hour)
+	defer obs.Stop()
+	obs.Renew()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := audit.New(10)
+	h := Mount(Opts{
+		SiteName:   "Test",
+		Users:      map[string]string{"op": string(hash)},
+		Servers:    []frontend.RenderState{{Client: c, Observer: obs, ID: "main", SiteName: "Main"}},
+		Sessions:   session.NewStore(),
+		Logins:     NewLogins(),
+		SessionTTL: time.Hour,
+		Audit:      log,
+	})
+
+	var cookies []*http.Cookie
+
+	do := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if set := w.Result().Cookies(); len(set) > 0 {
+			cookies = set
+		}
+		return w
+	}
+
+	if w := do("GET", "/", nil); w.Code != http.StatusFound || w.Header().Get("Location") != "/admin/login" {
+		t.Fatalf("expected redirect to login, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	if w := do("POST", "/login", url.Values{"user": {"op"}, "password": {"wrong"}}); w.Code != 401 {
+		t.Fatalf("expected 401 for wrong password, got %d", w.Code)
+	}
+	if w := do("POST", "/login", url.Values{"user": {"op"}, "password": {"hunter2"}}); w.Code != http.StatusFound {
+		t.Fatalf("expected login, got %d", w.Code)
+	}
+	if !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Errorf("unexpected cookie %#v", cookies[0])
+	}
+
+	w := do("GET", "/", nil)
+	if w.Code != 200 {
+		t.Fatalf("expected panel, got %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{"192.0.2.1:1234", "Alice", `name="csrf"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("panel is missing %q", want)
+		}
+	}
+
+	// The full session token must never be shown.
+	for token := range s.State().Links.Links {
+		if strings.Contains(body, token) {
+			t.Errorf("panel shows session token %q", token)
+		}
+	}
+
+	if w := do("POST", "/s/main/announce", url.Values{"m": {"hi"}}); w.Code != 403 {
+		t.Fatalf("expected 403 without CSRF token, got %d", w.Code)
+	}
+
+	csrf := body[strings.Index(body, `name="csrf" value="`)+len(`name="csrf" value="`):]
+	csrf = csrf[:strings.IndexByte(csrf, '"')]
+
+	if w := do("POST", "/s/main/announce", url.Values{"m": {"[b]hi[/b]"}, "csrf": {csrf}}); w.Code != http.StatusFound {
+		t.Fatalf("expected announcement, got %d: %s", w.Code, w.Body)
+	}
+
+	chatLog := s.State().Summary.ChatLog
+	if last := chatLog[len(chatLog)-1]; last.Chat != "[b]hi[/b]" || last.Type != distance.ServerCustomMessage {
+		t.Errorf("unexpected announcement %#v", last)
+	}
+
+	var actions []string
+	for _, entry := range log.Recent(10) {
+		actions = append(actions, entry.Actor+" "+entry.Action+" "+entry.Error)
+	}
+	if got, want := strings.Join(actions, ","), "op announce ,op login ,op login invalid credentials"; got != want {
+		t.Errorf("unexpected audit log %q, want %q", got, want)
+	}
+
+	w = do("GET", "/audit.json?action=login&failed=1", nil)
+
+	var entries []audit.Entry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatal("failed to decode audit entries:", err)
+	}
+	if len(entries) != 1 || entries[0].Error != "invalid credentials" {
+		t.Errorf("unexpected queried entries %#v", entries)
+	}
+
+	if w := do("GET", "/audit?limit=1", nil); !strings.Contains(w.Body.String(), "/admin/audit?before=") {
+		t.Errorf("expected a link to older entries")
+	}
+
+	// Password guessing is throttled by IP address.
+	for i := 0; ; i++ {
+		w := do("POST", "/login", url.Values{"user": {"op"}, "password": {"guess"}})
+		if w.Code == http.StatusTooManyRequests {
+			if w.Header().Get("Retry-After") == "" {
+				t.Error("throttled login without Retry-After")
+			}
+			break
+		}
+		if i == loginBurst {
+			t.Fatalf("login not throttled after %d attempts, got %d", i+1, w.Code)
+		}
+	}
+}
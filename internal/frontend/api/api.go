@@ -0,0 +1,381 @@
+// Package api provides a versioned JSON API that mirrors the HTML pages.
+// Sensitive fields such as player IP addresses and session tokens are never
+// exposed.
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/frontend/index/link"
+	"github.com/diamondburned/distant-front/internal/history"
+	"github.com/diamondburned/distant-front/internal/httperr"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/go-chi/chi"
+)
+
+// Mount mounts the API routes. Each version is mounted under its own prefix,
+// e.g. /v1.
+func Mount(rs frontend.RenderState) http.Handler {
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(rs))
+	r.Use(link.LoadSession)
+	r.Use(allowCORS)
+	r.Mount("/v1", mountV1())
+	return r
+}
+
+func mountV1() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/status", getStatus)
+	r.Get("/summary", getSummary)
+	r.Get("/players", getPlayers)
+	r.Get("/playlist", getPlaylist)
+	r.Get("/chat", getChat)
+	r.Get("/link", getLink)
+	r.Get("/leaderboards", getLeaderboards)
+	r.Get("/leaderboards/{levelID}", getLeaderboard)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeErr(w, ErrNotFound)
+	})
+	return r
+}
+
+func allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		next.ServeHTTP(w, r)
+	})
+}
+
+var (
+	// ErrNotFound is returned for unknown routes and resources.
+	ErrNotFound = httperr.New(404, "not found")
+	// ErrUnavailable is returned if the Distance server could not be observed.
+	ErrUnavailable = httperr.New(503, "server state unavailable")
+	// ErrNoHistory is returned if history is disabled.
+	ErrNoHistory = httperr.New(404, "history is disabled")
+)
+
+// Error is the body of an error response.
+type Error struct {
+	Error string
+}
+
+func writeErr(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	httperr.WriteErr(w, err)
+	json.NewEncoder(w).Encode(Error{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error encoding JSON:", err)
+	}
+}
+
+// intQuery parses the given query parameter as an integer within [1, max]. def
+// is returned if the parameter is missing.
+func intQuery(r *http.Request, name string, def, max int) (int, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 1 || i > max {
+		return 0, httperr.New(400, "invalid "+name+": must be between 1 and "+strconv.Itoa(max))
+	}
+
+	return i, nil
+}
+
+// Status is the reachability of the server.
+type Status struct {
+	Up bool
+	// DownSince is the time that the server became unreachable. It is null if
+	// the server is up.
+	DownSince *time.Time
+	LastRenew time.Time
+}
+
+func getStatus(w http.ResponseWriter, r *http.Request) {
+	state := frontend.GetRenderState(r.Context()).Observer.State()
+
+	status := Status{
+		Up:        !state.IsDown(),
+		LastRenew: state.LastRenew,
+	}
+	if state.IsDown() {
+		status.DownSince = &state.DownSince
+	}
+
+	writeJSON(w, status)
+}
+
+// Summary is the observed server summary.
+type Summary struct {
+	Server       distance.Server
+	Level        distance.Level
+	Players      []Player
+	AutoServer   distance.AutoServer
+	VoteCommands distance.VoteCommands
+	LastRenew    time.Time
+}
+
+func getSummary(w http.ResponseWriter, r *http.Request) {
+	state := frontend.GetRenderState(r.Context()).Observer.State()
+	if state.Summary == nil {
+		writeErr(w, ErrUnavailable)
+		return
+	}
+
+	writeJSON(w, Summary{
+		Server:       state.Summary.Server,
+		Level:        state.Summary.Level,
+		Players:      NewPlayers(state.Summary.Players),
+		AutoServer:   state.Summary.AutoServer,
+		VoteCommands: state.Summary.VoteCommands,
+		LastRenew:    state.LastRenew,
+	})
+}
+
+// Player is a player without the sensitive fields, such as the IP address and
+// port.
+type Player struct {
+	UnityPlayerGUID        string `json:"UnityPlayerGuid"`
+	State                  distance.PlayerState
+	Stuck                  bool
+	LevelID                int `json:"LevelId"`
+	ReceivedInfo           bool
+	Index                  int
+	Name                   string
+	JoinedAt               float64
+	ValidatedAt            float64
+	Ready                  bool
+	Car                    distance.Car
+	LevelCompatibilityInfo distance.LevelCompatibilityInfo
+	LevelCompatibility     string
+	Valid                  bool
+}
+
+// NewPlayer returns the player without the sensitive fields.
+func NewPlayer(p distance.Player) Player {
+	return Player{
+		UnityPlayerGUID:        p.UnityPlayerGUID,
+		State:                  p.State,
+		Stuck:                  p.Stuck,
+		LevelID:                p.LevelID,
+		ReceivedInfo:           p.ReceivedInfo,
+		Index:                  p.Index,
+		Name:                   p.Name,
+		JoinedAt:               p.JoinedAt,
+		ValidatedAt:            p.ValidatedAt,
+		Ready:                  p.Ready,
+		Car:                    p.Car,
+		LevelCompatibilityInfo: p.LevelCompatibilityInfo,
+		LevelCompatibility:     p.LevelCompatibility,
+		Valid:                  p.Valid,
+	}
+}
+
+// NewPlayers returns the players without the sensitive fields.
+func NewPlayers(players []distance.Player) []Player {
+	sanitized := make([]Player, len(players))
+	for i, player := range players {
+		sanitized[i] = NewPlayer(player)
+	}
+	return sanitized
+}
+
+func getPlayers(w http.ResponseWriter, r *http.Request) {
+	state := frontend.GetRenderState(r.Context()).Observer.State()
+	if state.Summary == nil {
+		writeErr(w, ErrUnavailable)
+		return
+	}
+
+	writeJSON(w, NewPlayers(state.Summary.Players))
+}
+
+func getPlaylist(w http.ResponseWriter, r *http.Request) {
+	state := frontend.GetRenderState(r.Context()).Observer.State()
+	if state.PlaylistState == nil {
+		writeErr(w, ErrUnavailable)
+		return
+	}
+
+	writeJSON(w, state.PlaylistState)
+}
+
+// Chat is a page of chat messages.
+type Chat struct {
+	// Messages is sorted oldest first.
+	Messages []distance.ChatMessage
+	// Older is the GUID to pass as ?before= to get the older page. It is empty
+	// if there are no older messages.
+	Older string `json:",omitempty"`
+}
+
+func getChat(w http.ResponseWriter, r *http.Request) {
+	limit, err := intQuery(r, "limit", 50, 500)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	rs := frontend.GetRenderState(r.Context())
+	before := r.FormValue("before")
+
+	if rs.History == nil {
+		state := rs.Observer.State()
+		if state.Summary == nil {
+			writeErr(w, ErrUnavailable)
+			return
+		}
+
+		// Without history, we can only serve the server's chat log, which
+		// isn't paginated.
+		if before != "" {
+			writeErr(w, ErrNoHistory)
+			return
+		}
+
+		msgs := state.Summary.ChatLog
+		if len(msgs) > limit {
+			msgs = msgs[len(msgs)-limit:]
+		}
+
+		writeJSON(w, Chat{Messages: msgs})
+		return
+	}
+
+	// Fetch one more to know if there are older messages.
+	msgs, err := rs.History.ChatBefore(before, limit+1)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	chat := Chat{Messages: msgs}
+	if len(msgs) > limit {
+		chat.Messages = msgs[1:]
+		chat.Older = chat.Messages[0].GUID
+	}
+
+	writeJSON(w, chat)
+}
+
+// Link is the link status of the current session. The session token itself is
+// never included.
+type Link struct {
+	Linked bool
+	Player *Player `json:",omitempty"`
+}
+
+func getLink(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+
+	sess, ok := link.GetSession(r)
+	if !ok {
+		writeJSON(w, Link{})
+		return
+	}
+
+	state := rs.Observer.State()
+	if state.Summary == nil {
+		writeErr(w, ErrUnavailable)
+		return
+	}
+
+	status := Link{Linked: true}
+	if player := state.Summary.FindPlayer(sess.PlayerGUID); player != nil {
+		p := NewPlayer(*player)
+		status.Player = &p
+	}
+
+	writeJSON(w, status)
+}
+
+func getLeaderboards(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+	if rs.History == nil {
+		writeErr(w, ErrNoHistory)
+		return
+	}
+
+	levels, err := rs.History.Levels()
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	type level struct {
+		ID string
+		history.LevelInfo
+	}
+
+	resp := make([]level, len(levels))
+	for i, info := range levels {
+		resp[i] = level{info.ID(), info}
+	}
+
+	writeJSON(w, resp)
+}
+
+// Leaderboard is the leaderboard of a single level.
+type Leaderboard struct {
+	ID string
+	history.LevelInfo
+	Times   []history.BestTime
+	Results []history.Result
+}
+
+func getLeaderboard(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+	if rs.History == nil {
+		writeErr(w, ErrNoHistory)
+		return
+	}
+
+	limit, err := intQuery(r, "results", 5, 100)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	levelID := chi.URLParam(r, "levelID")
+
+	level, err := rs.History.Level(levelID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if level == nil {
+		writeErr(w, ErrNotFound)
+		return
+	}
+
+	resp := Leaderboard{ID: levelID, LevelInfo: *level}
+
+	resp.Times, err = rs.History.Leaderboard(levelID)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	resp.Results, err = rs.History.Results(levelID, limit)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	writeJSON(w, resp)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/session"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+)
+
+func newTestAPI(t *testing.T) (*distancetest.Server, *distance.Observer, *httptest.Server) {
+	t.Helper()
+
+	s := distancetest.NewServer("hunter2")
+	t.Cleanup(s.Close)
+
+	s.AddPlayer(distance.Player{
+		UnityPlayerGUID: "a",
+		Name:            "Alice",
+		IPAddress:       "192.0.2.1",
+		Port:            1234,
+	})
+
+	c := s.NewClient()
+	obs := distance.NewObserver(c, time.Hour)
+	t.Cleanup(obs.Stop)
+
+	u, _ := url.Parse(s.URL)
+
+	api := httptest.NewServer(Mount(frontend.RenderState{
+		Client:      c,
+		Observer:    obs,
+		Sessions:    session.NewStore(),
+		DistanceURL: u,
+		SessionOpts: session.Opts{TTL: time.Hour, Rotate: time.Hour},
+	}))
+	t.Cleanup(api.Close)
+
+	return s, obs, api
+}
+
+func get(t *testing.T, url string, cookies ...*http.Cookie) (int, string) {
+	t.Helper()
+
+	rq, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal("failed to create request:", err)
+	}
+	for _, cookie := range cookies {
+		rq.AddCookie(cookie)
+	}
+
+	r, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		t.Fatal("failed to get:", err)
+	}
+	defer r.Body.Close()
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal("failed to read body:", err)
+	}
+
+	return r.StatusCode, string(b)
+}
+
+func TestSensitiveFields(t *testing.T) {
+	_, _, api := newTestAPI(t)
+
+	for _, path := range []string{"/v1/summary", "/v1/players"} {
+		code, body := get(t, api.URL+path)
+		if code != 200 {
+			t.Fatalf("%s: unexpected status %d: %s", path, code, body)
+		}
+
+		if !strings.Contains(body, "Alice") {
+			t.Errorf("%s: player not in body: %s", path, body)
+		}
+
+		for _, sensitive := range []string{"192.0.2.1", "IpAddress", "1234"} {
+			if strings.Contains(body, sensitive) {
+				t.Errorf("%s: body contains sensitive %q: %s", path, sensitive, body)
+			}
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	s, obs, api := newTestAPI(t)
+
+	session, err := s.NewClient().Link("a")
+	if err != nil {
+		t.Fatal("failed to link:", err)
+	}
+
+	// Sessions linked before they were kept server-side still have the token
+	// in their cookie.
+	cookie := &http.Cookie{Name: "DistanceSession", Value: session}
+
+	getLink := func() Link {
+		_, body := get(t, api.URL+"/v1/link", cookie)
+		if strings.Contains(body, session) {
+			t.Fatal("body contains session token:", body)
+		}
+
+		var link Link
+		if err := json.Unmarshal([]byte(body), &link); err != nil {
+			t.Fatal("failed to decode link:", err)
+		}
+		return link
+	}
+
+	// The legacy cookie is only migrated once the link is observed.
+	if getLink().Linked {
+		t.Fatal("unexpectedly linked before renewal")
+	}
+
+	ch, cancel := obs.Subscribe()
+	defer cancel()
+
+	obs.Renew()
+	<-ch
+
+	link := getLink()
+	if !link.Linked || link.Player == nil || link.Player.Name != "Alice" {
+		t.Fatalf("unexpected link status %#v", link)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	_, _, api := newTestAPI(t)
+
+	code, body := get(t, api.URL+"/v1/leaderboards")
+	if code != 404 || !strings.Contains(body, `"Error"`) {
+		t.Fatalf("unexpected response %d: %s", code, body)
+	}
+
+	code, _ = get(t, api.URL+"/v1/nope")
+	if code != 404 {
+		t.Fatalf("unexpected status %d for unknown route", code)
+	}
+}
@@ -2,16 +2,25 @@ package frontend
 
 import (
 	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/base64"
+	"encoding/json"
 	"html/template"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/internal/flood"
+	"github.com/diamondburned/distant-front/internal/history"
+	"github.com/diamondburned/distant-front/internal/session"
 	"github.com/diamondburned/distant-front/lib/distance"
 	"github.com/diamondburned/distant-front/lib/distance/markup"
 	"github.com/diamondburned/tmplutil"
@@ -31,7 +40,7 @@ var Templater = tmplutil.Templater{
 	},
 	Functions: template.FuncMap{
 		"markup": func(input string) template.HTML {
-			return template.HTML(markup.ToHTML(input))
+			return template.HTML(markup.RenderString(MarkupRenderer, input))
 		},
 		"rgbaHex": func(rgba [4]float32) string {
 			color := colorful.FastLinearRgb(
@@ -59,17 +68,113 @@ var Templater = tmplutil.Templater{
 	},
 }
 
+// MarkupRenderer renders chat markup in templates. It should render HTML.
+var MarkupRenderer markup.Renderer = markup.HTMLRenderer{}
+
 type ctxTypes uint8
 
 const (
 	renderStateCtx ctxTypes = iota
 )
 
+// RenderState is the state of a single Distance server that pages are rendered
+// with.
 type RenderState struct {
-	Client      *distance.Client
-	Observer    *distance.Observer
+	Client   *distance.Client
+	Observer *distance.Observer
+	History  *history.DB    // optional
+	Audit    *audit.Log     // optional
+	Flood    *flood.Control // optional
+	Pages    *PageCache     // optional
+	// ChatListeners counts the live chat streams. It is optional.
+	ChatListeners Gauge
+	// Trails keeps the recent positions of the cars for the track map. It is
+	// optional.
+	Trails Trails
+	// Sessions keeps the linked players' sessions. It is required by the
+	// index routes.
+	Sessions *session.Store
+
+	// ID uniquely identifies the server among all served servers.
+	ID string
+	// Prefix is the URL path prefix that the server's routes are mounted under,
+	// e.g. "/s/main". It is empty if the server is mounted at the root.
+	Prefix string
+
 	SiteName    string
 	DistanceURL *url.URL
+	Cookie      CookieOpts
+	SessionOpts session.Opts
+
+	// CSRF is the token that forms in the current request's page must be sent
+	// with, in the "csrf" field. It is set by the index routes.
+	CSRF string
+
+	// state is the observed state that a cached page is rendered from.
+	state *distance.ObservedState
+}
+
+// State returns the observed state to render. It is the Observer's current
+// state, unless a cached page is being rendered, in which case it is the state
+// that the page's tag is of.
+func (rs RenderState) State() distance.ObservedState {
+	if rs.state != nil {
+		return *rs.state
+	}
+	return rs.Observer.State()
+}
+
+// Gauge counts something that goes up and down, like a Prometheus gauge.
+type Gauge interface {
+	Inc()
+	Dec()
+}
+
+// Trails keeps the recent positions of each car on the current level.
+type Trails interface {
+	// Trail returns the recent map positions of the car of the player with
+	// the given GUID, oldest first.
+	Trail(guid string) [][2]float64
+}
+
+// CookieOpts is the options for the cookies set by the frontend.
+type CookieOpts struct {
+	Secure   bool
+	SameSite http.SameSite
+	// MaxAge is how long cookies are kept. 0 makes them session cookies.
+	MaxAge time.Duration
+}
+
+// Apply applies the options to the given cookie.
+func (opts CookieOpts) Apply(cookie *http.Cookie) {
+	cookie.Secure = opts.Secure
+	cookie.SameSite = opts.SameSite
+
+	if opts.MaxAge > 0 {
+		cookie.MaxAge = int(opts.MaxAge / time.Second)
+	}
+}
+
+// Record records the given privileged action on the server in the audit log,
+// if any. The server and client IP are filled in.
+func (rs RenderState) Record(r *http.Request, entry audit.Entry) {
+	if rs.Audit == nil {
+		return
+	}
+
+	entry.Server = rs.ID
+	entry.IP = ClientIP(r)
+	rs.Audit.Record(entry)
+}
+
+// ClientIP returns the IP address of the request's client. Behind a trusted
+// proxy, TrustProxies makes it the address that the proxy forwarded.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 // InjectRenderState injects the render state.
@@ -92,6 +197,24 @@ func GetRenderState(ctx context.Context) RenderState {
 	return renderState
 }
 
+// StateTag returns a weak ETag of the parts of the observed state that pages
+// show: the summary, the playlist and whether the server is down. It stays the
+// same between observer ticks that change nothing.
+func StateTag(state distance.ObservedState) string {
+	b, err := json.Marshal(struct {
+		Summary       *distance.Summary
+		PlaylistState *distance.PlaylistState
+		DownSince     time.Time
+	}{state.Summary, state.PlaylistState, state.DownSince})
+	if err != nil {
+		// Never match, so that the page is always rendered.
+		return ""
+	}
+
+	sum := sha256.Sum256(b)
+	return `W/"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+}
+
 // ExecuteTemplate executes the template with the RenderState.
 func ExecuteTemplate(w http.ResponseWriter, r *http.Request, sub *tmplutil.Subtemplate) {
 	if err := sub.Execute(w, GetRenderState(r.Context())); err != nil {
@@ -1,15 +1,23 @@
 package chat
 
 import (
+	"encoding/json"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/internal/flood"
 	"github.com/diamondburned/distant-front/internal/frontend"
 	"github.com/diamondburned/distant-front/internal/frontend/index/link"
 	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/markup"
 	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
 )
 
 var chat = frontend.Templater.Register("chat", "index/chat/chat.html")
@@ -28,83 +36,364 @@ func Mount() http.Handler {
 	r.Post("/", sendMessage)
 
 	r.Post("/unlink", unlinkSession)
+	r.Get("/listen", listen)
 	r.Get("/listen/{afterID}", listen)
+	r.Get("/history/{beforeID}", listHistory)
 
 	return r
 }
 
 func unlinkSession(w http.ResponseWriter, r *http.Request) {
-	link.ClearDistanceSession(w)
-	http.Redirect(w, r, "/chat", http.StatusFound)
+	rs := frontend.GetRenderState(r.Context())
+
+	if sess, ok := link.GetSession(r); ok {
+		rs.Record(r, audit.Entry{Actor: "player", Action: "unlink", PlayerGUID: sess.PlayerGUID})
+	}
+
+	link.ClearSession(w, r)
+	http.Redirect(w, r, rs.Prefix+"/chat", http.StatusFound)
 }
 
 func sendMessage(w http.ResponseWriter, r *http.Request) {
-	session := link.GetDistanceSession(r)
-	if session == "" {
+	rs := frontend.GetRenderState(r.Context())
+
+	switch err := Send(r, r.FormValue("m")); {
+	case err == nil:
+		http.Redirect(w, r, rs.Prefix+"/chat", http.StatusFound)
+	case errors.Is(err, ErrNotLinked):
 		w.WriteHeader(401)
 		io.WriteString(w, "action not permitted: missing session")
-		return
+	case flood.Rejected(err):
+		rejectMessage(w, r, rs, err)
+	default:
+		w.WriteHeader(500)
+		io.WriteString(w, err.Error())
+	}
+}
+
+// ErrNotLinked is returned by Send if the player isn't linked.
+var ErrNotLinked = errors.New("not linked")
+
+// Send sends the given message, written in Markdown, as the linked player of
+// the request, unless the flood control rejects it. Sent messages are recorded
+// in the audit log.
+func Send(r *http.Request, input string) error {
+	sess, ok := link.GetSession(r)
+	if !ok {
+		return ErrNotLinked
 	}
 
-	message := r.FormValue("m")
 	rs := frontend.GetRenderState(r.Context())
 
-	if err := rs.Client.Chat(session, message); err != nil {
-		w.WriteHeader(500)
-		io.WriteString(w, "failed to send message: "+err.Error())
+	// Limit players rather than sessions where possible, since a player can
+	// link multiple times.
+	floodKey := sess.PlayerGUID
+	if floodKey == "" {
+		floodKey = sess.Token
+	}
+
+	// Web messages are written in Markdown. Converting them also escapes any
+	// Distance markup, so web users can't spoof colors. The length limit
+	// applies to what's sent into the game, escapes included.
+	message := markup.FromMarkdown(input)
+
+	if rs.Flood != nil {
+		if err := rs.Flood.Check(floodKey, frontend.ClientIP(r), message); err != nil {
+			return err
+		}
+	}
+
+	entry := audit.Entry{
+		Actor:      "player",
+		Action:     "chat",
+		PlayerGUID: sess.PlayerGUID,
+		Detail:     message,
+	}
+
+	if err := rs.Client.Chat(sess.Token, message); err != nil {
+		entry.Error = err.Error()
+		rs.Record(r, entry)
+		return errors.Wrap(err, "failed to send message")
+	}
+
+	if rs.Flood != nil {
+		rs.Flood.Sent(floodKey, message)
+	}
+
+	rs.Record(r, entry)
+	return nil
+}
+
+// rejections maps the reasons that messages are rejected for in the chat URL
+// to what is shown to the player.
+var rejections = map[string]string{
+	"throttled": "You're sending messages too fast. Wait a moment and try again.",
+	"too-long":  "Your message is too long.",
+	"duplicate": "You just sent that message.",
+}
+
+// rejectMessage tells the player why their message was rejected by the flood
+// control. The chat script asks for the reason as text, while forms are
+// redirected back to the chat, which shows the reason.
+func rejectMessage(w http.ResponseWriter, r *http.Request, rs frontend.RenderState, err error) {
+	var throttled *flood.ThrottledError
+	var code int
+	var reason string
+
+	switch {
+	case errors.As(err, &throttled):
+		retry := int(math.Ceil(throttled.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		code, reason = http.StatusTooManyRequests, "throttled"
+	case errors.Is(err, flood.ErrTooLong):
+		code, reason = http.StatusRequestEntityTooLarge, "too-long"
+	default:
+		code, reason = http.StatusConflict, "duplicate"
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		w.WriteHeader(code)
+		io.WriteString(w, err.Error())
 		return
 	}
 
-	http.Redirect(w, r, "/chat", http.StatusFound)
+	http.Redirect(w, r, rs.Prefix+"/chat?rejected="+reason, http.StatusSeeOther)
 }
 
+// pageSize is the number of messages in a page of chat history.
+const pageSize = 50
+
 type renderData struct {
 	frontend.RenderState
+	// Messages is the page of messages to render, oldest first.
+	Messages []distance.ChatMessage
+	// Before is the GUID that the page is paginated before. It is empty for
+	// the latest page.
+	Before string
+	// Older is the GUID to paginate older messages from. It is empty if there
+	// are no older messages.
+	Older    string
 	IsLinked bool
+	// Rejected is why the player's last message was rejected, if it was.
+	Rejected string
 }
 
 func render(w http.ResponseWriter, r *http.Request) {
+	_, linked := link.GetSession(r)
+
 	data := renderData{
 		RenderState: frontend.GetRenderState(r.Context()),
-		IsLinked:    link.GetDistanceSession(r) != "",
+		Before:      r.FormValue("before"),
+		IsLinked:    linked,
+		Rejected:    rejections[r.FormValue("rejected")],
 	}
 
+	msgs, older, err := chatPage(data.RenderState, data.Before)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get chat history: "+err.Error())
+		return
+	}
+
+	data.Messages = msgs
+	data.Older = older
+
 	if err := chat.Execute(w, data); err != nil {
 		log.Println("Error rendering:", err)
 	}
 }
 
-const (
-	// MagicExpire is the magical expired HTML string.
-	MagicExpire = "<!-- SESSION EXPIRED -->"
-	// MagicHalted is written when the server is halted.
-	MagicHalted = "<!-- SERVER HALTED -->"
-)
+// chatPage returns a page of messages before the given GUID, oldest first, as
+// well as the GUID to paginate older messages from. If there is no history,
+// then the server's chat log is used, and there are no older messages.
+func chatPage(rs frontend.RenderState, before string) ([]distance.ChatMessage, string, error) {
+	if rs.History == nil {
+		state := rs.Observer.State()
+		if before != "" || state.Summary == nil {
+			return nil, "", nil
+		}
+		return state.Summary.ChatLog, "", nil
+	}
+
+	// Fetch one more to know if there are older messages.
+	msgs, err := rs.History.ChatBefore(before, pageSize+1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var older string
+	if len(msgs) > pageSize {
+		msgs = msgs[1:]
+		older = msgs[0].GUID
+	}
+
+	return msgs, older, nil
+}
+
+// HistoryMessage is a message listed by the history endpoint.
+type HistoryMessage struct {
+	Message distance.ChatMessage
+	// HTML is the message rendered like on the chat page.
+	HTML string
+}
+
+// listHistory writes the messages older than the given ID as a JSON array of
+// HistoryMessage, newest first. The array is empty if there are no older
+// messages.
+func listHistory(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
 
+	msgs, _, err := chatPage(rs, chi.URLParam(r, "beforeID"))
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get chat history: "+err.Error())
+		return
+	}
+
+	list := make([]HistoryMessage, 0, len(msgs))
+
+	for i := len(msgs) - 1; i >= 0; i-- {
+		var html strings.Builder
+		frontend.Templater.Execute(&html, "chat-message", msgs[i])
+		list = append(list, HistoryMessage{Message: msgs[i], HTML: html.String()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// heartbeatEvery is how often a comment is written to an idle listen stream,
+// so that proxies don't time it out.
+const heartbeatEvery = 15 * time.Second
+
+// maxResumed is the maximum number of messages caught up on from the history
+// when resuming the listen stream.
+const maxResumed = 1000
+
+// reconnectAfter is how long clients wait before reconnecting to the listen
+// stream, in milliseconds.
+const reconnectAfter = 5000
+
+// PlayerEvent is the data of the player event of the listen stream.
+type PlayerEvent struct {
+	// Event is either "joined" or "left".
+	Event string
+	GUID  string
+	Name  string
+}
+
+// listen streams the chat as Server-Sent Events. The events are:
+//
+//   - message: a chat message, with its GUID as the ID. The data is the
+//     rendered HTML, or the JSON message if the format query is "json".
+//   - player: a player joined or left, as a JSON PlayerEvent.
+//   - level: the level changed, as the JSON level.
+//   - expired: the linked player left the server. The stream ends, and the
+//     client should not reconnect.
+//   - halted: the server stopped being observed. The stream ends, and the
+//     client should reconnect.
+//
+// The stream starts after the message with the Last-Event-ID, or the afterID
+// in the URL if none. If that message is no longer in the server's chat log,
+// the stream catches up from the history if there is one; otherwise, the
+// whole chat log is sent.
 func listen(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	id := chi.URLParam(r, "afterID")
+	id := r.Header.Get("Last-Event-ID")
+	if id == "" {
+		id = chi.URLParam(r, "afterID")
+	}
+
+	asJSON := r.FormValue("format") == "json"
 	rs := frontend.GetRenderState(r.Context())
 
-	// Try and fetch the player's GUID so we can keep track of it.
-	var playerGUID string
+	// Keep track of the linked player, if any, so that the stream can be
+	// expired once they leave.
+	sess, _ := link.GetSession(r)
+	playerGUID := sess.PlayerGUID
+
+	if rs.ChatListeners != nil {
+		rs.ChatListeners.Inc()
+		defer rs.ChatListeners.Dec()
+	}
+
+	ew := newEventWriter(w)
+	ew.retry(reconnectAfter)
 
-	if session := link.GetDistanceSession(r); session != "" {
-		g, err := rs.Client.PlayerGUID(session)
+	// Subscribe before catching up, so no messages are lost in between.
+	evCh, cancel := rs.Observer.SubscribeEvents()
+	defer cancel()
+
+	writeMessage := func(msg distance.ChatMessage) {
+		var data strings.Builder
+
+		if asJSON {
+			json.NewEncoder(&data).Encode(msg)
+		} else {
+			frontend.Templater.Execute(&data, "chat-message", msg)
+		}
+
+		ew.event("message", msg.GUID, strings.TrimSpace(data.String()))
+	}
+
+	writeJSON := func(name string, v interface{}) {
+		b, err := json.Marshal(v)
 		if err != nil {
-			w.WriteHeader(401)
-			io.WriteString(w, "invalid session token")
+			log.Println("Error encoding event:", err)
 			return
 		}
-		playerGUID = g
+		ew.event(name, "", string(b))
 	}
 
-	flusher, canFlush := w.(http.Flusher)
+	// Keep track of the messages sent while catching up, since the events
+	// might contain them again.
+	sent := map[string]struct{}{}
+
+	if state := rs.Observer.State(); state.Summary != nil {
+		chatLog := state.Summary.ChatLog
+		start := lookBackwards(chatLog, id)
+
+		// If the message dropped out of the server's chat log, catch up from
+		// the history, then continue with the chat log after the last message
+		// caught up on.
+		var since time.Time
+		if start == 0 && id != "" && rs.History != nil {
+			msgs, err := rs.History.ChatSince(id, maxResumed)
+			if err != nil {
+				log.Println("Error resuming from history:", err)
+			}
 
-	evCh, cancel := rs.Observer.Subscribe()
-	defer cancel()
+			for i, msg := range msgs {
+				// The first message is the one that the client already has.
+				if i > 0 {
+					writeMessage(msg)
+				}
+				sent[msg.GUID] = struct{}{}
+				since = msg.Time()
+			}
+		}
+
+		for _, msg := range chatLog[start:] {
+			if _, dup := sent[msg.GUID]; dup || msg.Time().Before(since) {
+				continue
+			}
+			writeMessage(msg)
+			sent[msg.GUID] = struct{}{}
+		}
+
+		// Confirm that the player is still on the server.
+		if playerGUID != "" && state.Summary.FindPlayer(playerGUID) == nil {
+			ew.event("expired", "", "player left")
+			return
+		}
+	}
+
+	ew.flush()
+
+	heartbeat := time.NewTicker(heartbeatEvery)
+	defer heartbeat.Stop()
 
 	for {
 		select {
@@ -112,53 +401,44 @@ func listen(w http.ResponseWriter, r *http.Request) {
 			// Request cancelled; bail with OK.
 			return
 
-		case _, ok := <-evCh:
+		case <-heartbeat.C:
+			ew.comment("heartbeat")
+
+		case ev, ok := <-evCh:
 			if !ok {
-				// Observer is halted; bail with error.
-				io.WriteString(w, MagicHalted)
+				// Observer is halted.
+				ew.event("halted", "", "server halted")
 				return
 			}
 
-			// Get the latest event manually in case our event loop is lagged
-			// behind.
-			ev := rs.Observer.State()
+			switch ev := ev.(type) {
+			case distance.ChatMessageReceivedEvent:
+				if _, dup := sent[ev.Message.GUID]; dup {
+					continue
+				}
+				writeMessage(ev.Message)
 
-			// If there are no messages for some reason, then keep waiting.
-			if len(ev.Summary.ChatLog) == 0 {
-				continue
-			}
-			// If the last message is still the currently waiting message, then
-			// keep waiting. Else, immediately update the latest ID.
-			if ev.Summary.ChatLog[len(ev.Summary.ChatLog)-1].GUID == id {
-				continue
-			}
+			case distance.PlayerJoinedEvent:
+				writeJSON("player", PlayerEvent{"joined", ev.Player.UnityPlayerGUID, ev.Player.Name})
 
-			// Find the index to send.
-			ix := lookBackwards(ev.Summary.ChatLog, id)
-			for i := len(ev.Summary.ChatLog) - 1; i != ix; i-- {
-				frontend.Templater.Execute(w, "chat-message", ev.Summary.ChatLog[i])
-				// Delimit using a NULL byte. By using a proper delimiter, we
-				// don't need to worry about properly handling HTTP flushes.
-				w.Write([]byte{0})
-			}
+			case distance.PlayerLeftEvent:
+				writeJSON("player", PlayerEvent{"left", ev.Player.UnityPlayerGUID, ev.Player.Name})
 
-			// Update the latest ID.
-			id = ev.Summary.ChatLog[len(ev.Summary.ChatLog)-1].GUID
+				// Drop as soon as the linked player leaves.
+				if playerGUID != "" && ev.Player.UnityPlayerGUID == playerGUID {
+					ew.event("expired", "", "player left")
+					return
+				}
 
-			// Optionally flush the events over.
-			if canFlush {
-				flusher.Flush()
-			}
+			case distance.LevelChangedEvent:
+				writeJSON("level", ev.Level)
 
-			// Confirm that the player still has the same GUID. Drop as soon as
-			// that is false.
-			if playerGUID != "" && ev.Summary.FindPlayer(playerGUID) == nil {
-				// Write a special constant to trigger the frontend and bail.
-				io.WriteString(w, MagicExpire)
-				w.Write([]byte{0})
-				return
+			default:
+				continue
 			}
 		}
+
+		ew.flush()
 	}
 }
 
@@ -174,12 +454,12 @@ func reverseMessages(msgs []distance.ChatMessage) []distance.ChatMessage {
 }
 
 // lookBackwards looks backwards in the given slice for the message with the
-// given ID. The returned integer is the index of that message; 0 is returned
-// if nothing is found.
+// given ID. The returned integer is the index right after that message; 0 is
+// returned if nothing is found.
 func lookBackwards(msgs []distance.ChatMessage, id string) int {
 	for i := len(msgs) - 1; i >= 0; i-- {
 		if msgs[i].GUID == id {
-			return i
+			return i + 1
 		}
 	}
 	return 0
@@ -0,0 +1,211 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/history"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+	"github.com/go-chi/chi"
+)
+
+func TestListen(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	first := s.AddChat(distance.ChatMessage{Chat: "first", Type: distance.ServerCustomMessage})
+	s.AddChat(distance.ChatMessage{Chat: "second\nline", Type: distance.ServerCustomMessage})
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+	obs.Renew()
+
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(frontend.RenderState{Client: s.NewClient(), Observer: obs}))
+	r.Mount("/chat", Mount())
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	// readEvent reads the lines of the next event, skipping the retry field.
+	readEvent := func(t *testing.T, lastEventID, query string) []string {
+		t.Helper()
+
+		rq, _ := http.NewRequest("GET", srv.URL+"/chat/listen"+query, nil)
+		if lastEventID != "" {
+			rq.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(rq)
+		if err != nil {
+			t.Fatal("failed to listen:", err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("unexpected Content-Type %q", ct)
+		}
+
+		var lines []string
+		scanner := bufio.NewScanner(resp.Body)
+
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "" && len(lines) > 0:
+				return lines
+			case line != "" && !strings.HasPrefix(line, "retry: "):
+				lines = append(lines, line)
+			}
+		}
+
+		t.Fatal("stream ended early:", scanner.Err())
+		return nil
+	}
+
+	event := readEvent(t, "", "")
+	if event[0] != "id: "+first.GUID || event[1] != "event: message" ||
+		!strings.Contains(strings.Join(event, "\n"), "first") {
+		t.Errorf("unexpected first event %q", event)
+	}
+
+	// Resuming after the first message sends the second one, with each line
+	// of the message in its own data field.
+	event = readEvent(t, first.GUID, "?format=json")
+	if len(event) != 3 || event[1] != "event: message" || !strings.HasPrefix(event[2], `data: {"Sender"`) ||
+		!strings.Contains(event[2], `"second\nline"`) {
+		t.Errorf("unexpected resumed event %q", event)
+	}
+}
+
+func TestListenResumeFromHistory(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	live := s.AddChat(distance.ChatMessage{Chat: "live", Type: distance.ServerCustomMessage})
+
+	db, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal("failed to open history:", err)
+	}
+	defer db.Close()
+
+	// These messages dropped out of the server's chat log.
+	old := []distance.ChatMessage{
+		{GUID: "old-1", Timestamp: 1600000000, Chat: "first", Type: distance.ServerCustomMessage},
+		{GUID: "old-2", Timestamp: 1600000001, Chat: "second", Type: distance.ServerCustomMessage},
+	}
+	if err := db.AddChat(append(old, live)...); err != nil {
+		t.Fatal("failed to add chat:", err)
+	}
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(frontend.RenderState{
+		Client:   s.NewClient(),
+		Observer: obs,
+		History:  db,
+	}))
+	r.Mount("/chat", Mount())
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	rq, _ := http.NewRequest("GET", srv.URL+"/chat/listen", nil)
+	rq.Header.Set("Last-Event-ID", "old-1")
+
+	resp, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	defer resp.Body.Close()
+
+	// The missed message comes from the history, then the chat log continues
+	// without repeating it.
+	var ids []string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(ids) < 2 && scanner.Scan() {
+		if id := strings.TrimPrefix(scanner.Text(), "id: "); id != scanner.Text() {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) != 2 || ids[0] != "old-2" || ids[1] != live.GUID {
+		t.Fatalf("unexpected resumed message IDs %q", ids)
+	}
+}
+
+func TestListHistory(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	db, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal("failed to open history:", err)
+	}
+	defer db.Close()
+
+	msgs := []distance.ChatMessage{
+		{GUID: "old-1", Timestamp: 1600000000, Chat: "first", Type: distance.ServerCustomMessage},
+		{GUID: "old-2", Timestamp: 1600000001, Chat: "<second>", Type: distance.ServerCustomMessage},
+		{GUID: "old-3", Timestamp: 1600000002, Chat: "third", Type: distance.ServerCustomMessage},
+	}
+	if err := db.AddChat(msgs...); err != nil {
+		t.Fatal("failed to add chat:", err)
+	}
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(frontend.RenderState{
+		Client:   s.NewClient(),
+		Observer: obs,
+		History:  db,
+	}))
+	r.Mount("/chat", Mount())
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	list := func(t *testing.T, beforeID string) []HistoryMessage {
+		t.Helper()
+
+		resp, err := http.Get(srv.URL + "/chat/history/" + beforeID)
+		if err != nil {
+			t.Fatal("failed to get history:", err)
+		}
+		defer resp.Body.Close()
+
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("unexpected Content-Type %q", ct)
+		}
+
+		var list []HistoryMessage
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatal("failed to decode history:", err)
+		}
+		return list
+	}
+
+	older := list(t, "old-3")
+	if len(older) != 2 || older[0].Message.GUID != "old-2" || older[1].Message.GUID != "old-1" {
+		t.Fatalf("unexpected older messages %+v", older)
+	}
+	if !strings.Contains(older[0].HTML, "&lt;second&gt;") {
+		t.Errorf("unexpected HTML %q", older[0].HTML)
+	}
+
+	if older := list(t, "old-1"); older == nil || len(older) != 0 {
+		t.Errorf("expected an empty list, got %+v", older)
+	}
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// eventWriter writes Server-Sent Events.
+type eventWriter struct {
+	w       io.Writer
+	flusher http.Flusher // optional
+}
+
+func newEventWriter(w http.ResponseWriter) eventWriter {
+	flusher, _ := w.(http.Flusher)
+	return eventWriter{w, flusher}
+}
+
+// lineBreaks normalizes line breaks, since every line of the data is written as
+// its own field.
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// event writes an event with the given name and data. The ID is omitted if
+// it's empty or can't be written, in which case the client keeps the last one.
+// The data must not be empty, since clients don't dispatch events without data.
+func (ew eventWriter) event(name, id, data string) {
+	var b strings.Builder
+
+	if id != "" && !strings.ContainsAny(id, "\r\n\x00") {
+		b.WriteString("id: " + id + "\n")
+	}
+	b.WriteString("event: " + name + "\n")
+
+	for _, line := range strings.Split(lineBreaks.Replace(data), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteByte('\n')
+
+	io.WriteString(ew.w, b.String())
+}
+
+// retry tells the client how long to wait before reconnecting, in
+// milliseconds.
+func (ew eventWriter) retry(ms int) {
+	io.WriteString(ew.w, "retry: "+strconv.Itoa(ms)+"\n\n")
+}
+
+// comment writes a comment, which clients ignore. It keeps the connection
+// from timing out.
+func (ew eventWriter) comment(text string) {
+	io.WriteString(ew.w, ": "+text+"\n\n")
+}
+
+// flush flushes the written events to the client, if possible.
+func (ew eventWriter) flush() {
+	if ew.flusher != nil {
+		ew.flusher.Flush()
+	}
+}
@@ -3,10 +3,15 @@ package index
 import (
 	"net/http"
 	"sort"
+	"strings"
 
 	"github.com/diamondburned/distant-front/internal/frontend"
 	"github.com/diamondburned/distant-front/internal/frontend/index/chat"
+	"github.com/diamondburned/distant-front/internal/frontend/index/leaderboard"
 	"github.com/diamondburned/distant-front/internal/frontend/index/link"
+	"github.com/diamondburned/distant-front/internal/frontend/index/live"
+	"github.com/diamondburned/distant-front/internal/frontend/index/player"
+	"github.com/diamondburned/distant-front/internal/frontend/index/trackmap"
 	"github.com/diamondburned/distant-front/lib/distance"
 	"github.com/diamondburned/tmplutil"
 	"github.com/go-chi/chi"
@@ -70,10 +75,16 @@ func Mount(rs frontend.RenderState) http.Handler {
 
 	r := chi.NewRouter()
 	r.Use(frontend.InjectRenderState(rs))
+	r.Use(link.LoadSession)
+	r.Use(link.ProtectCSRF)
 
 	r.Group(func(r chi.Router) {
 		r.Mount("/chat", chat.Mount())
 		r.Mount("/link", link.Mount())
+		r.Mount("/leaderboard", leaderboard.Mount())
+		r.Mount("/map", trackmap.Mount())
+		r.Mount("/player", player.Mount())
+		r.Mount("/live", live.Mount())
 	})
 
 	r.Group(func(r chi.Router) {
@@ -93,9 +104,50 @@ func noSniff(next http.Handler) http.Handler {
 }
 
 func renderIndex(w http.ResponseWriter, r *http.Request) {
-	frontend.ExecuteTemplate(w, r, index)
+	page(r, "index").ServeHTTP(w, r)
 }
 
+// renderBody renders the body of the index page. It is only rendered if the
+// observed state changed since the client's copy, so that polling clients cost
+// next to nothing while the server is idle.
 func renderBody(w http.ResponseWriter, r *http.Request) {
-	frontend.ExecuteNamedTemplate(w, r, "index-body")
+	page := page(r, "index-body")
+
+	if page.Tag != "" {
+		w.Header().Set("ETag", page.Tag)
+		w.Header().Set("Cache-Control", "no-cache")
+
+		if matchesETag(r.Header.Get("If-None-Match"), page.Tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	page.ServeHTTP(w, r)
+}
+
+// page returns the named page. Anonymous viewers share the page rendered once
+// per change of the observed state; linked players get their own, so that the
+// pages can show them something of their own without leaking it.
+func page(r *http.Request, name string) *frontend.CachedPage {
+	rs := frontend.GetRenderState(r.Context())
+	if _, linked := link.GetSession(r); linked {
+		rs.Pages = nil
+	}
+	return rs.Page(name)
+}
+
+// matchesETag returns true if the If-None-Match header matches the tag. Weak
+// comparison is used, as required for If-None-Match.
+func matchesETag(ifNoneMatch, tag string) bool {
+	tag = strings.TrimPrefix(tag, "W/")
+
+	for _, match := range strings.Split(ifNoneMatch, ",") {
+		match = strings.TrimSpace(match)
+		if match == "*" || strings.TrimPrefix(match, "W/") == tag {
+			return true
+		}
+	}
+
+	return false
 }
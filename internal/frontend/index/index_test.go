@@ -1,9 +1,18 @@
 package index
 
 import (
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/session"
 	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
 	"github.com/hexops/autogold"
 )
 
@@ -51,3 +60,94 @@ func newPlayer(finished bool, finishTime int, alive, spec bool) distance.Player
 		},
 	}
 }
+
+func TestBodyETag(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "Alice"})
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	renew := func() {
+		ch, cancel := obs.Subscribe()
+		defer cancel()
+		obs.Renew()
+		<-ch
+	}
+	renew()
+
+	h := Mount(frontend.RenderState{
+		Client:      s.NewClient(),
+		Observer:    obs,
+		Pages:       frontend.NewPageCache(),
+		Sessions:    session.NewStore(),
+		SessionOpts: session.Opts{TTL: time.Hour, Rotate: time.Hour},
+		DistanceURL: &url.URL{Host: "localhost"},
+	})
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/body", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("")
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || etag == "" || !strings.Contains(w.Body.String(), "Alice") {
+		t.Fatalf("unexpected first response %d with ETag %q", w.Code, etag)
+	}
+
+	// The cached page is served compressed to clients that accept it.
+	r := httptest.NewRequest("GET", "/body", nil)
+	r.Header.Set("Accept-Encoding", "br, gzip")
+	gw := httptest.NewRecorder()
+	h.ServeHTTP(gw, r)
+
+	if gw.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("body not compressed")
+	}
+	zr, err := gzip.NewReader(gw.Body)
+	if err != nil {
+		t.Fatal("invalid gzip:", err)
+	}
+	if b, _ := io.ReadAll(zr); string(b) != w.Body.String() {
+		t.Fatal("compressed body differs")
+	}
+
+	rs := frontend.RenderState{
+		Client:      s.NewClient(),
+		Observer:    obs,
+		Pages:       frontend.NewPageCache(),
+		DistanceURL: &url.URL{Host: "localhost"},
+	}
+	page := rs.Page("index-body")
+
+	// Nothing changed, so nothing is rendered.
+	renew()
+	if w := get(etag); w.Code != 304 || w.Body.Len() > 0 {
+		t.Fatalf("unexpected response %d to unchanged state: %q", w.Code, w.Body)
+	}
+	if rs.Page("index-body") != page {
+		t.Fatal("page rendered again on an idle tick")
+	}
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "b", Name: "Bob"})
+	renew()
+
+	// A page is rendered from the state that its tag is of, even if the state
+	// changed since.
+	if html, _ := page.Render(); strings.Contains(string(html), "Bob") {
+		t.Fatal("page rendered from a newer state than its tag")
+	}
+
+	w = get(etag)
+	if w.Code != 200 || w.Header().Get("ETag") == etag || !strings.Contains(w.Body.String(), "Bob") {
+		t.Fatalf("unexpected response %d to changed state with ETag %q", w.Code, w.Header().Get("ETag"))
+	}
+}
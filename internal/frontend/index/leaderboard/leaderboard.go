@@ -0,0 +1,126 @@
+package leaderboard
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/history"
+	"github.com/go-chi/chi"
+)
+
+var (
+	levels      = frontend.Templater.Register("leaderboards", "index/leaderboard/levels.html")
+	leaderboard = frontend.Templater.Register("leaderboard", "index/leaderboard/leaderboard.html")
+)
+
+func init() {
+	frontend.Templater.Func("finishData", finishData)
+	frontend.Templater.Func("levelID", history.LevelID)
+	frontend.Templater.Func("inc", func(i int) int { return i + 1 })
+}
+
+// finishData formats the finish data of a level in the given game mode as
+// either a race time or a score.
+func finishData(gameMode string, data int) string {
+	if history.HigherIsBetter(gameMode) {
+		return fmt.Sprintf("%d eV", data)
+	}
+
+	ms := data % 1000
+	sec := data / 1000 % 60
+	min := data / 1000 / 60
+
+	return fmt.Sprintf("%d:%02d.%03d", min, sec, ms)
+}
+
+// Mount mounts the leaderboard routes.
+func Mount() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", renderLevels)
+	r.Get("/{levelID}", renderLeaderboard)
+	return r
+}
+
+// recentResults is the number of recent results shown on a leaderboard.
+const recentResults = 5
+
+type levelsData struct {
+	frontend.RenderState
+	Levels []history.LevelInfo
+}
+
+func renderLevels(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+	if rs.History == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, "history is disabled")
+		return
+	}
+
+	l, err := rs.History.Levels()
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get levels: "+err.Error())
+		return
+	}
+
+	if err := levels.Execute(w, levelsData{rs, l}); err != nil {
+		log.Println("Error rendering:", err)
+	}
+}
+
+type leaderboardData struct {
+	frontend.RenderState
+	Level   history.LevelInfo
+	Times   []history.BestTime
+	Results []history.Result
+}
+
+func renderLeaderboard(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+	if rs.History == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, "history is disabled")
+		return
+	}
+
+	levelID := chi.URLParam(r, "levelID")
+
+	level, err := rs.History.Level(levelID)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get level: "+err.Error())
+		return
+	}
+	if level == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, "level not found")
+		return
+	}
+
+	data := leaderboardData{
+		RenderState: rs,
+		Level:       *level,
+	}
+
+	data.Times, err = rs.History.Leaderboard(levelID)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get leaderboard: "+err.Error())
+		return
+	}
+
+	data.Results, err = rs.History.Results(levelID, recentResults)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get results: "+err.Error())
+		return
+	}
+
+	if err := leaderboard.Execute(w, data); err != nil {
+		log.Println("Error rendering:", err)
+	}
+}
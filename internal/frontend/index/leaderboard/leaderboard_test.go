@@ -0,0 +1,104 @@
+package leaderboard
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/history"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/go-chi/chi"
+)
+
+func newTestRouter(t *testing.T, db *history.DB) *chi.Mux {
+	t.Helper()
+
+	c, err := distance.NewClient("http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(frontend.RenderState{
+		Client:  c,
+		History: db,
+		ID:      "main",
+	}))
+	r.Mount("/leaderboard", Mount())
+
+	return r
+}
+
+func get(r *chi.Mux, path string) (int, string) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestLeaderboard(t *testing.T) {
+	db, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal("failed to open history:", err)
+	}
+	defer db.Close()
+
+	level := distance.Level{
+		Name:              "Odd Path",
+		GameMode:          "Sprint",
+		RelativeLevelPath: "WorkshopLevels/76561198000000000/a~b?.bytes",
+	}
+	alice := distance.Player{
+		UnityPlayerGUID: "a",
+		Name:            "Alice",
+		Car: distance.Car{
+			Name:       "Spectrum",
+			Finished:   true,
+			FinishType: distance.NormalFinish,
+			FinishData: 61234,
+		},
+	}
+
+	result := history.NewResult(level, []distance.Player{alice}, time.Unix(1600000000, 0))
+	if err := db.AddResult(result); err != nil {
+		t.Fatal("failed to add result:", err)
+	}
+
+	// The ID of this level has characters that base64 only uses in URLs.
+	id := history.LevelID(level)
+	if !strings.ContainsAny(id, "-_") {
+		t.Fatalf("level ID %q doesn't test URL-safe characters", id)
+	}
+
+	r := newTestRouter(t, db)
+
+	code, body := get(r, "/leaderboard/")
+	if code != 200 || !strings.Contains(body, "Odd Path") || !strings.Contains(body, id) {
+		t.Errorf("unexpected levels %d: %s", code, body)
+	}
+
+	code, body = get(r, "/leaderboard/"+id)
+	if code != 200 {
+		t.Fatalf("unexpected status %d for leaderboard: %s", code, body)
+	}
+	for _, want := range []string{"Odd Path", "Alice", "Spectrum", "1:01.234"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("leaderboard is missing %q", want)
+		}
+	}
+
+	if code, _ := get(r, "/leaderboard/bm9wZQ"); code != 404 {
+		t.Errorf("unexpected status %d for unknown level", code)
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	r := newTestRouter(t, nil)
+
+	for _, path := range []string{"/leaderboard/", "/leaderboard/bm9wZQ"} {
+		if code, body := get(r, path); code != 404 || body != "history is disabled" {
+			t.Errorf("unexpected response %d for %s: %s", code, path, body)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package link
+
+import (
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/session"
+)
+
+// csrfCookie is the cookie that holds the CSRF token of visitors without a
+// session.
+const csrfCookie = "CSRF"
+
+// ProtectCSRF is a middleware that rejects state-changing requests made by
+// other sites. They must have the CSRF token in the "csrf" form field and, if
+// the browser says where they come from, come from the same origin. The token
+// is bound to the linked player's session, or to a cookie for visitors without
+// one, and is set in the RenderState for the forms. LoadSession must come
+// first.
+func ProtectCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs := frontend.GetRenderState(r.Context())
+		rs.CSRF = csrfToken(w, r, rs)
+
+		if r.Method != "GET" && r.Method != "HEAD" {
+			if !SameOrigin(r) {
+				w.WriteHeader(403)
+				io.WriteString(w, "cross-origin request")
+				return
+			}
+
+			csrf := r.FormValue("csrf")
+			if subtle.ConstantTimeCompare([]byte(csrf), []byte(rs.CSRF)) != 1 {
+				w.WriteHeader(403)
+				io.WriteString(w, "invalid CSRF token")
+				return
+			}
+		}
+
+		frontend.InjectRenderState(rs)(next).ServeHTTP(w, r)
+	})
+}
+
+// csrfToken returns the CSRF token of the request. Visitors without a session
+// or a CSRF cookie are given a new token.
+func csrfToken(w http.ResponseWriter, r *http.Request, rs frontend.RenderState) string {
+	if sess, ok := GetSession(r); ok {
+		return sess.CSRF
+	}
+
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	token := session.NewToken()
+	writeCookie(w, rs, csrfCookie, token)
+	return token
+}
+
+// SameOrigin returns false if the Origin or Referer header of the request is
+// from another host. Requests with neither are allowed: browsers always send
+// Origin for WebSocket handshakes, and forms have the CSRF token anyway.
+func SameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
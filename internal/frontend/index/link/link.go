@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/diamondburned/distant-front/internal/audit"
 	"github.com/diamondburned/distant-front/internal/frontend"
 	"github.com/diamondburned/distant-front/lib/distance"
 	"github.com/go-chi/chi"
@@ -13,21 +14,6 @@ import (
 
 var link = frontend.Templater.Register("link", "index/link/link.html")
 
-// GetDistanceSession gets the Distance session token from the request cookies.
-// An empty string is returned if none is found.
-func GetDistanceSession(r *http.Request) string {
-	cookie, err := r.Cookie("DistanceSession")
-	if err != nil {
-		return ""
-	}
-	return cookie.Value
-}
-
-// ClearDistanceSession clears the DistanceSession cookie.
-func ClearDistanceSession(w http.ResponseWriter) {
-	writeDistanceSession(w, "")
-}
-
 func Mount() http.Handler {
 	r := chi.NewRouter()
 	r.Get("/", renderAuth)
@@ -41,12 +27,14 @@ type renderAuthData struct {
 	Unlinked bool
 }
 
+// renderAuth renders the link page. It must not change the session, since any
+// site can make the browser open it; unlinking is done by POSTing to the chat's
+// unlink route instead.
 func renderAuth(w http.ResponseWriter, r *http.Request) {
-	// Clear cookies.
-	writeDistanceSession(w, "")
+	rs := frontend.GetRenderState(r.Context())
 
 	executeAuthenticateTmpl(w, renderAuthData{
-		RenderState: frontend.GetRenderState(r.Context()),
+		RenderState: rs,
 		Unlinked:    r.FormValue("unlinked") != "",
 	})
 }
@@ -71,8 +59,13 @@ func postAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	entry := audit.Entry{Actor: "player", Action: "link"}
+
 	s, err := rs.Client.LinkSession(linkCode)
 	if err != nil {
+		entry.Error = err.Error()
+		rs.Record(r, entry)
+
 		if errors.Is(err, distance.ErrLinkCodeNotFound) {
 			w.WriteHeader(400)
 			executeAuthenticateTmpl(w, renderAuthData{
@@ -93,20 +86,22 @@ func postAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeDistanceSession(w, s)
-	http.Redirect(w, r, "/chat", http.StatusFound)
-}
-
-func writeDistanceSession(w http.ResponseWriter, session string) {
-	cookie := http.Cookie{
-		Name:  "DistanceSession",
-		Value: session,
-		Path:  "/",
+	// The GUID is validated again on the next observation if this fails.
+	var validated time.Time
+	guid, err := rs.Client.PlayerGUID(s)
+	if err == nil {
+		validated = time.Now()
 	}
 
-	if session == "" {
-		cookie.Expires = time.Unix(0, 0)
+	entry.PlayerGUID = guid
+	rs.Record(r, entry)
+
+	// Always issue a new session ID, so that an ID set by someone else can't
+	// be linked. The previous session is ended.
+	if old, ok := r.Context().Value(sessionCtx).(linkedSession); ok {
+		rs.Sessions.Delete(old.id)
 	}
+	startSession(w, rs, s, guid, validated)
 
-	http.SetCookie(w, &cookie)
+	http.Redirect(w, r, rs.Prefix+"/chat", http.StatusFound)
 }
@@ -0,0 +1,177 @@
+package link
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/session"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+	"github.com/go-chi/chi"
+)
+
+func TestSession(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "Alice"})
+	s.AddLinkCode("123ABC", "a")
+
+	c := s.NewClient()
+	obs := distance.NewObserver(c, time.Hour)
+	defer obs.Stop()
+
+	renew := func() {
+		ch, cancel := obs.Subscribe()
+		defer cancel()
+		obs.Renew()
+		<-ch
+	}
+
+	h := chi.NewRouter()
+	h.Use(frontend.InjectRenderState(frontend.RenderState{
+		Client:   c,
+		Observer: obs,
+		Sessions: session.NewStore(),
+		ID:       "main",
+		// Rotate and validate on every request.
+		SessionOpts: session.Opts{TTL: time.Hour},
+	}))
+	h.Use(LoadSession)
+	h.Mount("/link", Mount())
+	h.Get("/guid", func(w http.ResponseWriter, r *http.Request) {
+		sess, _ := GetSession(r)
+		io.WriteString(w, sess.PlayerGUID)
+	})
+
+	do := func(method, path string, form url.Values, cookie *http.Cookie) (*http.Cookie, string) {
+		t.Helper()
+
+		r := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		for _, set := range w.Result().Cookies() {
+			if set.Name == sessionCookie {
+				return set, w.Body.String()
+			}
+		}
+		return nil, w.Body.String()
+	}
+
+	cookie, _ := do("POST", "/link", url.Values{"link_code": {"123ABC"}}, nil)
+	if cookie == nil || !cookie.HttpOnly || cookie.MaxAge != 3600 {
+		t.Fatalf("unexpected session cookie %#v", cookie)
+	}
+
+	for token := range s.State().Links.Links {
+		if cookie.Value == token {
+			t.Fatal("session cookie contains the session token")
+		}
+	}
+
+	rotated, guid := do("GET", "/guid", nil, cookie)
+	if guid != "a" {
+		t.Fatalf("expected player a, got %q", guid)
+	}
+	if rotated == nil || rotated.Value == cookie.Value {
+		t.Fatalf("expected rotated session cookie, got %#v", rotated)
+	}
+
+	// The old ID stays valid for a while, but isn't rotated again.
+	if set, guid := do("GET", "/guid", nil, cookie); set != nil || guid != "a" {
+		t.Fatalf("unexpected response to the old ID: %#v, %q", set, guid)
+	}
+
+	// Opening the link page doesn't end the session, since any site can make
+	// the browser open it.
+	do("GET", "/link", nil, rotated)
+	if _, guid := do("GET", "/guid", nil, rotated); guid != "a" {
+		t.Fatal("session ended by opening the link page")
+	}
+
+	// Revoking the link ends the session on the next observation.
+	s.Update(func(state *distancetest.State) {
+		state.Links.Links = map[string]string{}
+	})
+	renew()
+
+	if set, guid := do("GET", "/guid", nil, rotated); guid != "" || set == nil || set.MaxAge >= 0 {
+		t.Fatalf("expected revoked session, got %#v, %q", set, guid)
+	}
+	if _, guid := do("GET", "/guid", nil, cookie); guid != "" {
+		t.Fatal("old ID is still valid after revoking")
+	}
+}
+
+func TestProtectCSRF(t *testing.T) {
+	c, err := distance.NewClient("http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := chi.NewRouter()
+	h.Use(frontend.InjectRenderState(frontend.RenderState{
+		Client:      c,
+		Sessions:    session.NewStore(),
+		SessionOpts: session.Opts{TTL: time.Hour},
+	}))
+	h.Use(LoadSession)
+	h.Use(ProtectCSRF)
+	h.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, frontend.GetRenderState(r.Context()).CSRF)
+	})
+	h.Post("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	token := w.Body.String()
+	cookies := w.Result().Cookies()
+	if token == "" || len(cookies) != 1 || cookies[0].Value != token || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected token %q and cookies %#v", token, cookies)
+	}
+
+	post := func(token, origin string) int {
+		r := httptest.NewRequest("POST", "http://example.com/", strings.NewReader("csrf="+token))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookies[0])
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	tests := []struct {
+		token  string
+		origin string
+		code   int
+	}{
+		{token, "", 200},
+		{token, "http://example.com", 200},
+		{token, "http://evil.example", 403},
+		{token, "null", 403},
+		{"", "http://example.com", 403},
+		{"wrong", "", 403},
+	}
+
+	for _, test := range tests {
+		if code := post(test.token, test.origin); code != test.code {
+			t.Errorf("token %q from %q: expected %d, got %d", test.token, test.origin, test.code, code)
+		}
+	}
+}
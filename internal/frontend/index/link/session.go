@@ -0,0 +1,200 @@
+package link
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/session"
+)
+
+const (
+	// sessionCookie is the cookie that holds the opaque session ID.
+	sessionCookie = "Session"
+	// legacyCookie is the cookie that used to hold the raw Distance session
+	// token. It is migrated to a session and cleared.
+	legacyCookie = "DistanceSession"
+)
+
+// rotateGrace is how long a rotated session ID stays valid, so that requests
+// already sent with it, e.g. a chat stream, don't unlink the player.
+const rotateGrace = time.Minute
+
+type ctxTypes uint8
+
+const (
+	sessionCtx ctxTypes = iota
+)
+
+type linkedSession struct {
+	id string
+	session.Session
+}
+
+// LoadSession is a middleware that loads the linked player's session from the
+// request cookies. Every so often, the session is validated against the links
+// of the server and its ID is rotated. The RenderState must be injected.
+func LoadSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rs := frontend.GetRenderState(r.Context())
+
+		if rs.Sessions != nil {
+			if linked, ok := loadSession(w, r, rs); ok {
+				r = r.WithContext(context.WithValue(r.Context(), sessionCtx, linked))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func loadSession(w http.ResponseWriter, r *http.Request, rs frontend.RenderState) (linkedSession, bool) {
+	if cookie, err := r.Cookie(legacyCookie); err == nil {
+		writeCookie(w, rs, legacyCookie, "")
+		if linked, ok := migrateSession(w, rs, cookie.Value); ok {
+			return linked, true
+		}
+	}
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return linkedSession{}, false
+	}
+
+	id := cookie.Value
+
+	sess, ok := rs.Sessions.Get(id)
+	if !ok || sess.Server != rs.ID {
+		writeCookie(w, rs, sessionCookie, "")
+		return linkedSession{}, false
+	}
+
+	now := time.Now()
+
+	if now.Sub(sess.Validated) >= rs.SessionOpts.Validate {
+		// Only trust links that were observed after the last validation, since
+		// the session might have been linked after the last observation.
+		state := rs.Observer.State()
+
+		if state.Links != nil && state.LastRenew.After(sess.Validated) {
+			guid, ok := state.Links.Links[sess.Token]
+			if !ok {
+				rs.Sessions.Delete(id)
+				writeCookie(w, rs, sessionCookie, "")
+				return linkedSession{}, false
+			}
+
+			sess.PlayerGUID = guid
+			sess.Validated = state.LastRenew
+			rs.Sessions.Update(id, sess)
+		}
+	}
+
+	if !sess.Rotated && now.Sub(sess.Issued) >= rs.SessionOpts.Rotate {
+		if newID, ok := rs.Sessions.Rotate(id, rotateGrace); ok {
+			id = newID
+			writeCookie(w, rs, sessionCookie, id)
+		}
+	}
+
+	return linkedSession{id, sess}, true
+}
+
+// migrateSession starts a session for a token from the legacy cookie if the
+// token is in the observed links.
+func migrateSession(w http.ResponseWriter, rs frontend.RenderState, token string) (linkedSession, bool) {
+	state := rs.Observer.State()
+	if state.Links == nil {
+		return linkedSession{}, false
+	}
+
+	guid, ok := state.Links.Links[token]
+	if !ok {
+		return linkedSession{}, false
+	}
+
+	return startSession(w, rs, token, guid, state.LastRenew), true
+}
+
+// startSession starts a new session for the given token and sets its cookie.
+func startSession(w http.ResponseWriter, rs frontend.RenderState, token, guid string, validated time.Time) linkedSession {
+	sess := session.Session{
+		Server:     rs.ID,
+		Token:      token,
+		PlayerGUID: guid,
+		Validated:  validated,
+		Expires:    time.Now().Add(rs.SessionOpts.TTL),
+	}
+
+	id, sess := rs.Sessions.Create(sess)
+	writeCookie(w, rs, sessionCookie, id)
+
+	return linkedSession{id, sess}
+}
+
+// GetSession returns the linked player's session loaded by LoadSession. False
+// is returned if the player isn't linked.
+func GetSession(r *http.Request) (session.Session, bool) {
+	linked, ok := r.Context().Value(sessionCtx).(linkedSession)
+	return linked.Session, ok
+}
+
+// StillLinked returns true if the session loaded by LoadSession wasn't ended
+// since, e.g. by unlinking or because the link is gone from the server.
+// Long-lived connections should check it every so often.
+func StillLinked(r *http.Request) bool {
+	sess, ok := GetSession(r)
+	if !ok {
+		return false
+	}
+
+	rs := frontend.GetRenderState(r.Context())
+	if !rs.Sessions.Linked(sess.Server, sess.Token) {
+		return false
+	}
+
+	state := rs.Observer.State()
+	if state.Links != nil && state.LastRenew.After(sess.Validated) {
+		_, ok := state.Links.Links[sess.Token]
+		return ok
+	}
+
+	return true
+}
+
+// ClearSession ends the linked player's session, if any, and clears its cookie.
+func ClearSession(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+
+	if linked, ok := r.Context().Value(sessionCtx).(linkedSession); ok {
+		rs.Sessions.Delete(linked.id)
+	}
+
+	writeCookie(w, rs, sessionCookie, "")
+}
+
+// writeCookie sets the cookie with the given name. An empty value clears it.
+func writeCookie(w http.ResponseWriter, rs frontend.RenderState, name, value string) {
+	// Scope the cookie to the server, so that sessions don't leak into other
+	// servers.
+	cookie := http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     rs.Prefix + "/",
+		HttpOnly: true,
+	}
+
+	rs.Cookie.Apply(&cookie)
+
+	if value == "" {
+		cookie.Expires = time.Unix(0, 0)
+		cookie.MaxAge = -1
+	} else {
+		// Keep the cookie for as long as a session lasts, regardless of
+		// cookie.max_age, since sessions expire server-side anyway.
+		cookie.MaxAge = int(rs.SessionOpts.TTL / time.Second)
+	}
+
+	http.SetCookie(w, &cookie)
+}
@@ -0,0 +1,424 @@
+// Package live serves a WebSocket that streams the server's state and chat as
+// it changes, and lets linked players send chat over the same connection.
+package live
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/flood"
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/frontend/api"
+	"github.com/diamondburned/distant-front/internal/frontend/index/chat"
+	"github.com/diamondburned/distant-front/internal/frontend/index/link"
+	"github.com/diamondburned/distant-front/internal/frontend/index/trackmap"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+// Message is a message sent to the client. Type says what Data is.
+type Message struct {
+	Type string
+	Data interface{} `json:",omitempty"`
+}
+
+// Types of messages sent to the client.
+const (
+	// HelloType is sent first, with Hello.
+	HelloType = "hello"
+	// SummaryType is sent when the summary changes, with SummaryDiff.
+	SummaryType = "summary"
+	// PlaylistType is sent when the playlist changes, with the whole
+	// distance.PlaylistState.
+	PlaylistType = "playlist"
+	// ChatType is sent for every new chat message, with Chat.
+	ChatType = "chat"
+	// BodyType is sent when the rendered index page changes, with Body. It is
+	// only sent if the body query is set.
+	BodyType = "body"
+	// MapType is sent when the rendered track map changes, with Body. It is
+	// only sent if the map query is set.
+	MapType = "map"
+	// ResultType is sent in response to every Request, with Result.
+	ResultType = "result"
+	// ExpiredType is sent when the linked player's session ends, e.g. because
+	// they left the server. Chat can't be sent anymore.
+	ExpiredType = "expired"
+	// HaltedType is sent when the server stops being observed, e.g. because
+	// the config was reloaded. The connection is closed, and the client
+	// should reconnect.
+	HaltedType = "halted"
+	// PingType is sent every so often, so that the connection doesn't time
+	// out.
+	PingType = "ping"
+)
+
+// Hello is the first message of the connection.
+type Hello struct {
+	Linked     bool
+	PlayerGUID string `json:",omitempty"`
+}
+
+// SummaryDiff is the change of the summary since the last one sent. Only the
+// fields that changed are set; the first one sent has every field.
+type SummaryDiff struct {
+	Server       *distance.Server       `json:",omitempty"`
+	Level        *distance.Level        `json:",omitempty"`
+	AutoServer   *distance.AutoServer   `json:",omitempty"`
+	VoteCommands *distance.VoteCommands `json:",omitempty"`
+	// Players are the players that joined or changed.
+	Players []api.Player `json:",omitempty"`
+	// Left are the GUIDs of the players that left.
+	Left []string `json:",omitempty"`
+}
+
+// Chat is a new chat message.
+type Chat struct {
+	Message distance.ChatMessage
+	// HTML is the message rendered like on the chat page.
+	HTML string
+}
+
+// Body is the rendered body of the index page or of the track map.
+type Body struct {
+	HTML string
+}
+
+// Request is a message sent by the client.
+type Request struct {
+	// Type is "send", which sends Message as chat.
+	Type string
+	// ID is echoed in the Result, so that the client can tell which request
+	// it is for.
+	ID      string
+	Message string
+}
+
+// Result is the result of a Request.
+type Result struct {
+	ID string
+	// Error is why the request failed. It is empty if it succeeded.
+	Error string `json:",omitempty"`
+	// RetryAfter is the number of seconds after which chat can be sent again,
+	// if it was throttled.
+	RetryAfter int `json:",omitempty"`
+}
+
+// pingEvery is how often a ping is sent.
+const pingEvery = 30 * time.Second
+
+// writeTimeout is how long sending a message may take before the client is
+// considered gone.
+const writeTimeout = 10 * time.Second
+
+// maxRequestSize is the maximum size of a request in bytes.
+const maxRequestSize = 4096
+
+// Mount returns the WebSocket handler. The RenderState must be injected, and
+// the session must be loaded.
+func Mount() http.Handler {
+	return websocket.Server{
+		Handshake: handshake,
+		Handler:   serve,
+	}
+}
+
+// handshake rejects connections from other sites, since the session cookie
+// would be sent along.
+func handshake(config *websocket.Config, r *http.Request) error {
+	if !link.SameOrigin(r) {
+		return errors.New("cross-origin request")
+	}
+	return nil
+}
+
+// conn is a single client connection.
+type conn struct {
+	ws      *websocket.Conn
+	r       *http.Request
+	rs      frontend.RenderState
+	linked  bool
+	body    bool
+	mapBody bool
+
+	summary  *distance.Summary
+	playlist *distance.PlaylistState
+	tag      string
+	mapTag   string
+}
+
+func serve(ws *websocket.Conn) {
+	ws.MaxPayloadBytes = maxRequestSize
+	r := ws.Request()
+
+	c := conn{
+		ws:      ws,
+		r:       r,
+		rs:      frontend.GetRenderState(r.Context()),
+		body:    r.FormValue("body") != "",
+		mapBody: r.FormValue("map") != "",
+	}
+
+	sess, linked := link.GetSession(r)
+	c.linked = linked
+
+	if c.rs.ChatListeners != nil {
+		c.rs.ChatListeners.Inc()
+		defer c.rs.ChatListeners.Dec()
+	}
+
+	// Subscribe before sending the current state, so no changes are lost in
+	// between.
+	stateCh, cancelState := c.rs.Observer.Subscribe()
+	defer cancelState()
+
+	evCh, cancelEvents := c.rs.Observer.SubscribeEvents()
+	defer cancelEvents()
+
+	requests := make(chan Request)
+	go c.readRequests(requests)
+
+	if !c.send(HelloType, Hello{Linked: linked, PlayerGUID: sess.PlayerGUID}) {
+		return
+	}
+	if !c.update(c.rs.Observer.State()) {
+		return
+	}
+
+	ping := time.NewTicker(pingEvery)
+	defer ping.Stop()
+
+	for {
+		var ok bool
+
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-ping.C:
+			ok = c.send(PingType, nil)
+
+		case req, open := <-requests:
+			if !open {
+				return
+			}
+			ok = c.send(ResultType, c.handle(req))
+
+		case state, open := <-stateCh:
+			if !open {
+				c.send(HaltedType, nil)
+				return
+			}
+			ok = c.update(state)
+
+		case ev, open := <-evCh:
+			if !open {
+				c.send(HaltedType, nil)
+				return
+			}
+			ok = c.event(ev, sess.PlayerGUID)
+		}
+
+		if !ok {
+			return
+		}
+	}
+}
+
+// readRequests reads the client's requests until the connection is closed.
+func (c *conn) readRequests(requests chan<- Request) {
+	defer close(requests)
+
+	for {
+		var req Request
+		if err := websocket.JSON.Receive(c.ws, &req); err != nil {
+			return
+		}
+
+		select {
+		case requests <- req:
+		case <-c.r.Context().Done():
+			return
+		}
+	}
+}
+
+// send sends a message. False is returned if the connection is broken or the
+// client stopped reading.
+func (c *conn) send(typ string, data interface{}) bool {
+	c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return websocket.JSON.Send(c.ws, Message{Type: typ, Data: data}) == nil
+}
+
+// handle handles the client's request.
+func (c *conn) handle(req Request) Result {
+	result := Result{ID: req.ID}
+
+	if req.Type != "send" {
+		result.Error = "unknown request type " + req.Type
+		return result
+	}
+
+	if !c.linked || !link.StillLinked(c.r) {
+		result.Error = chat.ErrNotLinked.Error()
+		return result
+	}
+
+	if err := chat.Send(c.r, req.Message); err != nil {
+		result.Error = err.Error()
+
+		var throttled *flood.ThrottledError
+		if errors.As(err, &throttled) {
+			result.RetryAfter = int(throttled.RetryAfter.Round(time.Second) / time.Second)
+		}
+	}
+
+	return result
+}
+
+// update sends what changed in the given state since the last update.
+func (c *conn) update(state distance.ObservedState) bool {
+	if state.Summary != nil {
+		if diff, changed := diffSummary(c.summary, state.Summary); changed {
+			if !c.send(SummaryType, diff) {
+				return false
+			}
+			c.summary = state.Summary
+		}
+	}
+
+	if state.PlaylistState != nil && !reflect.DeepEqual(c.playlist, state.PlaylistState) {
+		if !c.send(PlaylistType, state.PlaylistState) {
+			return false
+		}
+		c.playlist = state.PlaylistState
+	}
+
+	if c.body && !c.updateBody() {
+		return false
+	}
+
+	if c.mapBody && !c.updateMap() {
+		return false
+	}
+
+	if c.linked && !link.StillLinked(c.r) {
+		c.linked = false
+		return c.send(ExpiredType, nil)
+	}
+
+	return true
+}
+
+// updateBody sends the rendered body of the index page. It is only sent when
+// the state shown on it changes, and anonymous viewers share the render.
+func (c *conn) updateBody() bool {
+	rs := c.rs
+	if c.linked {
+		rs.Pages = nil
+	}
+
+	page := rs.Page("index-body")
+	if page.Tag != "" && page.Tag == c.tag {
+		return true
+	}
+
+	html, _ := page.Render()
+	if html == nil {
+		return true
+	}
+
+	if !c.send(BodyType, Body{HTML: string(html)}) {
+		return false
+	}
+
+	c.tag = page.Tag
+	return true
+}
+
+// event sends the chat messages in the given event. The session expires if
+// the linked player leaves.
+func (c *conn) event(ev distance.Event, playerGUID string) bool {
+	switch ev := ev.(type) {
+	case distance.ChatMessageReceivedEvent:
+		var html strings.Builder
+		frontend.Templater.Execute(&html, "chat-message", ev.Message)
+		return c.send(ChatType, Chat{Message: ev.Message, HTML: html.String()})
+
+	case distance.PlayerLeftEvent:
+		if c.linked && playerGUID != "" && ev.Player.UnityPlayerGUID == playerGUID {
+			c.linked = false
+			return c.send(ExpiredType, nil)
+		}
+	}
+
+	return true
+}
+
+// diffSummary returns the difference from the old summary to the new one. The
+// old summary may be nil, in which case everything is different.
+func diffSummary(old, new *distance.Summary) (SummaryDiff, bool) {
+	first := old == nil
+	if first {
+		old = &distance.Summary{}
+	}
+
+	var diff SummaryDiff
+	changed := first
+
+	if first || !reflect.DeepEqual(old.Server, new.Server) {
+		diff.Server = &new.Server
+		changed = true
+	}
+	if first || !reflect.DeepEqual(old.Level, new.Level) {
+		diff.Level = &new.Level
+		changed = true
+	}
+	if first || !reflect.DeepEqual(old.AutoServer, new.AutoServer) {
+		diff.AutoServer = &new.AutoServer
+		changed = true
+	}
+	if first || !reflect.DeepEqual(old.VoteCommands, new.VoteCommands) {
+		diff.VoteCommands = &new.VoteCommands
+		changed = true
+	}
+
+	for _, player := range new.Players {
+		if prev := old.FindPlayer(player.UnityPlayerGUID); prev == nil || !reflect.DeepEqual(*prev, player) {
+			diff.Players = append(diff.Players, api.NewPlayer(player))
+			changed = true
+		}
+	}
+	for _, player := range old.Players {
+		if new.FindPlayer(player.UnityPlayerGUID) == nil {
+			diff.Left = append(diff.Left, player.UnityPlayerGUID)
+			changed = true
+		}
+	}
+
+	return diff, changed
+}
+
+// updateMap sends the rendered track map if it changed. The map is shared by
+// every connection.
+func (c *conn) updateMap() bool {
+	page := trackmap.BodyPage(c.rs)
+	if page.Tag != "" && page.Tag == c.mapTag {
+		return true
+	}
+
+	html, _ := page.Render()
+	if html == nil {
+		return true
+	}
+
+	if !c.send(MapType, Body{HTML: string(html)}) {
+		return false
+	}
+
+	c.mapTag = page.Tag
+	return true
+}
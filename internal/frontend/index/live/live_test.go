@@ -0,0 +1,173 @@
+package live
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/frontend/index/link"
+	"github.com/diamondburned/distant-front/internal/session"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+	"github.com/go-chi/chi"
+	"golang.org/x/net/websocket"
+)
+
+func TestLive(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "Alice"})
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+	obs.Renew()
+
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(frontend.RenderState{
+		Client:      s.NewClient(),
+		Observer:    obs,
+		Sessions:    session.NewStore(),
+		SessionOpts: session.Opts{TTL: time.Hour, Rotate: time.Hour},
+	}))
+	r.Use(link.LoadSession)
+	r.Mount("/live", Mount())
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/live"
+
+	if ws, err := websocket.Dial(url, "", "http://example.com"); err == nil {
+		ws.Close()
+		t.Fatal("cross-origin connection was accepted")
+	}
+
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatal("failed to dial:", err)
+	}
+	defer ws.Close()
+
+	// receive receives the next message that isn't a ping, decoding its data
+	// into v.
+	receive := func(t *testing.T, v interface{}) string {
+		t.Helper()
+
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		for {
+			var msg struct {
+				Type string
+				Data json.RawMessage
+			}
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				t.Fatal("failed to receive:", err)
+			}
+			if msg.Type == PingType {
+				continue
+			}
+			if v != nil && len(msg.Data) > 0 {
+				if err := json.Unmarshal(msg.Data, v); err != nil {
+					t.Fatalf("failed to decode %s: %v", msg.Type, err)
+				}
+			}
+			return msg.Type
+		}
+	}
+
+	var hello Hello
+	if typ := receive(t, &hello); typ != HelloType || hello.Linked {
+		t.Fatalf("unexpected first message %q: %#v", typ, hello)
+	}
+
+	var diff SummaryDiff
+	if typ := receive(t, &diff); typ != SummaryType || diff.Server == nil ||
+		len(diff.Players) != 1 || diff.Players[0].Name != "Alice" {
+		t.Fatalf("unexpected first summary %q: %#v", typ, diff)
+	}
+
+	// Sending chat without a session fails.
+	if err := websocket.JSON.Send(ws, Request{Type: "send", ID: "1", Message: "hi"}); err != nil {
+		t.Fatal("failed to send:", err)
+	}
+
+	var result Result
+	for {
+		typ := receive(t, &result)
+		if typ == ResultType {
+			break
+		}
+	}
+	if result.ID != "1" || result.Error != "not linked" {
+		t.Fatalf("unexpected result %#v", result)
+	}
+
+	// Only the player who joined is sent.
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "b", Name: "Bob"})
+	obs.Renew()
+
+	for {
+		diff = SummaryDiff{}
+		if typ := receive(t, &diff); typ == SummaryType {
+			break
+		}
+	}
+	if diff.Server != nil || len(diff.Players) != 1 || diff.Players[0].Name != "Bob" {
+		t.Fatalf("unexpected summary diff %#v", diff)
+	}
+}
+
+func TestLiveMap(t *testing.T) {
+	s := distancetest.NewServer("secret")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{
+		UnityPlayerGUID: "a",
+		Name:            "Alice",
+		Car:             distance.Car{Position: []float32{1, 2, 3}},
+	})
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(frontend.RenderState{
+		Client:   s.NewClient(),
+		Observer: obs,
+		Sessions: session.NewStore(),
+	}))
+	r.Use(link.LoadSession)
+	r.Mount("/live", Mount())
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/live?map=1", "", srv.URL)
+	if err != nil {
+		t.Fatal("failed to dial:", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for {
+		var msg struct {
+			Type string
+			Data Body
+		}
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatal("failed to receive the map:", err)
+		}
+		if msg.Type != MapType {
+			continue
+		}
+		if !strings.Contains(msg.Data.HTML, "<svg") || !strings.Contains(msg.Data.HTML, "Alice") {
+			t.Fatalf("unexpected map %q", msg.Data.HTML)
+		}
+		return
+	}
+}
@@ -0,0 +1,96 @@
+package player
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/internal/history"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/go-chi/chi"
+)
+
+var profile = frontend.Templater.Register("player", "index/player/player.html")
+
+func init() {
+	frontend.Templater.Func("onlineTime", onlineTime)
+}
+
+// onlineTime formats the time spent online in hours and minutes.
+func onlineTime(d time.Duration) string {
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+
+	if h == 0 {
+		return fmt.Sprintf("%dm", m)
+	}
+	return fmt.Sprintf("%dh %02dm", h, m)
+}
+
+// Mount mounts the player profile routes.
+func Mount() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/{guid}", renderProfile)
+	return r
+}
+
+// recentChat is the number of recent chat messages shown on a profile.
+const recentChat = 10
+
+type profileData struct {
+	frontend.RenderState
+	Profile history.Profile
+	Levels  []history.PlayerLevel
+	Chat    []distance.ChatMessage
+	Now     time.Time
+}
+
+func renderProfile(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+	if rs.History == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, "history is disabled")
+		return
+	}
+
+	guid := chi.URLParam(r, "guid")
+
+	p, err := rs.History.Profile(guid)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get profile: "+err.Error())
+		return
+	}
+	if p == nil {
+		w.WriteHeader(404)
+		io.WriteString(w, "player not found")
+		return
+	}
+
+	data := profileData{
+		RenderState: rs,
+		Profile:     *p,
+		Now:         time.Now(),
+	}
+
+	data.Levels, err = rs.History.PlayerLevels(guid)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get levels: "+err.Error())
+		return
+	}
+
+	data.Chat, err = rs.History.PlayerChat(guid, recentChat)
+	if err != nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to get chat: "+err.Error())
+		return
+	}
+
+	if err := profile.Execute(w, data); err != nil {
+		log.Println("Error rendering:", err)
+	}
+}
@@ -0,0 +1,100 @@
+package player
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	// The profile shows finishes like the leaderboards.
+	_ "github.com/diamondburned/distant-front/internal/frontend/index/leaderboard"
+	"github.com/diamondburned/distant-front/internal/history"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/go-chi/chi"
+)
+
+func TestProfile(t *testing.T) {
+	db, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal("failed to open history:", err)
+	}
+	defer db.Close()
+
+	alice := distance.Player{
+		UnityPlayerGUID: "a",
+		Name:            "Alice",
+		Car: distance.Car{
+			Name:       "Spectrum",
+			Colors:     [][4]float32{{1, 0, 0, 1}},
+			Finished:   true,
+			FinishType: distance.NormalFinish,
+			FinishData: 61234,
+		},
+	}
+
+	start := time.Unix(1600000000, 0)
+	level := distance.Level{
+		Name:              "Broken Symmetry",
+		GameMode:          "Sprint",
+		RelativeLevelPath: "OfficialLevels/Broken Symmetry.bytes",
+	}
+
+	if err := db.PlayerJoined(alice, start); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddPlays(level, []distance.Player{alice}, start.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := distance.NewClient("http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(frontend.RenderState{
+		Client:  c,
+		History: db,
+		ID:      "main",
+	}))
+	r.Mount("/player", Mount())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/player/a", nil))
+
+	body := w.Body.String()
+	for _, want := range []string{"Alice", "Online now", "Broken Symmetry", "1:01.234", "Spectrum"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("profile is missing %q", want)
+		}
+	}
+
+	// Levels link to their leaderboards.
+	if href := "/leaderboard/" + history.LevelID(level); !strings.Contains(body, href) {
+		t.Errorf("profile is missing the link %q", href)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/player/unknown", nil))
+	if w.Code != 404 {
+		t.Errorf("unexpected status %d for unknown player", w.Code)
+	}
+}
+
+func TestProfileHistoryDisabled(t *testing.T) {
+	c, err := distance.NewClient("http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(frontend.InjectRenderState(frontend.RenderState{Client: c, ID: "main"}))
+	r.Mount("/player", Mount())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/player/a", nil))
+	if w.Code != 404 || w.Body.String() != "history is disabled" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
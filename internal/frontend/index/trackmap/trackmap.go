@@ -0,0 +1,351 @@
+// Package trackmap renders a live top-down map of the cars on the current
+// level.
+package trackmap
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/go-chi/chi"
+)
+
+var trackmap = frontend.Templater.Register("trackmap", "index/trackmap/trackmap.html")
+
+// Mount mounts the track map routes.
+func Mount() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", render)
+	r.Get("/body", renderBody)
+	r.Get("/svg", renderSVG)
+	return r
+}
+
+type renderData struct {
+	frontend.RenderState
+	Map *mapData
+}
+
+func render(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+
+	if err := trackmap.Execute(w, renderData{rs, newMapData(rs.State(), rs.Trails)}); err != nil {
+		log.Println("Error rendering:", err)
+	}
+}
+
+func renderBody(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	BodyPage(frontend.GetRenderState(r.Context())).ServeHTTP(w, r)
+}
+
+// BodyPage returns the body of the map page, which is what changes as the cars
+// move. It is shared by every viewer like the other cached pages.
+func BodyPage(rs frontend.RenderState) *frontend.CachedPage {
+	return rs.Render("trackmap-body", func(w io.Writer, rs frontend.RenderState) error {
+		return frontend.Templater.Execute(w, "trackmap-body", newMapData(rs.State(), rs.Trails))
+	})
+}
+
+// renderSVG renders the map as a standalone SVG image, e.g. for embedding.
+func renderSVG(w http.ResponseWriter, r *http.Request) {
+	rs := frontend.GetRenderState(r.Context())
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := frontend.Templater.Execute(w, "trackmap-svg", newMapData(rs.State(), rs.Trails)); err != nil {
+		log.Println("Error rendering:", err)
+	}
+}
+
+const (
+	// minMapSize is the minimum width and height of the map in world units, so
+	// that a few cars close together aren't blown up.
+	minMapSize = 100
+	// mapPadding is the fraction of the map size added around the cars.
+	mapPadding = 0.1
+)
+
+// mapData is the projected map of all cars. All coordinates are in world
+// units; the SVG's viewBox does the scaling.
+type mapData struct {
+	ViewBox string
+	// Radius is the radius of a car marker. Other sizes are derived from it.
+	Radius float64
+	Cars   []mapCar
+}
+
+// FontSize returns the font size of the player names.
+func (m *mapData) FontSize() float64 { return m.Radius * 2.5 }
+
+// StrokeWidth returns the width of the trails and the heading lines.
+func (m *mapData) StrokeWidth() float64 { return m.Radius / 2 }
+
+type mapCar struct {
+	Name   string
+	Colors [][4]float32
+	Alive  bool
+	Pos    point
+	// Heading is the end of the line showing where the car is heading, or the
+	// car's position if that's unknown.
+	Heading point
+	// Trail is the car's recent positions, oldest first. It ends with Pos.
+	Trail []point
+
+	dir point // unit heading direction
+}
+
+// Fill returns the primary color of the car.
+func (c mapCar) Fill() [4]float32 { return c.color(0) }
+
+// Stroke returns the secondary color of the car.
+func (c mapCar) Stroke() [4]float32 { return c.color(1) }
+
+func (c mapCar) color(i int) [4]float32 {
+	if i < len(c.Colors) {
+		return c.Colors[i]
+	}
+	return [4]float32{1, 1, 1, 1}
+}
+
+// TrailPoints returns the trail formatted for a polyline.
+func (c mapCar) TrailPoints() string {
+	var b strings.Builder
+	for i, p := range c.Trail {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%.2f,%.2f", p.X, p.Y)
+	}
+	return b.String()
+}
+
+// newMapData projects the cars in the given state. Their trails are taken from
+// trails if there are any.
+func newMapData(state distance.ObservedState, trails frontend.Trails) *mapData {
+	if state.Summary == nil {
+		return nil
+	}
+
+	var cars []mapCar
+	bounds := newBounds()
+
+	for _, player := range state.Summary.Players {
+		pos, ok := project(player.Car.Position)
+		if !ok || player.Car.Spectator {
+			continue
+		}
+
+		car := mapCar{
+			Name:   player.Name,
+			Colors: player.Car.Colors,
+			Alive:  player.Car.Alive,
+			Pos:    pos,
+			Trail:  []point{pos},
+		}
+
+		if trails != nil {
+			if trail := trails.Trail(player.UnityPlayerGUID); len(trail) > 0 {
+				car.Trail = car.Trail[:0]
+				for _, p := range trail {
+					car.Trail = append(car.Trail, point{p[0], p[1]})
+				}
+				if car.Trail[len(car.Trail)-1] != pos {
+					car.Trail = append(car.Trail, pos)
+				}
+			}
+		}
+
+		bounds.add(pos)
+		for _, p := range car.Trail {
+			bounds.add(p)
+		}
+
+		if dx, dy, ok := heading(player.Car.Rotation); ok {
+			car.dir = point{dx, dy}
+		}
+
+		cars = append(cars, car)
+	}
+
+	if len(cars) == 0 {
+		return &mapData{}
+	}
+
+	m := &mapData{Cars: cars}
+	m.ViewBox, m.Radius = bounds.viewBox()
+
+	// Scale the heading lines with the markers.
+	for i, car := range m.Cars {
+		m.Cars[i].Heading = point{
+			X: car.Pos.X + car.dir.X*m.Radius*2.5,
+			Y: car.Pos.Y + car.dir.Y*m.Radius*2.5,
+		}
+	}
+
+	return m
+}
+
+// point is a point on the map.
+type point struct {
+	X, Y float64
+}
+
+// project projects the given world position onto the top-down map. Distance
+// is Y-up, so the map is the X-Z plane; Z is flipped so that north is up.
+func project(pos []float32) (point, bool) {
+	if len(pos) < 3 {
+		return point{}, false
+	}
+	return point{X: float64(pos[0]), Y: -float64(pos[2])}, true
+}
+
+// heading returns the unit direction that the car with the given rotation
+// quaternion (x, y, z, w) faces on the map.
+func heading(rot []float32) (dx, dy float64, ok bool) {
+	if len(rot) < 4 {
+		return 0, 0, false
+	}
+
+	x, y, z, w := float64(rot[0]), float64(rot[1]), float64(rot[2]), float64(rot[3])
+
+	// Rotate the forward vector (0, 0, 1) and project it like project does.
+	fx := 2 * (x*z + w*y)
+	fz := 1 - 2*(x*x+y*y)
+
+	l := math.Hypot(fx, fz)
+	if l < 1e-6 {
+		return 0, 0, false
+	}
+
+	return fx / l, -fz / l, true
+}
+
+type bounds struct {
+	minX, minY, maxX, maxY float64
+}
+
+func newBounds() bounds {
+	return bounds{
+		minX: math.Inf(+1),
+		minY: math.Inf(+1),
+		maxX: math.Inf(-1),
+		maxY: math.Inf(-1),
+	}
+}
+
+func (b *bounds) add(p point) {
+	b.minX = math.Min(b.minX, p.X)
+	b.minY = math.Min(b.minY, p.Y)
+	b.maxX = math.Max(b.maxX, p.X)
+	b.maxY = math.Max(b.maxY, p.Y)
+}
+
+// viewBox returns the square SVG viewBox containing the bounds and the marker
+// radius fitting it.
+func (b bounds) viewBox() (string, float64) {
+	size := math.Max(b.maxX-b.minX, b.maxY-b.minY)
+	size = math.Max(size*(1+2*mapPadding), minMapSize)
+
+	midX := (b.minX + b.maxX) / 2
+	midY := (b.minY + b.maxY) / 2
+
+	viewBox := fmt.Sprintf("%.2f %.2f %.2f %.2f", midX-size/2, midY-size/2, size, size)
+	return viewBox, size / 80
+}
+
+// trailLength is the maximum number of points kept in a trail.
+const trailLength = 60
+
+// Tracker keeps the recent positions of each car on the current level. It
+// implements frontend.Trails.
+type Tracker struct {
+	mutex  sync.Mutex
+	level  distance.Level
+	trails map[string][]point // by player GUID
+}
+
+// NewTracker starts keeping the trails of the cars observed by the given
+// Observer. The returned callback stops tracking; tracking also stops when the
+// Observer is stopped.
+func NewTracker(obs *distance.Observer) (*Tracker, func()) {
+	t := &Tracker{trails: map[string][]point{}}
+
+	if state := obs.State(); state.Summary != nil {
+		t.update(state.Summary)
+	}
+
+	ch, cancel := obs.Subscribe()
+
+	go func() {
+		for state := range ch {
+			if state.Summary != nil {
+				t.update(state.Summary)
+			}
+		}
+	}()
+
+	return t, cancel
+}
+
+// update records the car positions in the given summary. The trails are reset
+// when the level changes.
+func (t *Tracker) update(s *distance.Summary) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if s.Level.RelativeLevelPath != t.level.RelativeLevelPath ||
+		s.Level.WorkshopFileID != t.level.WorkshopFileID {
+
+		t.level = s.Level
+		t.trails = map[string][]point{}
+	}
+
+	seen := make(map[string]bool, len(s.Players))
+
+	for _, player := range s.Players {
+		pos, ok := project(player.Car.Position)
+		if !ok || player.Car.Spectator {
+			continue
+		}
+
+		seen[player.UnityPlayerGUID] = true
+
+		trail := t.trails[player.UnityPlayerGUID]
+		if len(trail) > 0 && trail[len(trail)-1] == pos {
+			continue
+		}
+
+		if len(trail) == trailLength {
+			trail = append(trail[:0], trail[1:]...)
+		}
+
+		t.trails[player.UnityPlayerGUID] = append(trail, pos)
+	}
+
+	// Forget players that left or started spectating.
+	for guid := range t.trails {
+		if !seen[guid] {
+			delete(t.trails, guid)
+		}
+	}
+}
+
+// Trail returns a copy of the trail of the given player, oldest first.
+func (t *Tracker) Trail(guid string) [][2]float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	trail := make([][2]float64, len(t.trails[guid]))
+	for i, p := range t.trails[guid] {
+		trail[i] = [2]float64{p.X, p.Y}
+	}
+	return trail
+}
@@ -0,0 +1,147 @@
+package trackmap
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+)
+
+func newSummary(level string, positions ...float32) *distance.Summary {
+	s := &distance.Summary{Level: distance.Level{RelativeLevelPath: level}}
+	for i, x := range positions {
+		s.Players = append(s.Players, distance.Player{
+			UnityPlayerGUID: string(rune('a' + i)),
+			Car:             distance.Car{Position: []float32{x, 0, 0}},
+		})
+	}
+	return s
+}
+
+func TestTracker(t *testing.T) {
+	tr := &Tracker{trails: map[string][]point{}}
+
+	for i := 0; i < trailLength+10; i++ {
+		tr.update(newSummary("a", float32(i), 0))
+	}
+
+	if trail := tr.Trail("a"); len(trail) != trailLength {
+		t.Fatalf("expected trail of %d points, got %d", trailLength, len(trail))
+	} else if last := trail[len(trail)-1]; last[0] != trailLength+9 {
+		t.Fatalf("unexpected last point %v", last)
+	}
+
+	// Stationary cars don't grow their trail.
+	if trail := tr.Trail("b"); len(trail) != 1 {
+		t.Fatalf("expected stationary trail of 1 point, got %d", len(trail))
+	}
+
+	tr.update(newSummary("a", 1))
+	if trail := tr.Trail("b"); len(trail) != 0 {
+		t.Fatalf("expected trail of a player that left to be removed, got %v", trail)
+	}
+
+	tr.update(newSummary("b", 1))
+	if trail := tr.Trail("a"); len(trail) != 1 {
+		t.Fatalf("expected trail to be reset on level change, got %v", trail)
+	}
+}
+
+func TestRenderSVG(t *testing.T) {
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{
+		UnityPlayerGUID: "a",
+		Name:            "<Alice>",
+		Car: distance.Car{
+			Colors:   [][4]float32{{1, 0, 0, 1}, {0, 0, 1, 1}},
+			Alive:    true,
+			Position: []float32{10, 5, 20},
+			Rotation: []float32{0, 0, 0, 1},
+		},
+	})
+
+	c := s.NewClient()
+	obs := distance.NewObserver(c, time.Hour)
+	defer obs.Stop()
+
+	srv := httptest.NewServer(frontend.InjectRenderState(frontend.RenderState{
+		Client:   c,
+		Observer: obs,
+	})(Mount()))
+	defer srv.Close()
+
+	r, err := srv.Client().Get(srv.URL + "/svg")
+	if err != nil {
+		t.Fatal("failed to get SVG:", err)
+	}
+	defer r.Body.Close()
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal("failed to read SVG:", err)
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "image/svg+xml" {
+		t.Fatalf("unexpected Content-Type %q", ct)
+	}
+
+	// The SVG must be well-formed XML to be usable as an image.
+	var svg struct {
+		XMLName xml.Name
+		ViewBox string `xml:"viewBox,attr"`
+		Cars    []struct {
+			Circle struct {
+				CX   string `xml:"cx,attr"`
+				CY   string `xml:"cy,attr"`
+				Fill string `xml:"fill,attr"`
+			} `xml:"circle"`
+			Text string `xml:"text"`
+		} `xml:"g>g"`
+	}
+	if err := xml.Unmarshal(b, &svg); err != nil {
+		t.Fatalf("invalid SVG: %v\n%s", err, b)
+	}
+
+	if svg.XMLName.Local != "svg" || svg.ViewBox == "" {
+		t.Fatalf("unexpected SVG root:\n%s", b)
+	}
+	if len(svg.Cars) != 1 {
+		t.Fatalf("expected 1 car, got %d:\n%s", len(svg.Cars), b)
+	}
+
+	car := svg.Cars[0]
+	if car.Circle.CX != "10" || car.Circle.CY != "-20" {
+		t.Errorf("unexpected position (%s, %s)", car.Circle.CX, car.Circle.CY)
+	}
+	// rgbaHex converts from linear RGB approximately.
+	if !strings.HasPrefix(car.Circle.Fill, "#ff") {
+		t.Errorf("unexpected fill %q", car.Circle.Fill)
+	}
+	if car.Text != "<Alice>" {
+		t.Errorf("unexpected name %q", car.Text)
+	}
+
+	// The page embeds the same SVG.
+	r, err = srv.Client().Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal("failed to get page:", err)
+	}
+	defer r.Body.Close()
+
+	b, err = io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal("failed to read page:", err)
+	}
+
+	if !strings.Contains(string(b), `<svg xmlns="http://www.w3.org/2000/svg" class="trackmap"`) {
+		t.Fatalf("page has no map:\n%s", b)
+	}
+}
@@ -0,0 +1,62 @@
+// Package outlink provides the interstitial page that chat links go through
+// before leaving the site.
+package outlink
+
+import (
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/diamondburned/distant-front/lib/distance/markup"
+	"github.com/go-chi/chi"
+)
+
+var outlink = frontend.Templater.Register("outlink", "outlink/outlink.html")
+
+// Href returns the href of the interstitial page for the given URL. It can be
+// used as a markup.URLPolicy's Redirect.
+func Href(u *url.URL) string {
+	return "/out?u=" + url.QueryEscape(u.String())
+}
+
+// Mount mounts the interstitial page. Only URLs allowed by the given policy are
+// shown.
+func Mount(siteName string, policy *markup.URLPolicy) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		u, ok := policy.Allow(r.FormValue("u"))
+		if !ok {
+			w.WriteHeader(400)
+			io.WriteString(w, "invalid or disallowed link")
+			return
+		}
+
+		// Don't leak the page's URL, which contains the link, to the linked
+		// site.
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		err := outlink.Execute(w, renderData{
+			RenderState: frontend.RenderState{SiteName: siteName},
+			URL:         u,
+		})
+		if err != nil {
+			log.Println("Error rendering:", err)
+		}
+	})
+	return r
+}
+
+type renderData struct {
+	frontend.RenderState
+	URL *url.URL
+}
+
+// Href returns the URL as trusted, since it's already allowed by the policy.
+// Otherwise, html/template would filter schemes other than http, https and
+// mailto.
+func (data renderData) Href() template.URL {
+	return template.URL(data.URL.String())
+}
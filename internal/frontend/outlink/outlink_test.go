@@ -0,0 +1,36 @@
+package outlink
+
+import (
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/diamondburned/distant-front/lib/distance/markup"
+)
+
+func TestMount(t *testing.T) {
+	h := Mount("Test", &markup.DefaultURLPolicy)
+
+	get := func(link string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/?u="+url.QueryEscape(link), nil))
+		b, _ := io.ReadAll(w.Body)
+		return w.Code, string(b)
+	}
+
+	code, body := get("https://example.com/a?b=c")
+	if code != 200 {
+		t.Fatalf("unexpected status %d: %s", code, body)
+	}
+	if !strings.Contains(body, `href="https://example.com/a?b=c"`) {
+		t.Fatalf("missing continue link:\n%s", body)
+	}
+
+	for _, link := range []string{"javascript:alert(1)", "//example.com", ""} {
+		if code, _ := get(link); code != 400 {
+			t.Errorf("expected %q to be rejected, got status %d", link, code)
+		}
+	}
+}
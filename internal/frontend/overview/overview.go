@@ -0,0 +1,38 @@
+// Package overview provides the page listing all servers when more than one
+// server is served.
+package overview
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/diamondburned/distant-front/internal/frontend"
+	"github.com/go-chi/chi"
+)
+
+var overview = frontend.Templater.Register("overview", "overview/overview.html")
+
+type renderData struct {
+	// RenderState only has SiteName, which is the name of the whole site.
+	frontend.RenderState
+	Servers []frontend.RenderState
+}
+
+// Mount mounts the overview route listing the given servers.
+func Mount(siteName string, servers []frontend.RenderState) http.Handler {
+	frontend.Templater.Preload()
+
+	data := renderData{
+		RenderState: frontend.RenderState{SiteName: siteName},
+		Servers:     servers,
+	}
+
+	r := chi.NewRouter()
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if err := overview.Execute(w, data); err != nil {
+			log.Println("Error rendering:", err)
+		}
+	})
+
+	return r
+}
@@ -0,0 +1,161 @@
+package frontend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/distant-front/lib/distance"
+)
+
+// PageCache caches pages rendered from the observed state. Each page is
+// rendered once per change of the state's StateTag and shared by every viewer
+// until the next one, so it must not depend on who is viewing it. It is safe
+// to use concurrently.
+type PageCache struct {
+	mutex sync.Mutex
+	renew time.Time
+	tag   string
+	pages map[string]*CachedPage
+}
+
+// NewPageCache creates an empty page cache.
+func NewPageCache() *PageCache {
+	return &PageCache{pages: map[string]*CachedPage{}}
+}
+
+// Page returns the named template rendered with the RenderState, as Render
+// does.
+func (rs RenderState) Page(name string) *CachedPage {
+	return rs.Render(name, func(w io.Writer, rs RenderState) error {
+		return Templater.Execute(w, name, rs)
+	})
+}
+
+// Render returns the page that render writes. It is given the RenderState
+// with the observed state that the page's Tag is of, which it must render
+// from, using State. The page is taken from rs.Pages by name if there is one
+// and rendered at most once per state; otherwise, it is rendered for this call
+// only.
+func (rs RenderState) Render(name string, render func(io.Writer, RenderState) error) *CachedPage {
+	state := rs.State()
+
+	c := rs.Pages
+	if c == nil {
+		return newCachedPage(StateTag(state), rs, state, render)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// The tag is computed once per tick, and the pages are only invalidated
+	// when it changes, so that idle ticks reuse them. A state older than the
+	// cached pages gets its own.
+	switch {
+	case state.LastRenew.Before(c.renew):
+		return newCachedPage(StateTag(state), rs, state, render)
+	case state.LastRenew.After(c.renew):
+		c.renew = state.LastRenew
+		if tag := StateTag(state); tag != c.tag || tag == "" {
+			c.tag = tag
+			c.pages = map[string]*CachedPage{}
+		}
+	}
+
+	page, ok := c.pages[name]
+	if !ok {
+		page = newCachedPage(c.tag, rs, state, render)
+		c.pages[name] = page
+	}
+
+	return page
+}
+
+// newCachedPage creates a page with the given tag that renders from the given
+// state.
+func newCachedPage(tag string, rs RenderState, state distance.ObservedState, render func(io.Writer, RenderState) error) *CachedPage {
+	rs.state = &state
+	return &CachedPage{Tag: tag, render: func(w io.Writer) error { return render(w, rs) }}
+}
+
+// CachedPage is a page rendered from a single observed state. It is rendered
+// the first time it's needed, and concurrent callers wait for the same render.
+type CachedPage struct {
+	// Tag is the StateTag of the state that the page is rendered from.
+	Tag string
+
+	once   sync.Once
+	render func(io.Writer) error
+	html   []byte
+	gzip   []byte
+}
+
+// Render returns the rendered page, and the same compressed with gzip. They
+// are nil if the page couldn't be rendered.
+func (page *CachedPage) Render() (html, gz []byte) {
+	page.once.Do(func() {
+		var b bytes.Buffer
+		if err := page.render(&b); err != nil {
+			log.Println("Error rendering:", err)
+			return
+		}
+
+		var z bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&z, gzip.BestCompression)
+		zw.Write(b.Bytes())
+		zw.Close()
+
+		page.html = b.Bytes()
+		page.gzip = z.Bytes()
+	})
+
+	return page.html, page.gzip
+}
+
+// ServeHTTP writes the page, compressed if the client accepts gzip. A page
+// that couldn't be rendered is a 500.
+func (page *CachedPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, gz := page.Render()
+	if body == nil {
+		w.WriteHeader(500)
+		io.WriteString(w, "failed to render page")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		body = gz
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+// acceptsGzip returns true if the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(enc, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "gzip") {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			q := strings.TrimPrefix(strings.TrimSpace(param), "q=")
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
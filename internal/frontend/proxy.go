@@ -0,0 +1,100 @@
+package frontend
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustProxies returns a middleware that replaces the RemoteAddr of requests
+// from the given reverse proxies with the client address that they forwarded,
+// so that ClientIP returns it. The Forwarded header is preferred over
+// X-Forwarded-For. Since clients can send either header themselves, addresses
+// are read from the right, and the first one that isn't a trusted proxy is the
+// client.
+func TrustProxies(proxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(proxies) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedClient(r, proxies); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient returns the client address forwarded by the proxies, or an
+// empty string if the request didn't come through one.
+func forwardedClient(r *http.Request, proxies []*net.IPNet) string {
+	trusted := func(ip net.IP) bool {
+		for _, proxy := range proxies {
+			if proxy.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ip := net.ParseIP(ClientIP(r)); ip == nil || !trusted(ip) {
+		return ""
+	}
+
+	hops := forwardedHops(r.Header)
+
+	var client string
+	for i := len(hops) - 1; i >= 0; i-- {
+		// Nothing before a hop that isn't an address, e.g. "unknown", can be
+		// believed.
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+
+		client = ip.String()
+		if !trusted(ip) {
+			break
+		}
+	}
+
+	return client
+}
+
+// forwardedHops returns the addresses that the request was forwarded for,
+// first hop first.
+func forwardedHops(h http.Header) []string {
+	var hops []string
+
+	if forwarded := h.Values("Forwarded"); len(forwarded) > 0 {
+		for _, elem := range strings.Split(strings.Join(forwarded, ","), ",") {
+			var hop string
+			for _, pair := range strings.Split(elem, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hop = stripPort(strings.Trim(kv[1], `"`))
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	for _, xff := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(xff, ",") {
+			hops = append(hops, stripPort(strings.TrimSpace(hop)))
+		}
+	}
+
+	return hops
+}
+
+// stripPort strips the port and the IPv6 brackets from the address, if any.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
@@ -0,0 +1,45 @@
+package frontend
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustProxies(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		remote string
+		header string
+		value  string
+		want   string
+	}{
+		{"192.0.2.1:1234", "X-Forwarded-For", "198.51.100.1", "192.0.2.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "203.0.113.9, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "unknown", "10.0.0.1"},
+		{"10.0.0.1:1234", "Forwarded", `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`, "2001:db8::1"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+	}
+
+	var got string
+	h := TrustProxies([]*net.IPNet{proxies})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		if got != test.want {
+			t.Errorf("%s: %s %q: expected %s, got %s", test.remote, test.header, test.value, test.want, got)
+		}
+	}
+}
@@ -1,4 +1,8 @@
-const chatMessages = document.querySelector("#chat-box > .chat-messages");
+const chatBox = document.getElementById("chat-box");
+const chatMessages = chatBox.querySelector(".chat-messages");
+
+// prefix is the path prefix of the current server.
+const prefix = chatBox.dataset.prefix;
 const timeFmt = new Intl.DateTimeFormat(undefined, { timeStyle: "short" });
 
 function localizeTimeInNode(node) {
@@ -42,64 +46,42 @@ function hasCookies(...cookies) {
 // column-reverse, the last message is the first one in the DOM tree.
 const LastSelector = "div.chat-messages > div.chat-message:first-child";
 
-// MagicExpire is the magical expired HTML string; it is copy-pasted from the Go
-// implementation.
-const MagicExpire = "<!-- SESSION EXPIRED -->";
-
-// listen opens a persistent HTTP connection to receive null-delimited HTML
-// chunks.
-async function listen() {
+// listen listens to the chat's event stream. The browser reconnects on its own
+// and resumes after the last message, so the stream only has to be closed once
+// the session expires.
+function listen() {
   const last = document.querySelector(LastSelector);
-  const utf8 = new TextDecoder("utf-8");
+  const events = new EventSource(`${prefix}/chat/listen/${last ? last.id : ""}`);
 
-  const resp = await fetch(`/chat/listen/${last ? last.id : ""}`);
-  if (!resp.ok) throw `unexpected ${resp.status}, reason ${await resp.text()}`;
+  events.addEventListener("message", (ev) => {
+    // Skip the messages that are already shown.
+    if (ev.lastEventId && document.getElementById(ev.lastEventId)) return;
+    addMessageHTML(ev.data);
+  });
 
-  const reader = resp.body.getReader();
-  var textBuf = [];
-
-  while (true) {
-    const packet = await reader.read();
-    if (!packet || packet.done) throw `unexpected close: ${textBuf.join("")}`;
-    if (!packet.value) continue; // empty read; not an error until EOF.
-
-    let text = utf8.decode(packet.value);
-
-    // Iterate until we're out of delimiters.
-    while (true) {
-      let delim = text.indexOf("\0");
-      if (delim < 0) {
-        // Buffer, stop looking and continue reading.
-        textBuf.push(text);
-        break;
-      }
-
-      // Push the complete segment and join.
-      textBuf.push(text.slice(0, delim));
-      const message = textBuf.join("");
-
-      // Clear the buffer but allow space reusing and add the tail of the chunk
-      // in.
-      textBuf.length = 0;
-      text = text.slice(delim + 1);
-
-      // Check for specially-treated messages; treat as regular HTML otherwise.
-      switch (message) {
-        case "<!-- SESSION EXPIRED -->":
-          return;
-        case "<!-- SERVER HALTED -->":
-          throw "server halted; retry.";
-        default:
-          addMessageHTML(message);
-      }
-    }
-  }
+  events.addEventListener("expired", () => {
+    events.close();
+
+    // We should only unlink if we're linked in the first place. We can know
+    // this because the server will render the button if we are.
+    const unlinkButton = document.getElementById("unlink-button");
+    if (unlinkButton) unlinkButton.click();
+  });
+
+  events.addEventListener("halted", () => {
+    console.info("Server halted; reconnecting.");
+  });
 }
 
+// loadedOlder is true if the user has loaded older messages, in which case we
+// shouldn't clean them up.
+var loadedOlder = false;
+
 function addMessageHTML(html) {
   if (!html) return;
 
   chatMessages.insertAdjacentHTML("afterbegin", html);
+  if (loadedOlder) return;
 
   // Clean up messages. This preserves the last 50 messages.
   const elems = chatMessages.getElementsByClassName("chat-message");
@@ -108,41 +90,52 @@ function addMessageHTML(html) {
   }
 }
 
-async function sleep(ms) {
-  return new Promise((resolve) => setTimeout(resolve, ms));
-}
+// Start listening.
+listen();
 
-// backgroundLoop is the long polling loop.
-async function backgroundLoop() {
-  const unlinkButton = document.getElementById("unlink-button");
+const chatOlder = document.getElementById("chat-older");
 
-  while (true) {
-    const timeout = sleep(10000); // 10s promise
+// loadOlder fetches the messages older than the oldest one on the page and
+// inserts them before the "Load older" button.
+async function loadOlder() {
+  const oldest = chatOlder.previousElementSibling;
+  if (!oldest) return;
 
-    try {
-      await listen();
-
-      // We should only unlink if we're linked in the first place. We can know
-      // this because the server will render the button if we are.
-      if (unlinkButton) {
-        unlinkButton.click();
-        return;
-      }
-    } catch (err) {
-      console.error(`Listen error: ${err}`);
-    }
+  const resp = await fetch(`${prefix}/chat/history/${oldest.id}`);
+  if (!resp.ok) throw `unexpected ${resp.status}, reason ${await resp.text()}`;
 
-    // Wait for the timeout before retrying.
-    await timeout;
+  const messages = await resp.json();
+  if (messages.length === 0) {
+    chatOlder.remove();
+    return;
   }
+
+  loadedOlder = true;
+  messages.forEach((msg) => chatOlder.insertAdjacentHTML("beforebegin", msg.HTML));
 }
 
-// Start the background loop.
-backgroundLoop();
+if (chatOlder) {
+  chatOlder.addEventListener("click", async (ev) => {
+    ev.preventDefault();
+    try {
+      await loadOlder();
+    } catch (err) {
+      console.error(`failed to load older messages: ${err}`);
+    }
+  });
+}
 
 const chatSend = document.querySelector("form#chat-send"),
   chatInput = chatSend.querySelector("input[type='text']"),
-  chatButton = chatSend.querySelector("button[type='submit']");
+  chatButton = chatSend.querySelector("button[type='submit']"),
+  chatFeedback = document.getElementById("chat-feedback");
+
+// showFeedback shows why the last message wasn't sent. An empty string hides
+// it.
+function showFeedback(text) {
+  chatFeedback.textContent = text;
+  chatFeedback.hidden = !text;
+}
 
 // Start binding the sending form to remove the need to reload the page.
 
@@ -158,27 +151,81 @@ chatButton.addEventListener("click", async (ev) => {
   await sendMessage();
 });
 
+// live is the WebSocket that messages are sent over. Without JavaScript, the
+// form is posted instead.
+var live = null;
+var lastRequest = 0;
+const pending = new Map(); // request ID -> callback taking the Result
+
+// connectLive connects to the live updates to send messages, and reconnects
+// after a while if the connection is lost.
+function connectLive() {
+  const scheme = location.protocol == "https:" ? "wss:" : "ws:";
+  const ws = new WebSocket(`${scheme}//${location.host}${prefix}/live`);
+
+  ws.addEventListener("open", () => (live = ws));
+
+  ws.addEventListener("message", (ev) => {
+    const msg = JSON.parse(ev.data);
+    if (msg.Type != "result") return;
+
+    const done = pending.get(msg.Data.ID);
+    if (done) {
+      pending.delete(msg.Data.ID);
+      done(msg.Data);
+    }
+  });
+
+  ws.addEventListener("close", () => {
+    live = null;
+
+    pending.forEach((done) => done({ Error: "connection lost" }));
+    pending.clear();
+
+    setTimeout(connectLive, 5000);
+  });
+}
+
+connectLive();
+
+// request sends the message over the live connection and resolves to its
+// Result.
+function request(message) {
+  if (!live) {
+    return Promise.resolve({ Error: "not connected, try again shortly" });
+  }
+
+  const id = String(++lastRequest);
+
+  return new Promise((resolve) => {
+    pending.set(id, resolve);
+    live.send(JSON.stringify({ Type: "send", ID: id, Message: message }));
+  });
+}
+
 async function sendMessage() {
   const m = chatInput.value;
-  if (!m) return;
+  if (!m || chatButton.disabled) return;
 
   chatButton.disabled = true;
   chatInput.disabled = true;
   chatInput.value = "";
 
-  try {
-    const r = await fetch(`/chat?m=${encodeURIComponent(m)}`, {
-      method: "POST",
-      redirect: "manual",
-      credentials: "same-origin",
-    });
-    // Expect a redirection on success.
-    if (r.type != "opaqueredirect") {
-      throw `unexpected ${r.status} response: ${await r.text()}`;
-    }
-  } catch (err) {
+  // The error says why the message wasn't sent, e.g. because it was
+  // throttled, in which case sending is disabled until it can be retried.
+  const result = await request(m);
+  if (result.Error) {
     chatInput.value = m;
-    console.error(`failed to send message: ${err}`);
+    showFeedback(`Message not sent: ${result.Error}`);
+    console.error(`failed to send message: ${result.Error}`);
+  } else {
+    showFeedback("");
+  }
+
+  if (result.RetryAfter) {
+    setTimeout(() => (chatButton.disabled = false), result.RetryAfter * 1000);
+    chatInput.disabled = false;
+    return;
   }
 
   chatButton.disabled = false;
@@ -1,13 +1,17 @@
+const mainSelector = document.querySelector(".refresh-me");
+
+// prefix is the path prefix of the current server.
+const prefix = mainSelector.dataset.prefix;
+
 function changeChatPopup() {
-  document.querySelectorAll("a[href='/chat']").forEach((a) => {
+  document.querySelectorAll("a.chat-popup").forEach((a) => {
     a.href = "#";
     a.addEventListener("click", () => {
-      window.open("/chat", "popup", "width=450, height=320");
+      window.open(`${prefix}/chat`, "popup", "width=450, height=320");
     });
   });
 }
 
-const mainSelector = document.querySelector(".refresh-me");
 const main = new Reef(mainSelector, {
   data: "",
   template: (data) => data,
@@ -34,10 +38,13 @@ async function update() {
   loading.render();
 
   try {
-    const resp = await fetch("body");
-    main.data = await resp.text();
-    main.render();
-    changeChatPopup();
+    // The browser revalidates the cached body with its ETag, so the server
+    // only renders it again if the state changed.
+    const resp = await fetch(`${prefix}/body`, { cache: "no-cache" });
+    const body = await resp.text();
+    if (body != main.data) {
+      setBody(body);
+    }
 
     loading.data = "waiting";
   } catch (err) {
@@ -47,6 +54,43 @@ async function update() {
   loading.render();
 }
 
-setInterval(update, 3500);
+function setBody(html) {
+  main.data = html;
+  main.render();
+  changeChatPopup();
+}
+
+// listen listens to the live updates of the page. It falls back to polling
+// while the connection is down, and reconnects after a while.
+function listen() {
+  const scheme = location.protocol == "https:" ? "wss:" : "ws:";
+  const ws = new WebSocket(`${scheme}//${location.host}${prefix}/live?body=1`);
+
+  let poll;
+
+  ws.addEventListener("open", () => {
+    loading.data = "waiting";
+    loading.render();
+  });
+
+  ws.addEventListener("message", (ev) => {
+    const msg = JSON.parse(ev.data);
+    if (msg.Type == "body") {
+      setBody(msg.Data.HTML);
+    }
+  });
+
+  ws.addEventListener("close", () => {
+    poll = setInterval(update, 3500);
+    update();
+
+    setTimeout(() => {
+      clearInterval(poll);
+      listen();
+    }, 5000);
+  });
+}
+
+listen();
 loading.render();
 changeChatPopup();
@@ -0,0 +1,41 @@
+const mapBody = document.getElementById("trackmap-body");
+
+// prefix is the path prefix of the current server.
+const mapPrefix = mapBody.dataset.prefix;
+
+async function updateMap() {
+  try {
+    const resp = await fetch(`${mapPrefix}/map/body`);
+    if (resp.ok) {
+      mapBody.innerHTML = await resp.text();
+    }
+  } catch (err) {
+    console.error("failed to update map:", err);
+  }
+}
+
+// listenMap listens to the live updates of the map. It falls back to polling
+// while the connection is down, and reconnects after a while.
+function listenMap() {
+  const scheme = location.protocol == "https:" ? "wss:" : "ws:";
+  const ws = new WebSocket(`${scheme}//${location.host}${mapPrefix}/live?map=1`);
+
+  ws.addEventListener("message", (ev) => {
+    const msg = JSON.parse(ev.data);
+    if (msg.Type == "map") {
+      mapBody.innerHTML = msg.Data.HTML;
+    }
+  });
+
+  ws.addEventListener("close", () => {
+    const poll = setInterval(updateMap, 1000);
+    updateMap();
+
+    setTimeout(() => {
+      clearInterval(poll);
+      listenMap();
+    }, 5000);
+  });
+}
+
+listenMap();
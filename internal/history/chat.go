@@ -0,0 +1,170 @@
+package history
+
+import (
+	"encoding/json"
+
+	"github.com/dgraph-io/badger"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/pkg/errors"
+)
+
+const (
+	chatPrefix     = "chat/t/" // chat/t/{time}/{guid} -> ChatMessage
+	chatGUIDPrefix = "chat/g/" // chat/g/{guid} -> chat/t/ key
+)
+
+// AddChat adds the given messages into the chat history. Messages whose GUIDs
+// are already in the history are skipped.
+func (db *DB) AddChat(msgs ...distance.ChatMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	err := db.db.Update(func(txn *badger.Txn) error {
+		for _, msg := range msgs {
+			guidKey := []byte(chatGUIDPrefix + msg.GUID)
+
+			_, err := txn.Get(guidKey)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+
+			key := timeKey(chatPrefix, msg.Time(), msg.GUID)
+
+			if err := setJSON(txn, key, msg); err != nil {
+				return err
+			}
+			if err := txn.Set(guidKey, key); err != nil {
+				return err
+			}
+			if senderKey := chatSenderKey(msg); senderKey != nil {
+				if err := txn.Set(senderKey, key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	return errors.Wrap(err, "failed to add chat")
+}
+
+// ChatBefore returns at most limit messages that are older than the message
+// with the given GUID, sorted oldest first. If the GUID is empty, then the
+// latest messages are returned. If the GUID is not in the history, then nil is
+// returned.
+func (db *DB) ChatBefore(guid string, limit int) ([]distance.ChatMessage, error) {
+	var msgs []distance.ChatMessage
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		var seek []byte
+
+		if guid == "" {
+			// Seek past the end of the prefix, so the latest message is first.
+			seek = []byte(chatPrefix + "\xFF")
+		} else {
+			item, err := txn.Get([]byte(chatGUIDPrefix + guid))
+			if err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					return nil
+				}
+				return err
+			}
+
+			seek, err = item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		opts.Prefix = []byte(chatPrefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(seek); it.Valid() && len(msgs) < limit; it.Next() {
+			item := it.Item()
+			// Skip the message that we're seeking from.
+			if guid != "" && string(item.Key()) == string(seek) {
+				continue
+			}
+
+			var msg distance.ChatMessage
+			err := item.Value(func(b []byte) error {
+				return json.Unmarshal(b, &msg)
+			})
+			if err != nil {
+				return err
+			}
+
+			msgs = append(msgs, msg)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get chat")
+	}
+
+	// We iterated backwards, so flip the slice.
+	for i := len(msgs)/2 - 1; i >= 0; i-- {
+		opp := len(msgs) - 1 - i
+		msgs[i], msgs[opp] = msgs[opp], msgs[i]
+	}
+
+	return msgs, nil
+}
+
+// ChatSince returns the message with the given GUID followed by at most limit
+// messages that are newer than it, sorted oldest first. If the GUID is not in
+// the history, then nil is returned.
+func (db *DB) ChatSince(guid string, limit int) ([]distance.ChatMessage, error) {
+	var msgs []distance.ChatMessage
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(chatGUIDPrefix + guid))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		seek, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(chatPrefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(seek); it.Valid() && len(msgs) <= limit; it.Next() {
+			var msg distance.ChatMessage
+			err := it.Item().Value(func(b []byte) error {
+				return json.Unmarshal(b, &msg)
+			})
+			if err != nil {
+				return err
+			}
+
+			msgs = append(msgs, msg)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get chat")
+	}
+
+	return msgs, nil
+}
@@ -0,0 +1,211 @@
+// Package history records the Observer's history into an on-disk database, so
+// that it outlives the game server's own memory.
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/pkg/errors"
+)
+
+// DB is the history database.
+type DB struct {
+	db *badger.DB
+
+	// OnError is called on a recording error. By default, it logs to console.
+	OnError func(error)
+}
+
+// Open opens the history database at the given path. The directory is created
+// if it does not exist.
+func Open(path string) (*DB, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create directory")
+	}
+
+	opts := badger.DefaultOptions(path)
+	opts.EventLogging = false
+	opts.SyncWrites = false
+	opts.Truncate = true
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open badger")
+	}
+
+	return &DB{
+		db: db,
+		OnError: func(err error) {
+			log.Println("[history] Record error:", err)
+		},
+	}, nil
+}
+
+// Close closes the database.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// seenEvery is how often the players on the server are marked as seen while
+// recording. A visit that recording didn't see end is cut short by at most
+// this much.
+const seenEvery = time.Minute
+
+// Record starts recording the observer's events into the database in the
+// background. The returned callback stops recording and ends the visits of
+// the players on the server; recording also stops when the observer is
+// stopped.
+func (db *DB) Record(obs *distance.Observer) (stop func()) {
+	evCh, cancelEvents := obs.SubscribeEvents()
+	stateCh, cancelStates := obs.Subscribe()
+
+	// Record what's already in the state, since events only contain changes.
+	// Duplicates are ignored, so it doesn't matter if the events contain them
+	// again.
+	var players []distance.Player
+
+	if state := obs.State(); state.Summary != nil {
+		if err := db.AddChat(state.Summary.ChatLog...); err != nil {
+			db.OnError(err)
+		}
+		players = state.Summary.Players
+	}
+
+	lastSeen := time.Now()
+	if err := db.resumePlayers(players, lastSeen); err != nil {
+		db.OnError(err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for evCh != nil || stateCh != nil {
+			select {
+			case ev, ok := <-evCh:
+				if !ok {
+					evCh = nil
+					continue
+				}
+				if err := db.record(ev); err != nil {
+					db.OnError(err)
+				}
+
+			case state, ok := <-stateCh:
+				if !ok {
+					stateCh = nil
+					continue
+				}
+				// Players aren't seen while the server is down, so their
+				// visits end when it went down.
+				if state.Summary == nil || state.IsDown() || state.LastRenew.Sub(lastSeen) < seenEvery {
+					continue
+				}
+				lastSeen = state.LastRenew
+				if err := db.markSeen(state.Summary.Players, lastSeen); err != nil {
+					db.OnError(err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancelEvents()
+		cancelStates()
+		<-done
+
+		if err := db.endVisits(time.Now()); err != nil {
+			db.OnError(err)
+		}
+	}
+}
+
+func (db *DB) record(ev distance.Event) error {
+	switch ev := ev.(type) {
+	case distance.ChatMessageReceivedEvent:
+		return db.AddChat(ev.Message)
+
+	case distance.PlayerJoinedEvent:
+		return db.PlayerJoined(ev.Player, time.Now())
+
+	case distance.PlayerLeftEvent:
+		return db.PlayerLeft(ev.Player, time.Now())
+
+	case distance.LevelChangedEvent:
+		now := time.Now()
+
+		if err := db.AddPlays(ev.Previous, ev.Standings, now); err != nil {
+			return err
+		}
+
+		// Only record results of levels that someone actually finished.
+		for _, player := range ev.Standings {
+			if player.Car.Finished {
+				return db.AddResult(NewResult(ev.Previous, ev.Standings, now))
+			}
+		}
+	}
+	return nil
+}
+
+// timeKey creates a key that sorts by the given time then by the given ID.
+func timeKey(prefix string, t time.Time, id string) []byte {
+	key := make([]byte, 0, len(prefix)+8+1+len(id))
+	key = append(key, prefix...)
+	key = appendTime(key, t)
+	key = append(key, '/')
+	key = append(key, id...)
+	return key
+}
+
+func appendTime(key []byte, t time.Time) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(t.UnixNano()))
+	return append(key, b[:]...)
+}
+
+func getJSON(txn *badger.Txn, key []byte, v interface{}) error {
+	item, err := txn.Get(key)
+	if err != nil {
+		return err
+	}
+	return item.Value(func(b []byte) error {
+		return json.Unmarshal(b, v)
+	})
+}
+
+// iterateJSON unmarshals every value with the given key prefix into the value
+// returned by next.
+func iterateJSON(txn *badger.Txn, prefix string, next func() interface{}) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(prefix)
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		err := it.Item().Value(func(b []byte) error {
+			return json.Unmarshal(b, next())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setJSON(txn *badger.Txn, key []byte, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal")
+	}
+	return txn.Set(key, b)
+}
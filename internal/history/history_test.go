@@ -0,0 +1,358 @@
+package history
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal("failed to open:", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func newMessages(n int) []distance.ChatMessage {
+	msgs := make([]distance.ChatMessage, n)
+	for i := range msgs {
+		msgs[i] = distance.ChatMessage{
+			GUID:      "msg-" + strconv.Itoa(i),
+			Timestamp: float64(1600000000 + i),
+			Chat:      "message " + strconv.Itoa(i),
+		}
+	}
+	return msgs
+}
+
+func guids(msgs []distance.ChatMessage) []string {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.GUID
+	}
+	return ids
+}
+
+func TestChatBefore(t *testing.T) {
+	db := openTestDB(t)
+	msgs := newMessages(10)
+
+	// Add out of order and with duplicates.
+	if err := db.AddChat(msgs[5:]...); err != nil {
+		t.Fatal("failed to add chat:", err)
+	}
+	if err := db.AddChat(msgs...); err != nil {
+		t.Fatal("failed to add chat:", err)
+	}
+
+	tests := []struct {
+		before string
+		limit  int
+		want   []distance.ChatMessage
+	}{
+		{"", 3, msgs[7:]},
+		{"", 20, msgs},
+		{"msg-7", 3, msgs[4:7]},
+		{"msg-2", 5, msgs[:2]},
+		{"msg-0", 5, nil},
+		{"unknown", 5, nil},
+	}
+
+	for _, test := range tests {
+		got, err := db.ChatBefore(test.before, test.limit)
+		if err != nil {
+			t.Fatal("failed to get chat:", err)
+		}
+
+		if want, got := guids(test.want), guids(got); !equalStrings(want, got) {
+			t.Errorf("before %q limit %d: expected %v, got %v", test.before, test.limit, want, got)
+		}
+	}
+}
+
+func TestChatSince(t *testing.T) {
+	db := openTestDB(t)
+	msgs := newMessages(10)
+
+	if err := db.AddChat(msgs...); err != nil {
+		t.Fatal("failed to add chat:", err)
+	}
+
+	tests := []struct {
+		since string
+		limit int
+		want  []distance.ChatMessage
+	}{
+		{"msg-7", 5, msgs[7:]},
+		{"msg-2", 3, msgs[2:6]},
+		{"msg-9", 5, msgs[9:]},
+		{"unknown", 5, nil},
+	}
+
+	for _, test := range tests {
+		got, err := db.ChatSince(test.since, test.limit)
+		if err != nil {
+			t.Fatal("failed to get chat:", err)
+		}
+
+		if want, got := guids(test.want), guids(got); !equalStrings(want, got) {
+			t.Errorf("since %q limit %d: expected %v, got %v", test.since, test.limit, want, got)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db := openTestDB(t)
+
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	s.AddChat(distance.ChatMessage{Chat: "before"})
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	stop := db.Record(obs)
+	defer stop()
+
+	s.AddChat(distance.ChatMessage{Chat: "after"})
+	obs.Renew()
+
+	var msgs []distance.ChatMessage
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; {
+		var err error
+		msgs, err = db.ChatBefore("", 10)
+		if err != nil {
+			t.Fatal("failed to get chat:", err)
+		}
+		if len(msgs) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(msgs) != 2 || msgs[0].Chat != "before" || msgs[1].Chat != "after" {
+		t.Fatalf("unexpected recorded messages %#v", msgs)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResults(t *testing.T) {
+	db := openTestDB(t)
+
+	level := distance.Level{
+		Name:              "Broken Symmetry",
+		RelativeLevelPath: "OfficialLevels/Broken Symmetry.bytes",
+		GameMode:          "Sprint",
+	}
+
+	newPlayer := func(guid string, finish distance.FinishType, data int) distance.Player {
+		return distance.Player{
+			UnityPlayerGUID: guid,
+			Name:            guid,
+			Car: distance.Car{
+				Finished:   finish != distance.NoneFinish,
+				FinishType: finish,
+				FinishData: data,
+			},
+		}
+	}
+
+	start := time.Unix(1600000000, 0)
+
+	results := []Result{
+		NewResult(level, []distance.Player{
+			newPlayer("a", distance.DNFFinish, 0),
+			newPlayer("b", distance.NormalFinish, 62000),
+			newPlayer("c", distance.NormalFinish, 60000),
+		}, start),
+		NewResult(level, []distance.Player{
+			newPlayer("a", distance.NormalFinish, 59000),
+			newPlayer("b", distance.NormalFinish, 63000),
+		}, start.Add(time.Hour)),
+	}
+
+	if s := results[0].Standings; s[0].PlayerGUID != "c" || s[2].PlayerGUID != "a" {
+		t.Fatalf("unexpected standings order %#v", s)
+	}
+
+	for _, result := range results {
+		if err := db.AddResult(result); err != nil {
+			t.Fatal("failed to add result:", err)
+		}
+	}
+
+	id := LevelID(level)
+
+	info, err := db.Level(id)
+	if err != nil {
+		t.Fatal("failed to get level:", err)
+	}
+	if info == nil || info.Plays != 2 || !info.LastPlayed.Equal(start.Add(time.Hour)) {
+		t.Fatalf("unexpected level info %#v", info)
+	}
+
+	times, err := db.Leaderboard(id)
+	if err != nil {
+		t.Fatal("failed to get leaderboard:", err)
+	}
+
+	var got []string
+	for _, time := range times {
+		got = append(got, time.PlayerGUID+":"+strconv.Itoa(time.FinishData))
+	}
+
+	if want := []string{"a:59000", "c:60000", "b:62000"}; !equalStrings(want, got) {
+		t.Fatalf("expected leaderboard %v, got %v", want, got)
+	}
+
+	recent, err := db.Results(id, 1)
+	if err != nil {
+		t.Fatal("failed to get results:", err)
+	}
+	if len(recent) != 1 || !recent[0].EndedAt.Equal(start.Add(time.Hour)) {
+		t.Fatalf("unexpected recent results %#v", recent)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	db := openTestDB(t)
+
+	level := distance.Level{Name: "Broken Symmetry", GameMode: "Sprint"}
+	colors := [][4]float32{{1, 0, 0, 1}}
+
+	player := func(finish distance.FinishType, data int) distance.Player {
+		return distance.Player{
+			UnityPlayerGUID: "a",
+			Name:            "Alice",
+			Car: distance.Car{
+				Name:       "Spectrum",
+				Colors:     colors,
+				Finished:   finish != distance.NoneFinish,
+				FinishType: finish,
+				FinishData: data,
+			},
+		}
+	}
+
+	start := time.Unix(1600000000, 0)
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	must(db.PlayerJoined(player(distance.NoneFinish, 0), start))
+	must(db.AddPlays(level, []distance.Player{player(distance.NormalFinish, 62000)}, start.Add(time.Minute)))
+	must(db.AddPlays(level, []distance.Player{player(distance.DNFFinish, 0)}, start.Add(2*time.Minute)))
+	must(db.AddPlays(level, []distance.Player{player(distance.NormalFinish, 60000)}, start.Add(3*time.Minute)))
+	must(db.PlayerLeft(player(distance.NoneFinish, 0), start.Add(time.Hour)))
+
+	must(db.AddChat(
+		distance.ChatMessage{GUID: "1", Sender: "a", Timestamp: float64(start.Unix()), Chat: "hi"},
+		distance.ChatMessage{GUID: "2", Sender: "server", Timestamp: float64(start.Unix()), Chat: "welcome"},
+	))
+
+	// A visit that the last run left open ends when the player was last seen.
+	must(db.PlayerJoined(player(distance.NoneFinish, 0), start.Add(2*time.Hour)))
+	must(db.AddPlays(level, []distance.Player{player(distance.DNFFinish, 0)}, start.Add(3*time.Hour)))
+	must(db.resumePlayers(nil, start.Add(4*time.Hour)))
+
+	profile, err := db.Profile("a")
+	must(err)
+
+	if profile == nil || profile.Name != "Alice" || !profile.FirstSeen.Equal(start) || profile.IsOnline() {
+		t.Fatalf("unexpected profile %#v", profile)
+	}
+	if profile.TimeOnline != 2*time.Hour || profile.Plays != 4 ||
+		profile.Finishes[distance.NormalFinish] != 2 || profile.Finishes[distance.DNFFinish] != 2 {
+		t.Fatalf("unexpected profile stats %#v", profile)
+	}
+	if len(profile.Cars) != 1 || profile.Cars[0].Name != "Spectrum" || profile.Cars[0].Plays != 4 {
+		t.Fatalf("unexpected cars %#v", profile.Cars)
+	}
+
+	levels, err := db.PlayerLevels("a")
+	must(err)
+
+	if len(levels) != 1 || levels[0].Plays != 4 || levels[0].Finishes != 2 || levels[0].Best != 60000 {
+		t.Fatalf("unexpected levels %#v", levels)
+	}
+
+	msgs, err := db.PlayerChat("a", 10)
+	must(err)
+
+	if want, got := []string{"1"}, guids(msgs); !equalStrings(want, got) {
+		t.Fatalf("expected chat %v, got %v", want, got)
+	}
+
+	if profile, err := db.Profile("unknown"); err != nil || profile != nil {
+		t.Fatalf("unexpected unknown profile %#v, %v", profile, err)
+	}
+}
+
+func TestVisits(t *testing.T) {
+	db := openTestDB(t)
+
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	alice := distance.Player{UnityPlayerGUID: "a", Name: "Alice"}
+	bob := distance.Player{UnityPlayerGUID: "b", Name: "Bob"}
+	s.AddPlayer(alice)
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	stop := db.Record(obs)
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	later := time.Now().Add(time.Hour)
+
+	// Only players who are already on the server are marked as seen.
+	must(db.markSeen([]distance.Player{alice, bob}, later))
+
+	stop()
+
+	alicep, err := db.Profile("a")
+	must(err)
+
+	if alicep == nil || alicep.IsOnline() || !alicep.LastSeen.Equal(later) {
+		t.Fatalf("unexpected profile after stopping %#v", alicep)
+	}
+
+	bobp, err := db.Profile("b")
+	must(err)
+
+	if bobp != nil {
+		t.Fatalf("unexpected profile of a player who never joined %#v", bobp)
+	}
+}
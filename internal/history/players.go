@@ -0,0 +1,459 @@
+package history
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/pkg/errors"
+)
+
+const (
+	playerPrefix      = "player/"      // player/{guid} -> Profile
+	onlinePrefix      = "online/"      // online/{guid} -> nothing
+	playerLevelPrefix = "playerlevel/" // playerlevel/{guid}/{levelID} -> PlayerLevel
+	chatSenderPrefix  = "chat/s/"      // chat/s/{sender}/{time}/{guid} -> chat/t/ key
+)
+
+// maxCars is the maximum number of cars kept in a Profile. The least used ones
+// are forgotten first.
+const maxCars = 20
+
+// Profile is what is known about a player across their visits, keyed by their
+// UnityPlayerGUID.
+type Profile struct {
+	GUID string
+	// Name is the name that the player was last seen with.
+	Name      string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// OnlineSince is when the player joined, if they are on the server.
+	OnlineSince time.Time `json:",omitempty"`
+	// TimeOnline is the time spent on the server, not counting the current
+	// visit.
+	TimeOnline time.Duration
+	// Plays is the number of levels that the player was in when they ended,
+	// not counting spectating.
+	Plays int
+	// Finishes counts how each of the plays ended.
+	Finishes map[distance.FinishType]int
+	// Cars are the cars that the player played with, most used first.
+	Cars []CarUse
+}
+
+// IsOnline returns true if the player is on the server.
+func (p Profile) IsOnline() bool { return !p.OnlineSince.IsZero() }
+
+// TotalOnline returns the time spent on the server, including the current
+// visit.
+func (p Profile) TotalOnline(now time.Time) time.Duration {
+	if p.IsOnline() {
+		return p.TimeOnline + now.Sub(p.OnlineSince)
+	}
+	return p.TimeOnline
+}
+
+// Finished returns the number of plays that the player finished normally.
+func (p Profile) Finished() int { return p.Finishes[distance.NormalFinish] }
+
+// DNFs returns the number of plays that the player didn't finish.
+func (p Profile) DNFs() int { return p.Finishes[distance.DNFFinish] }
+
+// CarUse is a car, with its colors, that a player played with.
+type CarUse struct {
+	Name   string
+	Colors [][4]float32
+	Plays  int
+}
+
+// PlayerLevel is a player's plays of a single level.
+type PlayerLevel struct {
+	Level      distance.Level
+	Plays      int
+	Finishes   int
+	LastPlayed time.Time
+	// Best is the best finish; it is only valid if Finishes isn't 0. It is a
+	// time in milliseconds or a score, depending on the game mode.
+	Best    int
+	BestAt  time.Time
+	BestCar string
+}
+
+// ID returns the level's LevelID.
+func (pl PlayerLevel) ID() string { return LevelID(pl.Level) }
+
+// PlayerJoined records that the player joined the server at the given time.
+func (db *DB) PlayerJoined(player distance.Player, at time.Time) error {
+	err := db.db.Update(func(txn *badger.Txn) error {
+		profile, err := seePlayer(txn, player, at)
+		if err != nil {
+			return err
+		}
+		return setProfile(txn, profile)
+	})
+
+	return errors.Wrap(err, "failed to add joined player")
+}
+
+// PlayerLeft records that the player left the server at the given time. Their
+// visit is added to their time online.
+func (db *DB) PlayerLeft(player distance.Player, at time.Time) error {
+	err := db.db.Update(func(txn *badger.Txn) error {
+		profile, err := seePlayer(txn, player, at)
+		if err != nil {
+			return err
+		}
+		return setProfile(txn, leave(profile, at))
+	})
+
+	return errors.Wrap(err, "failed to add left player")
+}
+
+// AddPlays records the final state of the players on a level that ended at the
+// given time. Spectators are skipped.
+func (db *DB) AddPlays(level distance.Level, players []distance.Player, at time.Time) error {
+	id := LevelID(level)
+	higher := HigherIsBetter(level.GameMode)
+
+	err := db.db.Update(func(txn *badger.Txn) error {
+		for _, player := range players {
+			if player.Car.Spectator {
+				continue
+			}
+
+			profile, err := seePlayer(txn, player, at)
+			if err != nil {
+				return err
+			}
+
+			finish := player.Car.FinishType
+			if finish == "" {
+				finish = distance.NoneFinish
+			}
+
+			profile.Plays++
+			profile.Finishes[finish]++
+			profile.Cars = useCar(profile.Cars, player.Car)
+
+			if err := setProfile(txn, profile); err != nil {
+				return err
+			}
+
+			key := []byte(playerLevelPrefix + player.UnityPlayerGUID + "/" + id)
+
+			var pl PlayerLevel
+			if err := getJSON(txn, key, &pl); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+
+			pl.Level = level
+			pl.Plays++
+			pl.LastPlayed = at
+
+			if player.Car.IsFinished() {
+				improved := pl.Finishes == 0 || player.Car.FinishData < pl.Best
+				if higher {
+					improved = pl.Finishes == 0 || player.Car.FinishData > pl.Best
+				}
+				if improved {
+					pl.Best = player.Car.FinishData
+					pl.BestAt = at
+					pl.BestCar = player.Car.Name
+				}
+				pl.Finishes++
+			}
+
+			if err := setJSON(txn, key, pl); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return errors.Wrap(err, "failed to add plays")
+}
+
+// Profile returns the profile of the player with the given GUID. Nil is
+// returned if the player was never seen.
+func (db *DB) Profile(guid string) (*Profile, error) {
+	var profile Profile
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		return getJSON(txn, []byte(playerPrefix+guid), &profile)
+	})
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to get profile")
+	}
+
+	return &profile, nil
+}
+
+// PlayerLevels returns the levels that the player with the given GUID played,
+// most recently played first.
+func (db *DB) PlayerLevels(guid string) ([]PlayerLevel, error) {
+	var levels []PlayerLevel
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		return iterateJSON(txn, playerLevelPrefix+guid+"/", func() interface{} {
+			levels = append(levels, PlayerLevel{})
+			return &levels[len(levels)-1]
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get player levels")
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].LastPlayed.After(levels[j].LastPlayed)
+	})
+
+	return levels, nil
+}
+
+// PlayerChat returns at most limit of the latest messages sent by the player
+// with the given GUID, sorted oldest first. Only messages recorded since
+// profiles were introduced are found.
+func (db *DB) PlayerChat(guid string, limit int) ([]distance.ChatMessage, error) {
+	var msgs []distance.ChatMessage
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		prefix := chatSenderPrefix + guid + "/"
+
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		opts.Prefix = []byte(prefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek([]byte(prefix + "\xFF")); it.Valid() && len(msgs) < limit; it.Next() {
+			key, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			var msg distance.ChatMessage
+			if err := getJSON(txn, key, &msg); err != nil {
+				return err
+			}
+
+			msgs = append(msgs, msg)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get player chat")
+	}
+
+	// We iterated backwards, so flip the slice.
+	for i := len(msgs)/2 - 1; i >= 0; i-- {
+		opp := len(msgs) - 1 - i
+		msgs[i], msgs[opp] = msgs[opp], msgs[i]
+	}
+
+	return msgs, nil
+}
+
+// resumePlayers ends the visits left open by the last run, which can't have
+// lasted longer than when the players were last seen, then starts the visits
+// of the given players, who are on the server now.
+func (db *DB) resumePlayers(players []distance.Player, now time.Time) error {
+	err := db.db.Update(func(txn *badger.Txn) error {
+		if err := endVisits(txn, time.Time{}); err != nil {
+			return err
+		}
+		return seePlayers(txn, players, now)
+	})
+
+	return errors.Wrap(err, "failed to resume players")
+}
+
+// markSeen records that the given players are still on the server at the given
+// time, so that their visits don't end before it if recording stops abruptly.
+// Visits aren't started, since the players may have left since.
+func (db *DB) markSeen(players []distance.Player, at time.Time) error {
+	err := db.db.Update(func(txn *badger.Txn) error {
+		for _, player := range players {
+			var profile Profile
+			err := getJSON(txn, []byte(playerPrefix+player.UnityPlayerGUID), &profile)
+			if err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				}
+				return err
+			}
+
+			if !profile.IsOnline() || !at.After(profile.LastSeen) {
+				continue
+			}
+
+			profile.LastSeen = at
+			if err := setProfile(txn, profile); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return errors.Wrap(err, "failed to mark players as seen")
+}
+
+// endVisits ends the visits of every player on the server at the given time.
+func (db *DB) endVisits(at time.Time) error {
+	err := db.db.Update(func(txn *badger.Txn) error {
+		return endVisits(txn, at)
+	})
+
+	return errors.Wrap(err, "failed to end visits")
+}
+
+// endVisits ends the open visits at the given time, or when each player was
+// last seen if it's zero.
+func endVisits(txn *badger.Txn, at time.Time) error {
+	var open []string
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte(onlinePrefix)
+
+	it := txn.NewIterator(opts)
+	for it.Rewind(); it.Valid(); it.Next() {
+		open = append(open, string(it.Item().Key()[len(onlinePrefix):]))
+	}
+	it.Close()
+
+	for _, guid := range open {
+		var profile Profile
+		if err := getJSON(txn, []byte(playerPrefix+guid), &profile); err != nil {
+			return err
+		}
+
+		end := at
+		if end.IsZero() {
+			end = profile.LastSeen
+		}
+
+		if err := setProfile(txn, leave(profile, end)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// seePlayers updates the profiles of the given players with them being on the
+// server at the given time.
+func seePlayers(txn *badger.Txn, players []distance.Player, at time.Time) error {
+	for _, player := range players {
+		profile, err := seePlayer(txn, player, at)
+		if err != nil {
+			return err
+		}
+		if err := setProfile(txn, profile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seePlayer returns the player's profile updated with them being on the server
+// at the given time. A visit is started if they aren't known to be on the
+// server, e.g. because they joined before recording started.
+func seePlayer(txn *badger.Txn, player distance.Player, at time.Time) (Profile, error) {
+	var profile Profile
+
+	err := getJSON(txn, []byte(playerPrefix+player.UnityPlayerGUID), &profile)
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return profile, err
+	}
+
+	profile.GUID = player.UnityPlayerGUID
+	if player.Name != "" {
+		profile.Name = player.Name
+	}
+	if profile.FirstSeen.IsZero() {
+		profile.FirstSeen = at
+	}
+	if at.After(profile.LastSeen) {
+		profile.LastSeen = at
+	}
+	if !profile.IsOnline() {
+		profile.OnlineSince = at
+	}
+	if profile.Finishes == nil {
+		profile.Finishes = map[distance.FinishType]int{}
+	}
+
+	return profile, nil
+}
+
+// leave ends the player's visit at the given time.
+func leave(profile Profile, at time.Time) Profile {
+	if profile.IsOnline() {
+		if at.After(profile.OnlineSince) {
+			profile.TimeOnline += at.Sub(profile.OnlineSince)
+		}
+		profile.OnlineSince = time.Time{}
+	}
+	return profile
+}
+
+// setProfile saves the profile, keeping the index of players on the server
+// up to date.
+func setProfile(txn *badger.Txn, profile Profile) error {
+	onlineKey := []byte(onlinePrefix + profile.GUID)
+
+	if profile.IsOnline() {
+		if err := txn.Set(onlineKey, nil); err != nil {
+			return err
+		}
+	} else {
+		if err := txn.Delete(onlineKey); err != nil {
+			return err
+		}
+	}
+
+	return setJSON(txn, []byte(playerPrefix+profile.GUID), profile)
+}
+
+// useCar counts a play with the given car.
+func useCar(cars []CarUse, car distance.Car) []CarUse {
+	found := false
+	for i, use := range cars {
+		if use.Name == car.Name && reflect.DeepEqual(use.Colors, car.Colors) {
+			cars[i].Plays++
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		cars = append(cars, CarUse{Name: car.Name, Colors: car.Colors, Plays: 1})
+	}
+
+	sort.SliceStable(cars, func(i, j int) bool { return cars[i].Plays > cars[j].Plays })
+
+	if len(cars) > maxCars {
+		// Forget the least used car other than the one just used, so that
+		// new cars can still get in.
+		cars = append(cars[:maxCars-1], cars[len(cars)-1])
+	}
+
+	return cars
+}
+
+// chatSenderKey returns the key of the message in the index of messages by
+// sender, or nil if it wasn't sent by a player.
+func chatSenderKey(msg distance.ChatMessage) []byte {
+	if msg.Sender == "" || msg.Sender == "server" {
+		return nil
+	}
+	return timeKey(chatSenderPrefix+msg.Sender+"/", msg.Time(), msg.GUID)
+}
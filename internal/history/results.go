@@ -0,0 +1,284 @@
+package history
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/pkg/errors"
+)
+
+const (
+	levelPrefix  = "level/"  // level/{levelID} -> LevelInfo
+	resultPrefix = "result/" // result/{levelID}/{time}/ -> Result
+	bestPrefix   = "best/"   // best/{levelID}/{guid} -> BestTime
+)
+
+// LevelID returns the ID that identifies the given level across playlists. It
+// is derived from the level's RelativeLevelPath and WorkshopFileID and is safe
+// to use in URLs.
+func LevelID(level distance.Level) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(level.WorkshopFileID + "\x00" + level.RelativeLevelPath))
+}
+
+// Result is the final standings of a level that was played.
+type Result struct {
+	Level     distance.Level
+	EndedAt   time.Time
+	Standings []Standing
+}
+
+// Standing is the final state of a player in a Result.
+type Standing struct {
+	PlayerGUID string
+	Name       string
+	CarName    string
+	FinishType distance.FinishType
+	FinishData int
+}
+
+// IsFinished returns true if the player finished normally.
+func (s Standing) IsFinished() bool { return s.FinishType == distance.NormalFinish }
+
+// NewResult creates a new Result from the given players, sorted by their
+// finishes. Spectators are not included.
+func NewResult(level distance.Level, players []distance.Player, endedAt time.Time) Result {
+	result := Result{
+		Level:     level,
+		EndedAt:   endedAt,
+		Standings: make([]Standing, 0, len(players)),
+	}
+
+	for _, player := range players {
+		if player.Car.Spectator {
+			continue
+		}
+
+		result.Standings = append(result.Standings, Standing{
+			PlayerGUID: player.UnityPlayerGUID,
+			Name:       player.Name,
+			CarName:    player.Car.Name,
+			FinishType: player.Car.FinishType,
+			FinishData: player.Car.FinishData,
+		})
+	}
+
+	higher := HigherIsBetter(level.GameMode)
+
+	sort.SliceStable(result.Standings, func(i, j int) bool {
+		si, sj := result.Standings[i], result.Standings[j]
+		if si.IsFinished() != sj.IsFinished() {
+			return si.IsFinished()
+		}
+		if higher {
+			return si.FinishData > sj.FinishData
+		}
+		return si.FinishData < sj.FinishData
+	})
+
+	return result
+}
+
+// HigherIsBetter returns true if a higher FinishData is better in the given
+// game mode, which is the case for score-based modes. Otherwise, FinishData is
+// a time in milliseconds.
+func HigherIsBetter(gameMode string) bool {
+	return gameMode == "Stunt"
+}
+
+// LevelInfo describes a level that has results.
+type LevelInfo struct {
+	Level      distance.Level
+	Plays      int
+	LastPlayed time.Time
+}
+
+// ID returns the level's LevelID.
+func (info LevelInfo) ID() string { return LevelID(info.Level) }
+
+// BestTime is the best finish of a player on a level.
+type BestTime struct {
+	PlayerGUID string
+	Name       string
+	CarName    string
+	FinishData int
+	At         time.Time
+}
+
+// AddResult records the given result. The level's leaderboard is updated with
+// the result's finishes.
+func (db *DB) AddResult(result Result) error {
+	id := LevelID(result.Level)
+	higher := HigherIsBetter(result.Level.GameMode)
+
+	err := db.db.Update(func(txn *badger.Txn) error {
+		levelKey := []byte(levelPrefix + id)
+
+		var info LevelInfo
+		if err := getJSON(txn, levelKey, &info); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		info.Level = result.Level
+		info.Plays++
+		info.LastPlayed = result.EndedAt
+
+		if err := setJSON(txn, levelKey, info); err != nil {
+			return err
+		}
+
+		if err := setJSON(txn, timeKey(resultPrefix+id+"/", result.EndedAt, ""), result); err != nil {
+			return err
+		}
+
+		for _, standing := range result.Standings {
+			if !standing.IsFinished() {
+				continue
+			}
+
+			bestKey := []byte(bestPrefix + id + "/" + standing.PlayerGUID)
+
+			var best BestTime
+			err := getJSON(txn, bestKey, &best)
+			if err == nil {
+				improved := standing.FinishData < best.FinishData
+				if higher {
+					improved = standing.FinishData > best.FinishData
+				}
+				if !improved {
+					continue
+				}
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+
+			best = BestTime{
+				PlayerGUID: standing.PlayerGUID,
+				Name:       standing.Name,
+				CarName:    standing.CarName,
+				FinishData: standing.FinishData,
+				At:         result.EndedAt,
+			}
+
+			if err := setJSON(txn, bestKey, best); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return errors.Wrap(err, "failed to add result")
+}
+
+// Levels returns all levels that have results, most recently played first.
+func (db *DB) Levels() ([]LevelInfo, error) {
+	var levels []LevelInfo
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		return iterateJSON(txn, levelPrefix, func() interface{} {
+			levels = append(levels, LevelInfo{})
+			return &levels[len(levels)-1]
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get levels")
+	}
+
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].LastPlayed.After(levels[j].LastPlayed)
+	})
+
+	return levels, nil
+}
+
+// Level returns the level with the given ID. Nil is returned if the level has
+// no results.
+func (db *DB) Level(levelID string) (*LevelInfo, error) {
+	var info LevelInfo
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		return getJSON(txn, []byte(levelPrefix+levelID), &info)
+	})
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to get level")
+	}
+
+	return &info, nil
+}
+
+// Leaderboard returns the best finish of each player on the level with the
+// given ID, best first.
+func (db *DB) Leaderboard(levelID string) ([]BestTime, error) {
+	var level LevelInfo
+	var times []BestTime
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		err := getJSON(txn, []byte(levelPrefix+levelID), &level)
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		return iterateJSON(txn, bestPrefix+levelID+"/", func() interface{} {
+			times = append(times, BestTime{})
+			return &times[len(times)-1]
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get leaderboard")
+	}
+
+	higher := HigherIsBetter(level.Level.GameMode)
+
+	sort.SliceStable(times, func(i, j int) bool {
+		if higher {
+			return times[i].FinishData > times[j].FinishData
+		}
+		return times[i].FinishData < times[j].FinishData
+	})
+
+	return times, nil
+}
+
+// Results returns at most limit results of the level with the given ID, latest
+// first.
+func (db *DB) Results(levelID string, limit int) ([]Result, error) {
+	var results []Result
+
+	err := db.db.View(func(txn *badger.Txn) error {
+		prefix := resultPrefix + levelID + "/"
+
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		opts.Prefix = []byte(prefix)
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek([]byte(prefix + "\xFF")); it.Valid() && len(results) < limit; it.Next() {
+			var result Result
+			err := it.Item().Value(func(b []byte) error {
+				return json.Unmarshal(b, &result)
+			})
+			if err != nil {
+				return err
+			}
+
+			results = append(results, result)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get results")
+	}
+
+	return results, nil
+}
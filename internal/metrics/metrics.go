@@ -0,0 +1,219 @@
+// Package metrics exports Prometheus metrics about the Distance server and the
+// frontend itself.
+package metrics
+
+import (
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/diamondburned/distant-front/internal/workshopimg"
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewRegistry creates a new registry with the Go runtime, process and
+// frontend-wide collectors registered.
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "distantfront_workshop_cache_requests_total",
+			Help:        "Number of workshop image lookups by cache result.",
+			ConstLabels: prometheus.Labels{"result": "hit"},
+		}, func() float64 {
+			return float64(workshopimg.ReadCacheStats().Hits)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "distantfront_workshop_cache_requests_total",
+			Help:        "Number of workshop image lookups by cache result.",
+			ConstLabels: prometheus.Labels{"result": "miss"},
+		}, func() float64 {
+			return float64(workshopimg.ReadCacheStats().Misses)
+		}),
+	)
+	return reg
+}
+
+// Handler returns the HTTP handler serving the metrics gathered from the given
+// registry.
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// InstrumentClient wraps the Distance client's transport to record the latency
+// and errors of each request by endpoint. It must be called before the client
+// is used. The returned callback unregisters the metrics.
+func InstrumentClient(reg prometheus.Registerer, c *distance.Client) (unregister func()) {
+	t := &transport{
+		next: c.Client.Transport,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "distance_client_request_duration_seconds",
+			Help: "Latency of requests to the Distance server by endpoint.",
+		}, []string{"endpoint"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "distance_client_request_errors_total",
+			Help: "Number of failed requests to the Distance server by endpoint.",
+		}, []string{"endpoint"}),
+	}
+
+	if t.next == nil {
+		t.next = http.DefaultTransport
+	}
+
+	reg.MustRegister(t.duration, t.errors)
+	c.Client.Transport = t
+
+	return func() {
+		reg.Unregister(t.duration)
+		reg.Unregister(t.errors)
+	}
+}
+
+type transport struct {
+	next     http.RoundTripper
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	endpoint := path.Base(r.URL.Path)
+	now := time.Now()
+
+	resp, err := t.next.RoundTrip(r)
+
+	t.duration.WithLabelValues(endpoint).Observe(time.Since(now).Seconds())
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		t.errors.WithLabelValues(endpoint).Inc()
+	}
+
+	return resp, err
+}
+
+// ObserveServer registers the metrics of the server observed by the given
+// Observer. The returned callback stops recording and unregisters the metrics;
+// recording also stops when the observer is stopped.
+func ObserveServer(reg prometheus.Registerer, obs *distance.Observer) (stop func()) {
+	refetch := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "distance_observer_refetch_duration_seconds",
+		Help: "Time taken by the Observer to renew the whole state.",
+	})
+	chats := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "distance_chat_messages_total",
+		Help: "Number of chat messages observed.",
+	})
+	levels := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "distance_level_changes_total",
+		Help: "Number of level changes observed.",
+	})
+
+	collectors := []prometheus.Collector{refetch, chats, levels, stateCollector{obs}}
+	reg.MustRegister(collectors...)
+
+	stopRefetch := obs.OnRefetch(func(took time.Duration) {
+		refetch.Observe(took.Seconds())
+	})
+	eventCh, stopEvents := obs.SubscribeEvents()
+
+	go func() {
+		for ev := range eventCh {
+			switch ev.(type) {
+			case distance.ChatMessageReceivedEvent:
+				chats.Inc()
+			case distance.LevelChangedEvent:
+				levels.Inc()
+			}
+		}
+	}()
+
+	return func() {
+		stopRefetch()
+		stopEvents()
+
+		for _, collector := range collectors {
+			reg.Unregister(collector)
+		}
+	}
+}
+
+// NewChatListeners registers the gauge of the server's live chat streams, which
+// the frontend keeps up to date. The returned callback unregisters it.
+func NewChatListeners(reg prometheus.Registerer) (prometheus.Gauge, func()) {
+	listeners := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "distantfront_chat_listeners",
+		Help: "Number of live chat streams, over Server-Sent Events or WebSocket.",
+	})
+	reg.MustRegister(listeners)
+
+	return listeners, func() { reg.Unregister(listeners) }
+}
+
+var (
+	upDesc = prometheus.NewDesc(
+		"distance_up",
+		"Whether the last summary fetch succeeded.",
+		nil, nil,
+	)
+	playersDesc = prometheus.NewDesc(
+		"distance_players",
+		"Number of players on the server.",
+		nil, nil,
+	)
+	maxPlayersDesc = prometheus.NewDesc(
+		"distance_max_players",
+		"Maximum number of players allowed on the server.",
+		nil, nil,
+	)
+	levelIndexDesc = prometheus.NewDesc(
+		"distance_playlist_level_index",
+		"Index of the current level in the playlist.",
+		nil, nil,
+	)
+	levelInfoDesc = prometheus.NewDesc(
+		"distance_level_info",
+		"Information about the current level; the value is always 1.",
+		[]string{"name", "mode", "workshop_id"}, nil,
+	)
+)
+
+// stateCollector collects the Observer's latest state on every scrape.
+type stateCollector struct {
+	obs *distance.Observer
+}
+
+func (c stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- playersDesc
+	ch <- maxPlayersDesc
+	ch <- levelIndexDesc
+	ch <- levelInfoDesc
+}
+
+func (c stateCollector) Collect(ch chan<- prometheus.Metric) {
+	state := c.obs.State()
+
+	if state.Summary == nil {
+		ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 0)
+		return
+	}
+
+	s := state.Summary
+
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(
+		playersDesc, prometheus.GaugeValue, float64(len(s.Players)))
+	ch <- prometheus.MustNewConstMetric(
+		maxPlayersDesc, prometheus.GaugeValue, float64(s.Server.MaxPlayers))
+	ch <- prometheus.MustNewConstMetric(
+		levelInfoDesc, prometheus.GaugeValue, 1,
+		s.Level.Name, s.Level.GameMode, s.Level.WorkshopFileID)
+
+	if state.PlaylistState != nil {
+		ch <- prometheus.MustNewConstMetric(
+			levelIndexDesc, prometheus.GaugeValue, float64(state.PlaylistState.CurrentLevelIndex))
+	}
+}
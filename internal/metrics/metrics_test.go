@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+)
+
+func TestGather(t *testing.T) {
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "Alice"})
+	s.SetStatus("/links", 500)
+
+	reg := NewRegistry()
+
+	c := s.NewClient()
+	c.Retry = distance.NoRetry // count each request once
+	InstrumentClient(reg, c)
+
+	obs := distance.NewObserver(c, time.Hour)
+	defer obs.Stop()
+
+	stop := ObserveServer(reg, obs)
+	defer stop()
+
+	listeners, unregister := NewChatListeners(reg)
+	defer unregister()
+	listeners.Inc()
+
+	// Make an erroring request.
+	c.Links()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal("failed to gather:", err)
+	}
+
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			switch {
+			case metric.Gauge != nil:
+				values[family.GetName()] += metric.Gauge.GetValue()
+			case metric.Counter != nil:
+				values[family.GetName()] += metric.Counter.GetValue()
+			}
+		}
+	}
+
+	expect := map[string]float64{
+		"distance_up":                          1,
+		"distance_players":                     1,
+		"distance_max_players":                 24,
+		"distance_client_request_errors_total": 1,
+		"distantfront_chat_listeners":          1,
+	}
+
+	for name, want := range expect {
+		got, ok := values[name]
+		if !ok {
+			t.Errorf("metric %s missing", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("metric %s: expected %v, got %v", name, want, got)
+		}
+	}
+}
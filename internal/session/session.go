@@ -0,0 +1,293 @@
+// Package session keeps the Distance session tokens of linked players
+// server-side. Browsers only get an opaque session ID, which expires and is
+// rotated, so the tokens themselves never leave the server.
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/pkg/errors"
+)
+
+// Opts is the options of how sessions expire, are rotated and validated.
+type Opts struct {
+	// TTL is how long a session lasts after linking.
+	TTL time.Duration
+	// Rotate is how often a session's ID is replaced with a new one.
+	Rotate time.Duration
+	// Validate is how often a session's token is checked against the server's
+	// links.
+	Validate time.Duration
+}
+
+// Session is a linked player's session on a single server.
+type Session struct {
+	// Server is the ID of the server that the session is for.
+	Server string
+	// Token is the Distance session token.
+	Token string
+	// PlayerGUID is the GUID of the linked player, as of the last validation.
+	PlayerGUID string
+	// CSRF is the token that forms must be sent with. It is kept when the ID
+	// is rotated.
+	CSRF string
+	// Issued is when the session's ID was issued.
+	Issued time.Time
+	// Validated is when the token was last found in the server's links.
+	Validated time.Time
+	Expires   time.Time
+	// Rotated is true if the ID was replaced with a new one. The old ID is
+	// kept for a short while, so that requests already in flight still work.
+	Rotated bool
+
+	// User is the operator that the session is of, for sessions of the admin
+	// panel, which have no Token. PasswordHash is the hash that they logged in
+	// with, so that the session ends once the password changes.
+	User         string `json:",omitempty"`
+	PasswordHash string `json:",omitempty"`
+}
+
+// Store keeps the sessions in memory, and on disk if it was opened with a
+// path. It should outlive configuration reloads, so that players stay linked.
+type Store struct {
+	mutex    sync.Mutex
+	sessions map[string]Session
+	db       *badger.DB // optional
+}
+
+// NewStore creates an empty session store that is only kept in memory.
+func NewStore() *Store {
+	return &Store{sessions: map[string]Session{}}
+}
+
+// Open opens the session store saved at the given path, so that players stay
+// linked across restarts. The directory is created if it does not exist.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create directory")
+	}
+
+	opts := badger.DefaultOptions(path)
+	opts.EventLogging = false
+	opts.Truncate = true
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open badger")
+	}
+
+	s := &Store{sessions: map[string]Session{}, db: db}
+
+	err = db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var sess Session
+			err := it.Item().Value(func(v []byte) error { return json.Unmarshal(v, &sess) })
+			if err != nil {
+				return err
+			}
+			s.sessions[string(it.Item().Key())] = sess
+		}
+
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to load sessions")
+	}
+
+	return s, nil
+}
+
+// Close closes the store's database, if any.
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// save saves the sessions with the given IDs to disk, or deletes the ones
+// that are gone. Errors are only logged, since the sessions are still kept in
+// memory. The mutex must be held.
+func (s *Store) save(ids ...string) {
+	if s.db == nil {
+		return
+	}
+
+	err := s.db.Update(func(txn *badger.Txn) error {
+		for _, id := range ids {
+			sess, ok := s.sessions[id]
+			if !ok {
+				if err := txn.Delete([]byte(id)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			b, err := json.Marshal(sess)
+			if err != nil {
+				return err
+			}
+
+			// Expired sessions are dropped by badger even if they are never
+			// deleted.
+			entry := badger.NewEntry([]byte(id), b).WithTTL(time.Until(sess.Expires))
+			if err := txn.SetEntry(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("[session] Failed to save sessions:", err)
+	}
+}
+
+// Create creates the given session and returns its ID along with the created
+// session. Its Issued time is set to now, and it gets a new CSRF token.
+func (s *Store) Create(sess Session) (string, Session) {
+	now := time.Now()
+	sess.Issued = now
+	sess.CSRF = NewToken()
+	id := NewToken()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Sweep expired sessions here, since sessions are rarely created. Badger
+	// drops them from disk on its own.
+	for id, sess := range s.sessions {
+		if now.After(sess.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+
+	s.sessions[id] = sess
+	s.save(id)
+
+	return id, sess
+}
+
+// Get returns the unexpired session with the given ID.
+func (s *Store) Get(id string) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	if time.Now().After(sess.Expires) {
+		delete(s.sessions, id)
+		return Session{}, false
+	}
+
+	return sess, true
+}
+
+// Linked returns true if there is an unexpired session of the given token on
+// the given server, e.g. one that it was rotated to.
+func (s *Store) Linked(server, token string) bool {
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, sess := range s.sessions {
+		if sess.Server == server && sess.Token == token && now.Before(sess.Expires) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Update replaces the session with the given ID, unless it was deleted in the
+// meantime.
+func (s *Store) Update(id string, sess Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.sessions[id]; ok {
+		s.sessions[id] = sess
+		s.save(id)
+	}
+}
+
+// Rotate moves the session with the given ID to a new ID, which is returned.
+// The old ID stays valid for the given grace period. False is returned if the
+// session doesn't exist or was already rotated.
+func (s *Store) Rotate(id string, grace time.Duration) (string, bool) {
+	now := time.Now()
+	newID := NewToken()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok || sess.Rotated {
+		return "", false
+	}
+
+	old := sess
+	old.Rotated = true
+	if deadline := now.Add(grace); deadline.Before(old.Expires) {
+		old.Expires = deadline
+	}
+	s.sessions[id] = old
+
+	sess.Issued = now
+	s.sessions[newID] = sess
+
+	s.save(id, newID)
+
+	return newID, true
+}
+
+// Delete deletes the session with the given ID, along with every other ID of
+// the same token, e.g. the ones that it was rotated from.
+func (s *Store) Delete(id string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return
+	}
+
+	if sess.Token == "" {
+		delete(s.sessions, id)
+		s.save(id)
+		return
+	}
+
+	var deleted []string
+	for id, other := range s.sessions {
+		if other.Server == sess.Server && other.Token == sess.Token {
+			delete(s.sessions, id)
+			deleted = append(deleted, id)
+		}
+	}
+
+	s.save(deleted...)
+}
+
+// NewToken returns a new random token. It is also used for the CSRF tokens of
+// visitors without a session.
+func NewToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("session: failed to read random: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
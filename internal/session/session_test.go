@@ -0,0 +1,42 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpen(t *testing.T) {
+	path := t.TempDir()
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal("failed to open:", err)
+	}
+
+	id, _ := s.Create(Session{Server: "main", Token: "token", Expires: time.Now().Add(time.Hour)})
+	newID, _ := s.Rotate(id, time.Hour)
+
+	gone, _ := s.Create(Session{Server: "main", Token: "gone", Expires: time.Now().Add(time.Hour)})
+	s.Delete(gone)
+
+	if err := s.Close(); err != nil {
+		t.Fatal("failed to close:", err)
+	}
+
+	// Players stay linked after a restart.
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal("failed to reopen:", err)
+	}
+	defer s.Close()
+
+	if sess, ok := s.Get(newID); !ok || sess.Token != "token" || sess.Rotated {
+		t.Fatalf("rotated session not restored: %#v", sess)
+	}
+	if sess, ok := s.Get(id); !ok || !sess.Rotated {
+		t.Fatalf("old session not restored: %#v", sess)
+	}
+	if _, ok := s.Get(gone); ok {
+		t.Fatal("deleted session restored")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/dgraph-io/badger"
 	"github.com/diamondburned/distant-front/internal/httperr"
@@ -37,6 +38,24 @@ func Mount(cacheOpts CacheOpts) (http.Handler, error) {
 	return r, nil
 }
 
+// CacheStats contains the cache statistics of all mounted routes.
+type CacheStats struct {
+	// Hits is the number of lookups answered by the cache.
+	Hits uint64
+	// Misses is the number of lookups that had to go to Steam.
+	Misses uint64
+}
+
+var cacheStats CacheStats
+
+// ReadCacheStats returns the current cache statistics.
+func ReadCacheStats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&cacheStats.Hits),
+		Misses: atomic.LoadUint64(&cacheStats.Misses),
+	}
+}
+
 // ErrNotFound is used when a workshop file is not found.
 var ErrNotFound = httperr.New(404, "file not found")
 
@@ -102,12 +121,16 @@ func (c *cache) getFile(workshopID string) (string, error) {
 	})
 
 	if found {
+		atomic.AddUint64(&cacheStats.Hits, 1)
+
 		if imageURL != "" {
 			return imageURL, nil
 		}
 		return "", ErrNotFound
 	}
 
+	atomic.AddUint64(&cacheStats.Misses, 1)
+
 	v, err := c.getFileDirect(workshopID)
 	if err != nil {
 		return "", err
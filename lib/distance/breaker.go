@@ -0,0 +1,74 @@
+package distance
+
+import "time"
+
+// breaker is a circuit breaker that makes the Observer back off while the
+// server is unreachable. After threshold consecutive failures, it opens and
+// only lets a single probe through every backoff, which doubles on each failed
+// probe.
+type breaker struct {
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	failures  int
+	backoff   time.Duration
+	downSince time.Time
+	retryAt   time.Time
+}
+
+const (
+	breakerThreshold  = 3
+	breakerMaxBackoff = 30 * time.Second
+)
+
+func newBreaker(freq time.Duration) breaker {
+	minBackoff := 2 * freq
+	if minBackoff < time.Second {
+		minBackoff = time.Second
+	}
+
+	return breaker{
+		threshold:  breakerThreshold,
+		minBackoff: minBackoff,
+		maxBackoff: breakerMaxBackoff,
+	}
+}
+
+// open returns true if the breaker is open.
+func (b *breaker) open() bool {
+	return b.failures >= b.threshold
+}
+
+// allow returns true if a request may be made at the given time.
+func (b *breaker) allow(now time.Time) bool {
+	return !b.open() || !now.Before(b.retryAt)
+}
+
+// succeed records a success and closes the breaker.
+func (b *breaker) succeed() {
+	b.failures = 0
+	b.backoff = 0
+	b.downSince = time.Time{}
+	b.retryAt = time.Time{}
+}
+
+// fail records a failure at the given time.
+func (b *breaker) fail(now time.Time) {
+	if b.failures == 0 {
+		b.downSince = now
+	}
+
+	b.failures++
+	if !b.open() {
+		return
+	}
+
+	if b.backoff == 0 {
+		b.backoff = b.minBackoff
+	} else if b.backoff *= 2; b.backoff > b.maxBackoff {
+		b.backoff = b.maxBackoff
+	}
+
+	b.retryAt = now.Add(b.backoff)
+}
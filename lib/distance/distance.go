@@ -18,7 +18,11 @@ import (
 
 // Client is a Distance Server client with a custom endpoint.
 type Client struct {
-	Client    http.Client
+	Client http.Client
+	// Retry is the policy for retrying idempotent requests. It defaults to
+	// DefaultRetryPolicy.
+	Retry RetryPolicy
+
 	endpoint  url.URL
 	privToken string
 
@@ -35,6 +39,7 @@ func NewClient(endpoint string) (*Client, error) {
 
 	return &Client{
 		Client:   http.Client{Timeout: 10 * time.Second},
+		Retry:    DefaultRetryPolicy,
 		endpoint: *url,
 		ctx:      context.Background(),
 	}, nil
@@ -62,7 +67,7 @@ func (err ErrStatusCode) Error() string {
 }
 
 func (c *Client) getJSON(u url.URL, dst interface{}) error {
-	r, err := c.doJSON("GET", u, dst, nil)
+	r, err := c.doJSON("GET", u, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -82,22 +87,48 @@ func (c *Client) doJSON(
 	u.Host = c.endpoint.Host
 	u.Path = path.Join(c.endpoint.Path, u.Path)
 
-	var body io.Reader
+	var body []byte
 	if in != nil {
 		b, err := json.Marshal(in)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to marshal body")
 		}
-		body = bytes.NewReader(b)
+		body = b
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, statusCode, err := c.do(method, u, body, h)
+		if err == nil {
+			return resp, nil
+		}
+
+		if attempt >= c.Retry.MaxAttempts || !shouldRetry(method, statusCode) {
+			return nil, err
+		}
+
+		if err := sleepContext(c.ctx, c.Retry.delay(attempt)); err != nil {
+			return nil, errors.Wrap(err, "failed to retry request")
+		}
+	}
+}
+
+// do does a single request. The status code is 0 if the request failed before
+// getting a response.
+func (c *Client) do(
+	method string, u url.URL, body []byte, h http.Header) (*http.Response, int, error) {
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
 	}
 
-	rq, err := http.NewRequestWithContext(c.ctx, method, u.String(), body)
+	rq, err := http.NewRequestWithContext(c.ctx, method, u.String(), r)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request")
+		return nil, 0, errors.Wrap(err, "failed to create request")
 	}
 
 	if h != nil {
-		rq.Header = h
+		rq.Header = h.Clone()
 	}
 
 	if c.privToken != "" {
@@ -106,15 +137,15 @@ func (c *Client) doJSON(
 
 	resp, err := c.Client.Do(rq)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to do request")
+		return nil, 0, errors.Wrap(err, "failed to do request")
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		resp.Body.Close()
-		return nil, ErrStatusCode(resp.StatusCode)
+		return nil, resp.StatusCode, ErrStatusCode(resp.StatusCode)
 	}
 
-	return resp, nil
+	return resp, resp.StatusCode, nil
 }
 
 // Observer observes the server for changes periodically.
@@ -123,8 +154,16 @@ type Observer struct {
 	waitg sync.WaitGroup
 	state ObservedState
 
-	subMu sync.Mutex
-	subs  map[chan ObservedState]struct{}
+	subMu  sync.Mutex
+	subs   map[chan ObservedState]struct{}
+	evSubs map[*eventSub]struct{}
+	hooks  map[*func(time.Duration)]struct{}
+
+	// diffBase is the state that the next state is diffed against. It only
+	// contains successfully fetched parts. It is only accessed in refetch.
+	diffBase ObservedState
+	// breaker is only accessed in refetch.
+	breaker breaker
 
 	// constants
 	client *Client
@@ -141,15 +180,31 @@ type ObservedState struct {
 	PlaylistState *PlaylistState
 	Links         *Links
 	LastRenew     time.Time
+	// DownSince is the time that the server became unreachable. It is zero if
+	// the server is reachable.
+	DownSince time.Time
 }
 
-// NewObserver creates a new periodic observer.
+// IsDown returns true if the server is unreachable.
+func (s ObservedState) IsDown() bool { return !s.DownSince.IsZero() }
+
+// NewObserver creates a new periodic observer. The server is polled every
+// dura; if it becomes unreachable, the Observer backs off until it is
+// reachable again.
 func NewObserver(c *Client, dura time.Duration) *Observer {
+	// The Observer polls the server periodically anyway, so it doesn't need to
+	// retry requests.
+	c = c.WithContext(c.ctx)
+	c.Retry = NoRetry
+
 	obs := Observer{
-		client: c,
-		sig:    make(chan struct{}, 1), // allow queueing
-		done:   make(chan struct{}),
-		subs:   map[chan ObservedState]struct{}{},
+		client:  c,
+		breaker: newBreaker(dura),
+		sig:     make(chan struct{}, 1), // allow queueing
+		done:    make(chan struct{}),
+		subs:    map[chan ObservedState]struct{}{},
+		evSubs:  map[*eventSub]struct{}{},
+		hooks:   map[*func(time.Duration)]struct{}{},
 
 		OnError: func(err error) {
 			log.Println("[distance] Observer error:", err)
@@ -183,13 +238,20 @@ func NewObserver(c *Client, dura time.Duration) *Observer {
 }
 
 func (obs *Observer) refetch(tick time.Time) {
+	// Don't hammer the server while it's down.
+	if !obs.breaker.allow(tick) {
+		return
+	}
+
+	start := time.Now()
+
+	var errs [3]error
+
 	var playlist *PlaylistState
 	obs.waitg.Add(1)
 	go func() {
 		p, err := obs.client.AllPlaylist()
-		if err != nil {
-			obs.OnError(errors.Wrap(err, "failed to get all playlists"))
-		}
+		errs[0] = errors.Wrap(err, "failed to get all playlists")
 		playlist = p
 		obs.waitg.Done()
 	}()
@@ -198,9 +260,7 @@ func (obs *Observer) refetch(tick time.Time) {
 	obs.waitg.Add(1)
 	go func() {
 		s, err := obs.client.Summary()
-		if err != nil {
-			obs.OnError(errors.Wrap(err, "failed to get summary"))
-		}
+		errs[1] = errors.Wrap(err, "failed to get summary")
 		summary = s
 		obs.waitg.Done()
 	}()
@@ -208,14 +268,39 @@ func (obs *Observer) refetch(tick time.Time) {
 	var links *Links
 	if obs.client.privToken != "" {
 		l, err := obs.client.Links()
-		if err != nil {
-			obs.OnError(errors.Wrap(err, "failed to get all playlists"))
-		}
+		errs[2] = errors.Wrap(err, "failed to get links")
 		links = l
 	}
 
 	obs.waitg.Wait()
 
+	took := time.Since(start)
+
+	// The summary decides whether the server is up.
+	wasOpen := obs.breaker.open()
+	if summary != nil {
+		obs.breaker.succeed()
+		if wasOpen {
+			log.Println("[distance] Observer: server is reachable again")
+		}
+	} else {
+		obs.breaker.fail(tick)
+	}
+
+	// Only report errors until the breaker opens, and then once more when it
+	// does.
+	if !wasOpen {
+		for _, err := range errs {
+			if err != nil {
+				obs.OnError(err)
+			}
+		}
+		if obs.breaker.open() {
+			obs.OnError(errors.Errorf(
+				"server unreachable since %s, backing off", obs.breaker.downSince.Format(time.RFC3339)))
+		}
+	}
+
 	state := ObservedState{
 		LastRenew:     tick,
 		PlaylistState: playlist,
@@ -223,19 +308,54 @@ func (obs *Observer) refetch(tick time.Time) {
 		Summary:       summary,
 	}
 
+	// Single failures are not considered downtime.
+	if obs.breaker.open() {
+		state.DownSince = obs.breaker.downSince
+	}
+
 	obs.mutex.Lock()
 	obs.state = state
 	obs.mutex.Unlock()
 
+	// Diff against the last successfully fetched parts, so that a failed fetch
+	// doesn't cause everything to be emitted again on the next one.
+	next := obs.diffBase
+	next.LastRenew = tick
+	if summary != nil {
+		next.Summary = summary
+	}
+	if playlist != nil {
+		next.PlaylistState = playlist
+	}
+	if links != nil {
+		next.Links = links
+	}
+
+	events := DiffStates(obs.diffBase, next)
+	obs.diffBase = next
+
 	obs.subMu.Lock()
 	defer obs.subMu.Unlock()
 
+	for hook := range obs.hooks {
+		(*hook)(took)
+	}
+
 	for ch := range obs.subs {
 		select {
 		case ch <- state:
 		default:
 		}
 	}
+
+	if len(events) > 0 {
+		for sub := range obs.evSubs {
+			if !sub.push(events) {
+				delete(obs.evSubs, sub)
+				log.Println("[distance] Observer: dropped an event subscriber that fell behind")
+			}
+		}
+	}
 }
 
 // Subscribe subscribes to the current observer. The returned channel will be
@@ -273,6 +393,30 @@ func (obs *Observer) Subscribe() (<-chan ObservedState, func()) {
 	}
 }
 
+// OnRefetch adds a hook that is called after every refetch with how long it
+// took to fetch the whole state. Unlike the states sent to subscribers, no
+// refetch is skipped. The hook must not block. The returned callback removes
+// the hook.
+func (obs *Observer) OnRefetch(fn func(took time.Duration)) (remove func()) {
+	hook := &fn
+
+	obs.subMu.Lock()
+	defer obs.subMu.Unlock()
+
+	if obs.hooks == nil {
+		return func() {}
+	}
+
+	obs.hooks[hook] = struct{}{}
+
+	return func() {
+		obs.subMu.Lock()
+		defer obs.subMu.Unlock()
+
+		delete(obs.hooks, hook)
+	}
+}
+
 // State returns the current observed state. The user must not mutate fields
 // inside the state, as that is racy.
 func (obs *Observer) State() ObservedState {
@@ -312,5 +456,11 @@ func (obs *Observer) Stop() {
 		close(ch)
 	}
 
+	for sub := range obs.evSubs {
+		sub.close()
+	}
+
 	obs.subs = nil
+	obs.evSubs = nil
+	obs.hooks = nil
 }
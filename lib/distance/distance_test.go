@@ -0,0 +1,340 @@
+package distance_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diamondburned/distant-front/lib/distance"
+	"github.com/diamondburned/distant-front/lib/distance/distancetest"
+)
+
+func newLevels(n int) []distance.Level {
+	levels := make([]distance.Level, n)
+	for i := range levels {
+		levels[i] = distance.Level{
+			Name:              "Level " + strconv.Itoa(i),
+			RelativeLevelPath: "OfficialLevels/Level" + strconv.Itoa(i) + ".bytes",
+			GameMode:          "Sprint",
+		}
+	}
+	return levels
+}
+
+func TestAllPlaylist(t *testing.T) {
+	tests := []struct {
+		name     string
+		levels   int
+		pageSize int
+	}{
+		{"empty", 0, 0},
+		{"single page", 5, 0},
+		{"paginated", 7, 3},
+		{"exact pages", 6, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := distancetest.NewServer("")
+			s.PageSize = test.pageSize
+			defer s.Close()
+
+			s.SetPlaylist(newLevels(test.levels)...)
+
+			p, err := s.NewClient().AllPlaylist()
+			if err != nil {
+				t.Fatal("failed to get all playlist:", err)
+			}
+
+			if len(p.Playlist.Levels) != test.levels {
+				t.Fatalf("expected %d levels, got %d", test.levels, len(p.Playlist.Levels))
+			}
+			if p.Playlist.Total != test.levels || p.Playlist.Count != test.levels {
+				t.Fatalf("unexpected total/count %d/%d", p.Playlist.Total, p.Playlist.Count)
+			}
+
+			for i, level := range p.Playlist.Levels {
+				if level.Index != i {
+					t.Fatalf("level %d has index %d", i, level.Index)
+				}
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "Alice"})
+
+	summary, err := s.NewClient().Summary()
+	if err != nil {
+		t.Fatal("failed to get summary:", err)
+	}
+
+	if summary.FindPlayer("a") == nil {
+		t.Fatal("player a not found in summary")
+	}
+	if len(summary.ChatLog) != 1 {
+		t.Fatalf("expected 1 join message, got %d", len(summary.ChatLog))
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	s.SetStatus("/summary", 503)
+
+	_, err := s.NewClient().Summary()
+
+	var code distance.ErrStatusCode
+	if !errors.As(err, &code) || code != 503 {
+		t.Fatalf("expected status code 503, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	s := distancetest.NewServer("hunter2")
+	defer s.Close()
+
+	s.SetStatus("/summary", 503)
+	s.SetStatus("/serverchat", 503)
+
+	c := s.NewClient()
+	c.Retry = distance.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	if _, err := c.Summary(); err == nil {
+		t.Fatal("unexpected nil error")
+	}
+	if n := s.Requests("/summary"); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	// POSTs are not idempotent, so they must not be retried.
+	if err := c.ServerChat("hi"); err == nil {
+		t.Fatal("unexpected nil error")
+	}
+	if n := s.Requests("/serverchat"); n != 1 {
+		t.Fatalf("expected 1 attempt, got %d", n)
+	}
+
+	// Client errors are not retried either.
+	s.SetStatus("/summary", 404)
+	c.Summary()
+
+	if n := s.Requests("/summary"); n != 4 {
+		t.Fatalf("expected 4 attempts in total, got %d", n)
+	}
+}
+
+func TestLinkChat(t *testing.T) {
+	s := distancetest.NewServer("hunter2")
+	defer s.Close()
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "Alice"})
+	s.AddLinkCode("123ABC", "a")
+
+	c := s.NewClient()
+
+	if _, err := c.LinkSession("000AAA"); !errors.Is(err, distance.ErrLinkCodeNotFound) {
+		t.Fatal("unexpected error linking invalid code:", err)
+	}
+
+	session, err := c.LinkSession("123ABC")
+	if err != nil {
+		t.Fatal("failed to link session:", err)
+	}
+
+	guid, err := c.PlayerGUID(session)
+	if err != nil {
+		t.Fatal("failed to get player GUID:", err)
+	}
+	if guid != "a" {
+		t.Fatalf("expected GUID a, got %q", guid)
+	}
+
+	if err := c.Chat(session, "hello"); err != nil {
+		t.Fatal("failed to chat:", err)
+	}
+	if err := c.ServerChat("[00FF00]welcome[-]"); err != nil {
+		t.Fatal("failed to server chat:", err)
+	}
+
+	log := s.State().Summary.ChatLog
+	if len(log) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(log))
+	}
+	if msg := log[1]; msg.Type != distance.PlayerChatMessage || msg.Sender != "a" {
+		t.Fatalf("unexpected player message %#v", msg)
+	}
+	if msg := log[2]; msg.Type != distance.ServerCustomMessage {
+		t.Fatalf("unexpected server message %#v", msg)
+	}
+}
+
+func TestPrivateUnauthorized(t *testing.T) {
+	s := distancetest.NewServer("hunter2")
+	defer s.Close()
+
+	c := s.NewClient()
+	c.SetPrivateToken("wrong")
+
+	_, err := c.Links()
+
+	var code distance.ErrStatusCode
+	if !errors.As(err, &code) || code != 401 {
+		t.Fatalf("expected status code 401, got %v", err)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	s := distancetest.NewServer("hunter2")
+	defer s.Close()
+
+	s.SetPlaylist(newLevels(3)...)
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	state := obs.State()
+	if state.Summary == nil || state.PlaylistState == nil || state.Links == nil {
+		t.Fatalf("incomplete initial state %#v", state)
+	}
+
+	ch, cancel := obs.Subscribe()
+	defer cancel()
+
+	s.AdvanceLevel()
+	obs.Renew()
+
+	select {
+	case state = <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for observer")
+	}
+
+	if state.PlaylistState.CurrentLevelIndex != 1 {
+		t.Fatalf("expected level index 1, got %d", state.PlaylistState.CurrentLevelIndex)
+	}
+	if state.Summary.Level.Name != "Level 1" {
+		t.Fatalf("expected Level 1, got %q", state.Summary.Level.Name)
+	}
+}
+
+func TestObserverBreaker(t *testing.T) {
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	s.SetStatus("/summary", 503)
+
+	obs := distance.NewObserver(s.NewClient(), 10*time.Millisecond)
+	defer obs.Stop()
+
+	// The breaker opens after 3 failures and then backs off for at least a
+	// second, so there shouldn't be any more requests for a while.
+	deadline := time.Now().Add(5 * time.Second)
+	for !obs.State().IsDown() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the server to be down")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	n := s.Requests("/summary")
+	time.Sleep(200 * time.Millisecond)
+
+	if m := s.Requests("/summary"); m != n {
+		t.Fatalf("expected no requests while backing off, got %d", m-n)
+	}
+
+	// The server comes back up. It is only noticed after the backoff.
+	s.SetStatus("/summary", 0)
+
+	deadline = time.Now().Add(5 * time.Second)
+	for obs.State().IsDown() || obs.State().Summary == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the server to be up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestObserverEvents(t *testing.T) {
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	s.SetPlaylist(newLevels(2)...)
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "a", Name: "Alice"})
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	evCh, cancel := obs.SubscribeEvents()
+	defer cancel()
+
+	next := func() distance.Event {
+		t.Helper()
+		select {
+		case ev := <-evCh:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return nil
+		}
+	}
+
+	s.AddPlayer(distance.Player{UnityPlayerGUID: "b", Name: "Bob"})
+	s.FinishPlayer("a", distance.NormalFinish, 42000)
+	obs.Renew()
+
+	if ev, ok := next().(distance.PlayerFinishedEvent); !ok || ev.Player.Car.FinishData != 42000 {
+		t.Fatalf("expected Alice to finish, got %#v", ev)
+	}
+	if ev, ok := next().(distance.PlayerJoinedEvent); !ok || ev.Player.Name != "Bob" {
+		t.Fatalf("expected Bob to join, got %#v", ev)
+	}
+	if ev, ok := next().(distance.ChatMessageReceivedEvent); !ok || ev.Message.Type != distance.ServerVanillaMessage {
+		t.Fatalf("expected join message, got %#v", ev)
+	}
+
+	s.AdvanceLevel()
+	s.RemovePlayer("b")
+	obs.Renew()
+
+	ev, ok := next().(distance.LevelChangedEvent)
+	if !ok || ev.Level.Name != "Level 1" {
+		t.Fatalf("expected level change to Level 1, got %#v", ev)
+	}
+	if len(ev.Standings) != 2 || !ev.Standings[0].Car.IsFinished() {
+		t.Fatalf("unexpected standings %#v", ev.Standings)
+	}
+
+	if ev, ok := next().(distance.PlayerLeftEvent); !ok || ev.Player.Name != "Bob" {
+		t.Fatalf("expected Bob to leave, got %#v", ev)
+	}
+}
+
+func TestOnRefetch(t *testing.T) {
+	s := distancetest.NewServer("")
+	defer s.Close()
+
+	obs := distance.NewObserver(s.NewClient(), time.Hour)
+	defer obs.Stop()
+
+	took := make(chan time.Duration, 1)
+	remove := obs.OnRefetch(func(d time.Duration) { took <- d })
+
+	obs.Renew()
+	if d := <-took; d <= 0 {
+		t.Fatalf("unexpected refetch duration %v", d)
+	}
+
+	remove()
+	obs.Stop()
+
+	// Adding a hook after stopping does nothing.
+	obs.OnRefetch(func(time.Duration) {})()
+}
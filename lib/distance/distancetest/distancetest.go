@@ -0,0 +1,481 @@
+// Package distancetest provides an in-process fake Distance server for tests.
+package distancetest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondburned/distant-front/lib/distance"
+)
+
+// State is the scriptable state of the fake server. It is what the server
+// serves on its endpoints.
+type State struct {
+	Summary  distance.Summary
+	Playlist distance.PlaylistState
+	Links    distance.Links
+}
+
+// Server is a fake Distance server backed by an httptest.Server. All methods
+// are safe to use concurrently with requests.
+type Server struct {
+	*httptest.Server
+
+	// PrivateToken is the token required by the privileged endpoints. It
+	// should not be changed after requests are made.
+	PrivateToken string
+	// PageSize is the maximum number of levels returned by /playlist per
+	// request. 0 means unlimited.
+	PageSize int
+
+	mutex  sync.Mutex
+	state  State
+	status map[string]int
+	counts map[string]int
+	nextID int
+}
+
+// NewServer creates and starts a new fake server with the given private
+// token. The caller should call Close when done.
+func NewServer(privToken string) *Server {
+	s := &Server{
+		PrivateToken: privToken,
+		status:       map[string]int{},
+		counts:       map[string]int{},
+		state: State{
+			Summary: distance.Summary{
+				Server: distance.Server{
+					MaxPlayers: 24,
+					Port:       45671,
+					IsInLobby:  true,
+				},
+				ChatLog: []distance.ChatMessage{},
+				Players: []distance.Player{},
+			},
+			Links: distance.Links{
+				CodesForward: map[string]string{},
+				CodesReverse: map[string]string{},
+				Links:        map[string]string{},
+			},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/summary", s.get(s.serveSummary))
+	mux.HandleFunc("/playlist", s.get(s.servePlaylist))
+	mux.HandleFunc("/links", s.get(s.private(s.serveLinks)))
+	mux.HandleFunc("/link", s.post(s.private(s.serveLink)))
+	mux.HandleFunc("/chat", s.post(s.private(s.serveChat)))
+	mux.HandleFunc("/serverchat", s.post(s.private(s.serveServerChat)))
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// NewClient creates a new Distance client pointed at the fake server with the
+// server's private token set.
+func (s *Server) NewClient() *distance.Client {
+	c, err := distance.NewClient(s.URL)
+	if err != nil {
+		panic("distancetest: invalid server URL: " + err.Error())
+	}
+	c.SetPrivateToken(s.PrivateToken)
+	return c
+}
+
+// Update calls fn with the server's state locked. The state may be mutated
+// freely inside fn.
+func (s *Server) Update(fn func(*State)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fn(&s.state)
+}
+
+// State returns a copy of the current state. Slices and maps inside are
+// shared, so they must not be mutated.
+func (s *Server) State() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.state
+}
+
+// SetStatus makes the endpoint with the given path (e.g. "/summary") respond
+// with the given status code and an empty body. A code of 0 restores normal
+// behavior.
+func (s *Server) SetStatus(path string, code int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if code == 0 {
+		delete(s.status, path)
+	} else {
+		s.status[path] = code
+	}
+}
+
+// Requests returns the number of requests made to the endpoint with the given
+// path so far.
+func (s *Server) Requests(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.counts[path]
+}
+
+// SetPlaylist replaces the playlist with the given levels. Their indices are
+// renumbered, and the first level becomes the current one.
+func (s *Server) SetPlaylist(levels ...distance.Level) {
+	s.Update(func(state *State) {
+		state.Playlist.Playlist.Levels = make([]distance.Level, len(levels))
+		for i, level := range levels {
+			level.Index = i
+			state.Playlist.Playlist.Levels[i] = level
+		}
+		state.Playlist.Playlist.Total = len(levels)
+		state.Playlist.CurrentLevelIndex = 0
+
+		if len(levels) > 0 {
+			state.Summary.Level = state.Playlist.Playlist.Levels[0]
+		}
+	})
+}
+
+// AdvanceLevel moves the server to the next level in the playlist, wrapping
+// around at the end. All players' cars are reset. The new level is returned.
+func (s *Server) AdvanceLevel() distance.Level {
+	var level distance.Level
+
+	s.Update(func(state *State) {
+		levels := state.Playlist.Playlist.Levels
+		if len(levels) == 0 {
+			return
+		}
+
+		ix := (state.Playlist.CurrentLevelIndex + 1) % len(levels)
+		level = levels[ix]
+
+		state.Playlist.CurrentLevelIndex = ix
+		state.Summary.Level = level
+		state.Summary.Server.CurrentLevelID++
+		state.Summary.Server.HasModeStarted = false
+
+		for i := range state.Summary.Players {
+			car := &state.Summary.Players[i].Car
+			car.Finished = false
+			car.FinishType = distance.NoneFinish
+			car.FinishData = 0
+			car.Alive = true
+		}
+	})
+
+	return level
+}
+
+// StartMode marks the current game mode as started.
+func (s *Server) StartMode() {
+	s.Update(func(state *State) {
+		state.Summary.Server.IsInLobby = false
+		state.Summary.Server.HasModeStarted = true
+		state.Summary.Server.ModeStartTime = timestamp(time.Now())
+	})
+}
+
+// AddPlayer adds the given player to the server. The player's index is
+// assigned automatically, and a join message is logged.
+func (s *Server) AddPlayer(player distance.Player) {
+	s.Update(func(state *State) {
+		player.Index = len(state.Summary.Players)
+		player.LevelID = int(state.Summary.Server.CurrentLevelID)
+		if player.JoinedAt == 0 {
+			player.JoinedAt = timestamp(time.Now())
+		}
+
+		state.Summary.Players = append(state.Summary.Players, player)
+		s.addChat(state, distance.ChatMessage{
+			Sender: "server",
+			Chat:   "[FFE999]" + player.Name + " has joined the server![-]",
+			Type:   distance.ServerVanillaMessage,
+		})
+	})
+}
+
+// RemovePlayer removes the player with the given GUID. False is returned if
+// the player is not found.
+func (s *Server) RemovePlayer(guid string) bool {
+	var found bool
+
+	s.Update(func(state *State) {
+		players := state.Summary.Players
+
+		for i, player := range players {
+			if player.UnityPlayerGUID != guid {
+				continue
+			}
+
+			found = true
+			state.Summary.Players = append(players[:i:i], players[i+1:]...)
+
+			s.addChat(state, distance.ChatMessage{
+				Sender: "server",
+				Chat:   "[FFE999]" + player.Name + " left the server[-]",
+				Type:   distance.ServerVanillaMessage,
+			})
+			return
+		}
+	})
+
+	return found
+}
+
+// FinishPlayer marks the player with the given GUID as finished with the given
+// finish type and data. False is returned if the player is not found.
+func (s *Server) FinishPlayer(guid string, finish distance.FinishType, data int) bool {
+	var found bool
+
+	s.Update(func(state *State) {
+		for i, player := range state.Summary.Players {
+			if player.UnityPlayerGUID == guid {
+				found = true
+
+				car := &state.Summary.Players[i].Car
+				car.Finished = true
+				car.FinishType = finish
+				car.FinishData = data
+				return
+			}
+		}
+	})
+
+	return found
+}
+
+// AddChat appends the given message to the chat log. The GUID and timestamp are
+// filled in if empty. The final message is returned.
+func (s *Server) AddChat(msg distance.ChatMessage) distance.ChatMessage {
+	s.Update(func(state *State) {
+		msg = s.addChat(state, msg)
+	})
+	return msg
+}
+
+// AddLinkCode registers a link code for the player with the given GUID, as if
+// the player had typed /link in game.
+func (s *Server) AddLinkCode(code, playerGUID string) {
+	s.Update(func(state *State) {
+		state.Links.CodesForward[code] = playerGUID
+	})
+}
+
+// addChat adds the message into the chat log. The state must be locked.
+func (s *Server) addChat(state *State, msg distance.ChatMessage) distance.ChatMessage {
+	s.nextID++
+
+	if msg.GUID == "" {
+		msg.GUID = "chat-" + strconv.Itoa(s.nextID)
+	}
+	if msg.Timestamp == 0 {
+		msg.Timestamp = timestamp(time.Now())
+	}
+	if msg.Type == "" {
+		msg.Type = distance.ServerCustomMessage
+	}
+
+	state.Summary.ChatLog = append(state.Summary.ChatLog, msg)
+	return msg
+}
+
+func timestamp(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func (s *Server) get(h http.HandlerFunc) http.HandlerFunc {
+	return s.method("GET", h)
+}
+
+func (s *Server) post(h http.HandlerFunc) http.HandlerFunc {
+	return s.method("POST", h)
+}
+
+func (s *Server) method(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.mutex.Lock()
+		code := s.status[r.URL.Path]
+		s.counts[r.URL.Path]++
+		s.mutex.Unlock()
+
+		if code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+func (s *Server) private(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if s.PrivateToken == "" || auth != "Bearer "+s.PrivateToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) serveSummary(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	writeJSON(w, s.state.Summary)
+}
+
+func (s *Server) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	start, _ := strconv.Atoi(r.FormValue("Start"))
+	count, _ := strconv.Atoi(r.FormValue("Count"))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.PageSize > 0 && (count <= 0 || count > s.PageSize) {
+		count = s.PageSize
+	}
+
+	levels := s.state.Playlist.Playlist.Levels
+	if start < 0 || start > len(levels) {
+		start = len(levels)
+	}
+	end := len(levels)
+	if count > 0 && start+count < end {
+		end = start + count
+	}
+
+	writeJSON(w, distance.PlaylistState{
+		CurrentLevelIndex: s.state.Playlist.CurrentLevelIndex,
+		Playlist: distance.Playlist{
+			Total:  len(levels),
+			Start:  start,
+			Count:  end - start,
+			Levels: levels[start:end],
+		},
+	})
+}
+
+func (s *Server) serveLinks(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	writeJSON(w, s.state.Links)
+}
+
+func (s *Server) serveLink(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		GUID string `json:"Guid"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.GUID == "" {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	session := randomToken()
+
+	s.Update(func(state *State) {
+		state.Links.Links[session] = body.GUID
+
+		// Consume the link codes for this player.
+		for code, guid := range state.Links.CodesForward {
+			if guid == body.GUID {
+				delete(state.Links.CodesForward, code)
+				state.Links.CodesReverse[code] = session
+			}
+		}
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:  "DistanceSession",
+		Value: session,
+		Path:  "/",
+	})
+}
+
+func (s *Server) serveChat(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("DistanceSession")
+	if err != nil {
+		http.Error(w, "missing session", http.StatusUnauthorized)
+		return
+	}
+
+	var body struct{ Message string }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	var ok bool
+
+	s.Update(func(state *State) {
+		guid, linked := state.Links.Links[cookie.Value]
+		if !linked {
+			return
+		}
+
+		player := state.Summary.FindPlayer(guid)
+		if player == nil {
+			return
+		}
+
+		ok = true
+		s.addChat(state, distance.ChatMessage{
+			Sender: guid,
+			Chat:   player.Name + ": " + body.Message,
+			Type:   distance.PlayerChatMessage,
+		})
+	})
+
+	if !ok {
+		http.Error(w, "invalid session", http.StatusUnauthorized)
+		return
+	}
+}
+
+func (s *Server) serveServerChat(w http.ResponseWriter, r *http.Request) {
+	var body struct{ Message string }
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	s.AddChat(distance.ChatMessage{
+		Sender: "server",
+		Chat:   body.Message,
+		Type:   distance.ServerCustomMessage,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("distancetest: failed to read random: " + err.Error())
+	}
+	return strings.ToUpper(hex.EncodeToString(b[:]))
+}
@@ -0,0 +1,310 @@
+package distance
+
+import "sync"
+
+// Event is a typed change between two consecutive observed states. It is one
+// of the *Event types in this package.
+type Event interface {
+	event()
+}
+
+// PlayerJoinedEvent is emitted when a player appears on the server.
+type PlayerJoinedEvent struct {
+	Player Player
+}
+
+// PlayerLeftEvent is emitted when a player disappears from the server. Player
+// is the last observed state of the player.
+type PlayerLeftEvent struct {
+	Player Player
+}
+
+// PlayerFinishedEvent is emitted when a player's car finishes the current
+// level. The finish type may be anything other than NoneFinish.
+type PlayerFinishedEvent struct {
+	Player Player
+	Level  Level
+}
+
+// LevelChangedEvent is emitted when the server moves on to another level.
+// Standings contains the players as last observed on the previous level, which
+// is useful for recording results.
+type LevelChangedEvent struct {
+	Previous  Level
+	Level     Level
+	Standings []Player
+}
+
+// ChatMessageReceivedEvent is emitted for every new message in the chat log.
+type ChatMessageReceivedEvent struct {
+	Message ChatMessage
+}
+
+// VoteCastEvent is emitted when a player casts a vote. Player is nil if the
+// voter is no longer on the server. Level is only valid for LevelVote.
+type VoteCastEvent struct {
+	PlayerGUID string
+	Player     *Player
+	Kind       VoteKind
+	Level      Level
+}
+
+// ModeStartedEvent is emitted when the game mode of the current level starts.
+type ModeStartedEvent struct {
+	Level Level
+}
+
+func (PlayerJoinedEvent) event()        {}
+func (PlayerLeftEvent) event()          {}
+func (PlayerFinishedEvent) event()      {}
+func (LevelChangedEvent) event()        {}
+func (ChatMessageReceivedEvent) event() {}
+func (VoteCastEvent) event()            {}
+func (ModeStartedEvent) event()         {}
+
+// VoteKind is the kind of vote in VoteCastEvent.
+type VoteKind string
+
+const (
+	SkipVote    VoteKind = "skip"
+	ExtendVote  VoteKind = "extend"
+	LevelVote   VoteKind = "level"
+	AgainstVote VoteKind = "against"
+)
+
+// DiffStates returns the events that happened between the two given states in
+// order. Parts of the state that are nil in either prev or next are not
+// diffed, so the first state of an Observer yields no events.
+func DiffStates(prev, next ObservedState) []Event {
+	var events []Event
+
+	if prev.Summary == nil || next.Summary == nil {
+		return events
+	}
+
+	ps, ns := prev.Summary, next.Summary
+
+	levelChanged := ps.Server.CurrentLevelID != ns.Server.CurrentLevelID
+	if prev.PlaylistState != nil && next.PlaylistState != nil {
+		levelChanged = levelChanged ||
+			prev.PlaylistState.CurrentLevelIndex != next.PlaylistState.CurrentLevelIndex
+	}
+
+	if levelChanged {
+		events = append(events, LevelChangedEvent{
+			Previous:  ps.Level,
+			Level:     ns.Level,
+			Standings: ps.Players,
+		})
+	}
+
+	if ns.Server.HasModeStarted && (levelChanged || !ps.Server.HasModeStarted) {
+		events = append(events, ModeStartedEvent{Level: ns.Level})
+	}
+
+	for _, player := range ps.Players {
+		if ns.FindPlayer(player.UnityPlayerGUID) == nil {
+			events = append(events, PlayerLeftEvent{Player: player})
+		}
+	}
+
+	for _, player := range ns.Players {
+		old := ps.FindPlayer(player.UnityPlayerGUID)
+		if old == nil {
+			events = append(events, PlayerJoinedEvent{Player: player})
+		}
+
+		// Only count finishes within the same level, since the previous
+		// state's car belongs to another level otherwise.
+		if player.Car.Finished && !levelChanged && (old == nil || !old.Car.Finished) {
+			events = append(events, PlayerFinishedEvent{
+				Player: player,
+				Level:  ns.Level,
+			})
+		}
+	}
+
+	events = diffVotes(events, ps, ns)
+
+	// Index the previous chat log so we know which messages are new.
+	seen := make(map[string]struct{}, len(ps.ChatLog))
+	for _, msg := range ps.ChatLog {
+		seen[msg.GUID] = struct{}{}
+	}
+
+	for _, msg := range ns.ChatLog {
+		if _, ok := seen[msg.GUID]; !ok {
+			events = append(events, ChatMessageReceivedEvent{Message: msg})
+		}
+	}
+
+	return events
+}
+
+func diffVotes(events []Event, ps, ns *Summary) []Event {
+	pv, nv := ps.VoteCommands, ns.VoteCommands
+
+	addVote := func(guid string, kind VoteKind, level Level) {
+		events = append(events, VoteCastEvent{
+			PlayerGUID: guid,
+			Player:     ns.FindPlayer(guid),
+			Kind:       kind,
+			Level:      level,
+		})
+	}
+
+	for _, guid := range newStrings(pv.SkipVotes, nv.SkipVotes) {
+		addVote(guid, SkipVote, Level{})
+	}
+
+	for _, guid := range newStrings(pv.ExtendVotes, nv.ExtendVotes) {
+		addVote(guid, ExtendVote, Level{})
+	}
+
+	for guid, level := range nv.PlayerVotes {
+		old, ok := pv.PlayerVotes[guid]
+		if !ok || old.RelativeLevelPath != level.RelativeLevelPath {
+			addVote(guid, LevelVote, level)
+		}
+	}
+
+	for guid, n := range nv.AgainstVotes {
+		if n > pv.AgainstVotes[guid] {
+			addVote(guid, AgainstVote, Level{})
+		}
+	}
+
+	return events
+}
+
+// newStrings returns the strings in next that are not in prev.
+func newStrings(prev, next []string) []string {
+	var added []string
+
+	for _, str := range next {
+		if !containsString(prev, str) {
+			added = append(added, str)
+		}
+	}
+
+	return added
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// maxQueuedEvents is the maximum number of events queued for a subscriber. A
+// subscriber that falls further behind is dropped.
+const maxQueuedEvents = 4096
+
+// eventSub is a single events subscriber. Events are queued and pumped into the
+// channel by its own goroutine, so a slow subscriber never blocks the Observer.
+type eventSub struct {
+	ch    chan Event
+	sig   chan struct{}
+	stop  chan struct{}
+	once  sync.Once
+	mutex sync.Mutex
+	queue []Event
+}
+
+func newEventSub() *eventSub {
+	sub := &eventSub{
+		ch:   make(chan Event),
+		sig:  make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+	go sub.pump()
+	return sub
+}
+
+// push queues the events. False is returned if the subscriber fell too far
+// behind, in which case it is closed instead.
+func (sub *eventSub) push(events []Event) bool {
+	sub.mutex.Lock()
+	overflow := len(sub.queue)+len(events) > maxQueuedEvents
+	if !overflow {
+		sub.queue = append(sub.queue, events...)
+	}
+	sub.mutex.Unlock()
+
+	if overflow {
+		sub.close()
+		return false
+	}
+
+	select {
+	case sub.sig <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (sub *eventSub) close() {
+	sub.once.Do(func() { close(sub.stop) })
+}
+
+func (sub *eventSub) pump() {
+	defer close(sub.ch)
+
+	for {
+		sub.mutex.Lock()
+		queue := sub.queue
+		sub.queue = nil
+		sub.mutex.Unlock()
+
+		for _, ev := range queue {
+			select {
+			case sub.ch <- ev:
+			case <-sub.stop:
+				return
+			}
+		}
+
+		select {
+		case <-sub.sig:
+		case <-sub.stop:
+			return
+		}
+	}
+}
+
+// SubscribeEvents subscribes to typed change events from the Observer. Events
+// are computed by diffing consecutive states using DiffStates. If the returned
+// callback is called, the channel will be closed shortly after, and pending
+// events are discarded.
+//
+// Unlike Subscribe, events are not dropped; they are queued until the
+// subscriber receives them. A subscriber that falls more than maxQueuedEvents
+// behind is closed, as if the Observer were shut down, so subscribers should
+// keep receiving until they cancel.
+//
+// When the Observer is shut down, its subscribed channels will be closed.
+func (obs *Observer) SubscribeEvents() (<-chan Event, func()) {
+	sub := newEventSub()
+
+	obs.subMu.Lock()
+	defer obs.subMu.Unlock()
+
+	// If the observer is invalidated, then return an already closed channel.
+	if obs.evSubs == nil {
+		sub.close()
+		return sub.ch, func() {}
+	}
+
+	obs.evSubs[sub] = struct{}{}
+
+	return sub.ch, func() {
+		obs.subMu.Lock()
+		delete(obs.evSubs, sub)
+		obs.subMu.Unlock()
+
+		sub.close()
+	}
+}
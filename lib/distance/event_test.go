@@ -0,0 +1,24 @@
+package distance
+
+import "testing"
+
+func TestEventSubOverflow(t *testing.T) {
+	sub := newEventSub()
+
+	events := make([]Event, maxQueuedEvents/2)
+	for i := range events {
+		events[i] = ModeStartedEvent{}
+	}
+
+	// Without receiving, the queue overflows after a few pushes.
+	pushes := 0
+	for sub.push(events) {
+		if pushes++; pushes > 4 {
+			t.Fatal("queue never overflowed")
+		}
+	}
+
+	// The channel is closed once the subscriber is dropped.
+	for range sub.ch {
+	}
+}
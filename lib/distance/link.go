@@ -2,7 +2,6 @@ package distance
 
 import (
 	"encoding/json"
-	"log"
 	"net/url"
 
 	"github.com/pkg/errors"
@@ -26,7 +25,6 @@ func (c *Client) Link(playerGUID string) (string, error) {
 
 	for _, cookie := range r.Cookies() {
 		if cookie.Name == "DistanceSession" {
-			log.Printf("%#v\n", cookie)
 			return cookie.Value, nil
 		}
 	}
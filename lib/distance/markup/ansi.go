@@ -0,0 +1,115 @@
+package markup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ANSIRenderer renders markup for terminals using ANSI escape sequences with
+// 24-bit colors. Links are written as OSC 8 hyperlinks.
+type ANSIRenderer struct {
+	// ColorModifier modifies text colors like HTMLRenderer's.
+	ColorModifier func(string) string
+}
+
+var ansiStyles = map[StyleKind][2]string{
+	Bold:      {"1", "22"},
+	Italic:    {"3", "23"},
+	Underline: {"4", "24"},
+	Strike:    {"9", "29"},
+}
+
+// Render implements Renderer.
+func (r ANSIRenderer) Render(nodes []Node) string {
+	var b strings.Builder
+
+	// Styles may be nested, so each is only turned off once its outermost
+	// node is exited. Colors are restored to the parent color instead.
+	var styles [Superscript + 1]int
+	var colors []string
+
+	sgr := func(code string) {
+		b.WriteString("\x1b[")
+		b.WriteString(code)
+		b.WriteByte('m')
+	}
+
+	Walk(nodes, func(n Node, entering bool) bool {
+		switch n := n.(type) {
+		case Text:
+			b.WriteString(stripEscapes(string(n)))
+
+		case *Color:
+			hex := n.Hex
+			if r.ColorModifier != nil {
+				hex = r.ColorModifier(hex)
+			}
+			if hex == "" {
+				break
+			}
+
+			if entering {
+				colors = append(colors, hex)
+				sgr(ansiColor(hex))
+				break
+			}
+
+			colors = colors[:len(colors)-1]
+			if len(colors) > 0 {
+				sgr(ansiColor(colors[len(colors)-1]))
+			} else {
+				sgr("39")
+			}
+
+		case *Style:
+			codes, ok := ansiStyles[n.Kind]
+			if !ok {
+				break
+			}
+
+			if entering {
+				if styles[n.Kind]++; styles[n.Kind] == 1 {
+					sgr(codes[0])
+				}
+			} else {
+				if styles[n.Kind]--; styles[n.Kind] == 0 {
+					sgr(codes[1])
+				}
+			}
+
+		case *URL:
+			if entering {
+				b.WriteString("\x1b]8;;")
+				b.WriteString(stripEscapes(n.Target()))
+				b.WriteString("\x1b\\")
+			} else {
+				b.WriteString("\x1b]8;;\x1b\\")
+			}
+		}
+
+		return true
+	})
+
+	return b.String()
+}
+
+// ansiColor returns the SGR code for the given hex color.
+func ansiColor(hex string) string {
+	var r, g, b uint8
+	fmt.Sscanf(hex, "%02x%02x%02x", &r, &g, &b)
+	return fmt.Sprintf("38;2;%d;%d;%d", r, g, b)
+}
+
+// stripEscapes removes the escape and other control characters from text, so
+// that chat messages can't mess with the terminal.
+func stripEscapes(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if r < 0x20 || r == 0x7F || (r >= 0x80 && r < 0xA0) {
+			return -1
+		}
+		return r
+	}, text)
+}
@@ -0,0 +1,237 @@
+package markup
+
+import "strings"
+
+// Node is a node in parsed markup. It is one of Text, *Color, *Style, *URL or
+// *NoTint.
+type Node interface {
+	node()
+}
+
+// Text is plain text.
+type Text string
+
+// Color colors its children, e.g. "[FF0000]red[-]".
+type Color struct {
+	// Hex is the color as written, e.g. "FF0000".
+	Hex      string
+	Children []Node
+}
+
+// StyleKind is the kind of a Style.
+type StyleKind uint8
+
+const (
+	Bold        StyleKind = iota // [b]
+	Italic                       // [i]
+	Underline                    // [u]
+	Strike                       // [s]
+	Subscript                    // [sub]
+	Superscript                  // [sup]
+)
+
+// Style styles its children, e.g. "[b]bold[/b]".
+type Style struct {
+	Kind     StyleKind
+	Children []Node
+}
+
+// URL links its children, e.g. "[url=https://example.com]link[/url]".
+type URL struct {
+	// Href is the link target. It is empty for "[url]", in which case the
+	// children's text is the target; use Target to get it.
+	Href     string
+	Children []Node
+}
+
+// Target returns the link target.
+func (u *URL) Target() string {
+	if u.Href != "" {
+		return u.Href
+	}
+	return PlainText(u.Children)
+}
+
+// NoTint makes its children ignore the game's color tint, e.g. "[c]text[/c]".
+// It has no visual effect outside the game.
+type NoTint struct {
+	Children []Node
+}
+
+func (Text) node()    {}
+func (*Color) node()  {}
+func (*Style) node()  {}
+func (*URL) node()    {}
+func (*NoTint) node() {}
+
+// Children returns the children of the given node, or nil if it's Text.
+func Children(n Node) []Node {
+	if c, ok := n.(container); ok {
+		return *c.children()
+	}
+	return nil
+}
+
+// container is a node with children.
+type container interface {
+	Node
+	children() *[]Node
+	// empty returns a copy of the node without any children.
+	empty() container
+}
+
+func (c *Color) children() *[]Node  { return &c.Children }
+func (s *Style) children() *[]Node  { return &s.Children }
+func (u *URL) children() *[]Node    { return &u.Children }
+func (c *NoTint) children() *[]Node { return &c.Children }
+
+func (c *Color) empty() container  { return &Color{Hex: c.Hex} }
+func (s *Style) empty() container  { return &Style{Kind: s.Kind} }
+func (u *URL) empty() container    { return &URL{Href: u.Href} }
+func (c *NoTint) empty() container { return &NoTint{} }
+
+var styleTags = map[string]StyleKind{
+	"b":   Bold,
+	"i":   Italic,
+	"u":   Underline,
+	"s":   Strike,
+	"sub": Subscript,
+	"sup": Superscript,
+}
+
+// Parse parses the given Distance markup. It never fails: tags that don't
+// match are kept as text, and tags that are never closed are closed at the
+// end.
+//
+// Tags that are closed out of order, e.g. "[b]a[i]b[/b]c[/i]", are reopened
+// after the close, so the above is parsed as "[b]a[i]b[/i][/b][i]c[/i]".
+func Parse(markup string) []Node {
+	var p parser
+
+	var prev int
+	for _, match := range tagMatcherRegex.FindAllStringIndex(markup, -1) {
+		p.text(markup[prev:match[0]])
+		prev = match[1]
+
+		tag := markup[match[0]:match[1]]
+		if !p.tag(tagBody(tag)) {
+			p.text(tag)
+		}
+	}
+
+	p.text(markup[prev:])
+	p.closeAll()
+
+	return p.root
+}
+
+type parser struct {
+	root  []Node
+	stack []openTag
+}
+
+type openTag struct {
+	closeOn string
+	node    container
+}
+
+// tag handles the tag with the given body. False is returned if the tag
+// doesn't match anything and should be written as text.
+func (p *parser) tag(body string) bool {
+	if body == "-" || strings.HasPrefix(body, "/") {
+		return p.close(body)
+	}
+
+	switch {
+	case strings.HasPrefix(body, "url="):
+		p.open("/url", &URL{Href: strings.TrimPrefix(body, "url=")})
+	case body == "url":
+		p.open("/url", &URL{})
+	case body == "c":
+		p.open("/c", &NoTint{})
+	case hex(body) != "":
+		p.open("-", &Color{Hex: body})
+	default:
+		kind, ok := styleTags[body]
+		if !ok {
+			return false
+		}
+		p.open("/"+body, &Style{Kind: kind})
+	}
+
+	return true
+}
+
+// current returns the children of the innermost open node.
+func (p *parser) current() *[]Node {
+	if len(p.stack) == 0 {
+		return &p.root
+	}
+	return p.stack[len(p.stack)-1].node.children()
+}
+
+func (p *parser) text(text string) {
+	if text == "" {
+		return
+	}
+
+	nodes := p.current()
+
+	// Merge with the previous text, which happens when a tag is kept as text.
+	if n := len(*nodes); n > 0 {
+		if prev, ok := (*nodes)[n-1].(Text); ok {
+			(*nodes)[n-1] = prev + Text(text)
+			return
+		}
+	}
+
+	*nodes = append(*nodes, Text(text))
+}
+
+func (p *parser) open(closeOn string, node container) {
+	nodes := p.current()
+	*nodes = append(*nodes, node)
+	p.stack = append(p.stack, openTag{closeOn, node})
+}
+
+// close closes the innermost open tag that closeOn closes. False is returned if
+// there's none.
+func (p *parser) close(closeOn string) bool {
+	i := len(p.stack) - 1
+	for ; i >= 0 && p.stack[i].closeOn != closeOn; i-- {
+	}
+	if i < 0 {
+		return false
+	}
+
+	reopen := append([]openTag(nil), p.stack[i+1:]...)
+
+	for len(p.stack) > i {
+		p.pop()
+	}
+
+	for _, tag := range reopen {
+		p.open(tag.closeOn, tag.node.empty())
+	}
+
+	return true
+}
+
+func (p *parser) closeAll() {
+	for len(p.stack) > 0 {
+		p.pop()
+	}
+}
+
+// pop closes the innermost open tag. It is removed if it has no children.
+func (p *parser) pop() {
+	top := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+
+	if len(*top.node.children()) == 0 {
+		// The node must be the last child of its parent, since everything
+		// after it went into it.
+		nodes := p.current()
+		*nodes = (*nodes)[:len(*nodes)-1]
+	}
+}
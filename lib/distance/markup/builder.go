@@ -0,0 +1,153 @@
+package markup
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// zeroWidthSpace is inserted after a '[' in text that would start a tag, so
+// that it shows as-is.
+const zeroWidthSpace = "\u200B"
+
+// anchoredTagRegex matches a tag at the start of a string.
+var anchoredTagRegex = regexp.MustCompile(`^(?:` + tagMatcherRegex.String() + `)`)
+
+// tagBodyPrefixes are the bodies of the tags other than colors and links, and
+// the start of a link tag.
+var tagBodyPrefixes = []string{
+	"b", "i", "u", "s", "c", "sub", "sup", "url=",
+	"/b", "/i", "/u", "/s", "/c", "/sub", "/sup", "/url", "-",
+}
+
+// Escape escapes the given text so that it shows as-is instead of being parsed
+// as markup. Only the '[' that start a tag are escaped, along with one at the
+// end that could start a tag once other markup is appended, e.g. "[b" followed
+// by "]"; other text is left alone.
+func Escape(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+
+	for {
+		i := strings.IndexByte(text, '[')
+		if i < 0 {
+			b.WriteString(text)
+			return b.String()
+		}
+
+		b.WriteString(text[:i+1])
+		if startsTag(text[i:]) {
+			b.WriteString(zeroWidthSpace)
+		}
+
+		text = text[i+1:]
+	}
+}
+
+// startsTag returns true if s, which starts with '[', starts with a tag or is
+// the start of one.
+func startsTag(s string) bool {
+	if anchoredTagRegex.MatchString(s) {
+		return true
+	}
+
+	// Tags end at the first ']', so only the rest of an unterminated tag can
+	// be appended.
+	body := s[1:]
+	if strings.Contains(body, "]") {
+		return false
+	}
+
+	if strings.HasPrefix(body, "url=") {
+		return true
+	}
+	if len(body) <= 6 && strings.Trim(body, "0123456789ABCDEF") == "" {
+		return true
+	}
+	for _, prefix := range tagBodyPrefixes {
+		if strings.HasPrefix(prefix, body) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Builder builds Distance markup. Text is escaped, and tags are always closed
+// in the right order. The zero value is ready to use.
+type Builder struct {
+	b strings.Builder
+}
+
+// String returns the built markup.
+func (b *Builder) String() string {
+	return b.b.String()
+}
+
+// Text writes the given text.
+func (b *Builder) Text(text string) *Builder {
+	b.b.WriteString(Escape(text))
+	return b
+}
+
+// Markup writes the given markup as-is. It must be balanced, e.g. from
+// FromMarkdown or another Builder.
+func (b *Builder) Markup(markup string) *Builder {
+	b.b.WriteString(markup)
+	return b
+}
+
+// Color writes whatever fn writes in the given color.
+func (b *Builder) Color(c colorful.Color, fn func(*Builder)) *Builder {
+	hex := strings.ToUpper(c.Clamped().Hex()[1:])
+	return b.wrap("["+hex+"]", "[-]", fn)
+}
+
+// Style writes whatever fn writes in the given style.
+func (b *Builder) Style(kind StyleKind, fn func(*Builder)) *Builder {
+	var tag string
+	switch kind {
+	case Bold:
+		tag = "b"
+	case Italic:
+		tag = "i"
+	case Underline:
+		tag = "u"
+	case Strike:
+		tag = "s"
+	case Subscript:
+		tag = "sub"
+	case Superscript:
+		tag = "sup"
+	default:
+		fn(b)
+		return b
+	}
+
+	return b.wrap("["+tag+"]", "[/"+tag+"]", fn)
+}
+
+// Bold writes whatever fn writes in bold.
+func (b *Builder) Bold(fn func(*Builder)) *Builder {
+	return b.Style(Bold, fn)
+}
+
+// Italic writes whatever fn writes in italics.
+func (b *Builder) Italic(fn func(*Builder)) *Builder {
+	return b.Style(Italic, fn)
+}
+
+var hrefEscaper = strings.NewReplacer("[", "%5B", "]", "%5D")
+
+// Link writes whatever fn writes as a link to href.
+func (b *Builder) Link(href string, fn func(*Builder)) *Builder {
+	return b.wrap("[url="+hrefEscaper.Replace(href)+"]", "[/url]", fn)
+}
+
+func (b *Builder) wrap(open, close string, fn func(*Builder)) *Builder {
+	b.b.WriteString(open)
+	fn(b)
+	b.b.WriteString(close)
+	return b
+}
@@ -0,0 +1,81 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hexops/autogold"
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+func TestBuilder(t *testing.T) {
+	var b Builder
+	b.Text("Welcome ").
+		Color(colorful.Color{R: 0, G: 1, B: 0}, func(b *Builder) {
+			b.Bold(func(b *Builder) { b.Text("[b]player[-]") })
+		}).
+		Text(" to ").
+		Link("https://example.com/[x]", func(b *Builder) { b.Text("the server") })
+
+	got := b.String()
+
+	want := autogold.Want("builder", "Welcome [00FF00][b][\u200Bb]player[\u200B-][/b][-] to [url=https://example.com/%5Bx%5D]the server[/url]")
+	want.Equal(t, got)
+
+	// The escaped text must survive parsing as-is.
+	plain := strings.ReplaceAll(PlainText(Parse(got)), zeroWidthSpace, "")
+	if plain != "Welcome [b]player[-] to the server" {
+		t.Fatalf("unexpected plain text %q", plain)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	var tests = []struct {
+		in, out string
+	}{
+		{"[Discord] [x] a[]b", "[Discord] [x] a[]b"},
+		{"[b]hi[/b] [C0FFEE]", "[\u200Bb]hi[\u200B/b] [\u200BC0FFEE]"},
+		{"[url=https://example.com]", "[\u200Burl=https://example.com]"},
+		{"trailing [su", "trailing [\u200Bsu"},
+		{"trailing [sux", "trailing [sux"},
+	}
+
+	for _, test := range tests {
+		if got := Escape(test.in); got != test.out {
+			t.Errorf("Escape(%q) = %q, want %q", test.in, got, test.out)
+		}
+	}
+}
+
+func TestFromMarkdown(t *testing.T) {
+	type test struct {
+		Input string
+		autogold.Value
+	}
+
+	var tests = []test{{
+		"**bold** *italic* __under__ ~~strike~~",
+		autogold.Want("styles", "[b]bold[/b] [i]italic[/i] [u]under[/u] [s]strike[/s]"),
+	}, {
+		"**bold _and italic_**",
+		autogold.Want("nested", "[b]bold [i]and italic[/i][/b]"),
+	}, {
+		"snake_case_name and 2 * 3 * 4",
+		autogold.Want("not formatting", "snake_case_name and 2 * 3 * 4"),
+	}, {
+		"`**code**` \\*escaped\\*",
+		autogold.Want("code and escapes", "**code** *escaped*"),
+	}, {
+		"[the **site**](https://example.com) [bad](javascript:alert(1))",
+		autogold.Want("links", "[url=https://example.com]the [b]site[/b][/url] [bad](javascript:alert(1))"),
+	}, {
+		"[FF0000]red[-] **[b]**",
+		autogold.Want("markup escaped", "[\u200BFF0000]red[\u200B-] [b][\u200Bb][/b]"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.Name(), func(t *testing.T) {
+			test.Value.Equal(t, FromMarkdown(test.Input))
+		})
+	}
+}
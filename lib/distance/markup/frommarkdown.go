@@ -0,0 +1,169 @@
+package markup
+
+import (
+	"net/url"
+	"strings"
+)
+
+// FromMarkdown converts a subset of Markdown into Distance markup. The subset
+// is what chat clients commonly support:
+//
+//	**bold**, *italic*, _italic_, __underline__, ~~strike~~, `code`,
+//	[label](https://example.com) and backslash escapes.
+//
+// Everything else, including any Distance markup in the input, is kept as
+// escaped text. Only http and https links are converted.
+func FromMarkdown(md string) string {
+	var b Builder
+	convertMarkdown(&b, md)
+	return b.String()
+}
+
+var markdownStyles = []struct {
+	delim string
+	kind  StyleKind
+}{
+	// Longer delimiters go first.
+	{"**", Bold},
+	{"__", Underline},
+	{"~~", Strike},
+	{"*", Italic},
+	{"_", Italic},
+}
+
+func convertMarkdown(b *Builder, md string) {
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			b.Text(text.String())
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(md); {
+		switch md[i] {
+		case '\\':
+			if i+1 < len(md) && isMarkdownPunct(md[i+1]) {
+				text.WriteByte(md[i+1])
+				i += 2
+				continue
+			}
+
+		case '`':
+			// Code is kept as-is without any formatting.
+			if end := strings.IndexByte(md[i+1:], '`'); end > 0 {
+				text.WriteString(md[i+1 : i+1+end])
+				i += end + 2
+				continue
+			}
+
+		case '[':
+			if label, href, n := markdownLink(md[i:]); n > 0 {
+				flush()
+				b.Link(href, func(b *Builder) { convertMarkdown(b, label) })
+				i += n
+				continue
+			}
+
+		default:
+			if kind, inner, n := markdownStyle(md, i); n > 0 {
+				flush()
+				b.Style(kind, func(b *Builder) { convertMarkdown(b, inner) })
+				i += n
+				continue
+			}
+		}
+
+		text.WriteByte(md[i])
+		i++
+	}
+
+	flush()
+}
+
+// markdownStyle parses the styled text starting at md[i]. n is 0 if there's
+// none.
+func markdownStyle(md string, i int) (kind StyleKind, inner string, n int) {
+	for _, style := range markdownStyles {
+		if !strings.HasPrefix(md[i:], style.delim) {
+			continue
+		}
+
+		// Underscores inside words, e.g. snake_case, aren't formatting.
+		if style.delim[0] == '_' && i > 0 && isAlnum(md[i-1]) {
+			return 0, "", 0
+		}
+
+		start := i + len(style.delim)
+		end := indexUnescaped(md[start:], style.delim)
+		if end <= 0 {
+			continue
+		}
+
+		inner = md[start : start+end]
+		if strings.TrimSpace(inner) != inner {
+			continue
+		}
+
+		after := start + end + len(style.delim)
+		if style.delim[0] == '_' && after < len(md) && isAlnum(md[after]) {
+			continue
+		}
+
+		return style.kind, inner, after - i
+	}
+
+	return 0, "", 0
+}
+
+// markdownLink parses the link at the start of md, which starts with '['. n is
+// 0 if there's none.
+func markdownLink(md string) (label, href string, n int) {
+	end := indexUnescaped(md[1:], "](")
+	if end < 0 {
+		return "", "", 0
+	}
+
+	label = md[1 : 1+end]
+	rest := md[1+end+2:]
+
+	hrefEnd := strings.IndexByte(rest, ')')
+	if hrefEnd < 0 {
+		return "", "", 0
+	}
+
+	href = rest[:hrefEnd]
+
+	u, err := url.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", 0
+	}
+
+	return label, href, 1 + end + 2 + hrefEnd + 1
+}
+
+// indexUnescaped is strings.Index, but it skips matches that are escaped with
+// a backslash.
+func indexUnescaped(s, substr string) int {
+	for i := 0; i < len(s); {
+		j := strings.Index(s[i:], substr)
+		if j < 0 {
+			return -1
+		}
+		j += i
+		if j == 0 || s[j-1] != '\\' {
+			return j
+		}
+		i = j + 1
+	}
+	return -1
+}
+
+func isMarkdownPunct(c byte) bool {
+	return strings.IndexByte("\\`*_~[]()#>|<!-+.", c) >= 0
+}
+
+func isAlnum(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,167 @@
+package markup
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// IRCRenderer renders markup using mIRC formatting codes. Colors are mapped to
+// the closest of the 16 standard mIRC colors. Links whose text differs from
+// their target are followed by the target, since IRC has no hyperlinks.
+type IRCRenderer struct {
+	// ColorModifier modifies text colors like HTMLRenderer's.
+	ColorModifier func(string) string
+}
+
+// mircColors is the standard mIRC palette, indexed by color code.
+var mircColors = [16]colorful.Color{
+	mustHex("#FFFFFF"), mustHex("#000000"), mustHex("#00007F"), mustHex("#009300"),
+	mustHex("#FF0000"), mustHex("#7F0000"), mustHex("#9C009C"), mustHex("#FC7F00"),
+	mustHex("#FFFF00"), mustHex("#00FC00"), mustHex("#009393"), mustHex("#00FFFF"),
+	mustHex("#0000FC"), mustHex("#FF00FF"), mustHex("#7F7F7F"), mustHex("#D2D2D2"),
+}
+
+var ircStyles = map[StyleKind]byte{
+	Bold:      '\x02',
+	Italic:    '\x1D',
+	Underline: '\x1F',
+	Strike:    '\x1E',
+}
+
+// Render implements Renderer.
+func (r IRCRenderer) Render(nodes []Node) string {
+	var b strings.Builder
+
+	// Styles are toggles, so like in ANSIRenderer, each is only toggled by its
+	// outermost node. Colors are restored to the parent color instead.
+	var styles [Superscript + 1]int
+	var colors []string
+	var afterColor bool
+
+	color := func(hex string) {
+		b.WriteByte('\x03')
+		b.WriteString(mircColor(hex))
+		afterColor = true
+	}
+
+	Walk(nodes, func(n Node, entering bool) bool {
+		switch n := n.(type) {
+		case Text:
+			text := stripIRC(string(n))
+			if text == "" {
+				break
+			}
+			// A comma right after a color code would be read as the start of
+			// a background color, so separate them with an empty bold.
+			if afterColor && text[0] == ',' {
+				b.WriteString("\x02\x02")
+			}
+			b.WriteString(text)
+			afterColor = false
+
+		case *Color:
+			hex := n.Hex
+			if r.ColorModifier != nil {
+				hex = r.ColorModifier(hex)
+			}
+			if hex == "" {
+				break
+			}
+
+			if entering {
+				colors = append(colors, hex)
+				color(hex)
+				break
+			}
+
+			colors = colors[:len(colors)-1]
+			if len(colors) > 0 {
+				color(colors[len(colors)-1])
+				break
+			}
+
+			// A lone color code followed by digits would be read as a color,
+			// so reset everything and turn the styles back on.
+			b.WriteByte('\x0F')
+			afterColor = false
+			for kind, count := range styles {
+				if count > 0 {
+					b.WriteByte(ircStyles[StyleKind(kind)])
+				}
+			}
+
+		case *Style:
+			code, ok := ircStyles[n.Kind]
+			if !ok {
+				break
+			}
+
+			if entering {
+				styles[n.Kind]++
+			} else {
+				styles[n.Kind]--
+			}
+			if styles[n.Kind] == 0 || (entering && styles[n.Kind] == 1) {
+				b.WriteByte(code)
+			}
+
+		case *URL:
+			if entering {
+				break
+			}
+			if target := n.Target(); PlainText(n.Children) != target {
+				b.WriteString(" <")
+				b.WriteString(stripIRC(target))
+				b.WriteByte('>')
+				afterColor = false
+			}
+		}
+
+		return true
+	})
+
+	return b.String()
+}
+
+// mircColor returns the two-digit mIRC color code closest to the given hex
+// color.
+func mircColor(hex string) string {
+	c, err := colorful.Hex("#" + hex)
+	if err != nil {
+		return "99" // default color
+	}
+
+	best, bestDist := 0, c.DistanceLab(mircColors[0])
+	for i, mirc := range mircColors[1:] {
+		if dist := c.DistanceLab(mirc); dist < bestDist {
+			best, bestDist = i+1, dist
+		}
+	}
+
+	if best < 10 {
+		return "0" + strconv.Itoa(best)
+	}
+	return strconv.Itoa(best)
+}
+
+// stripIRC removes control characters, which include IRC formatting codes, and
+// turns line breaks into spaces, since a message must be a single line.
+func stripIRC(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, text)
+	return stripEscapes(text)
+}
+
+func mustHex(hex string) colorful.Color {
+	c, err := colorful.Hex(hex)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
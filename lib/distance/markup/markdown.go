@@ -0,0 +1,86 @@
+package markup
+
+import "strings"
+
+// MarkdownRenderer renders markup as Markdown. Colors can't be represented, so
+// they are dropped, as are subscripts and superscripts. Markdown in the text is
+// escaped.
+type MarkdownRenderer struct {
+	// Underline enables underlining using "__", which only some flavors
+	// support, e.g. Discord's. Standard Markdown renders it as bold, so it is
+	// dropped by default.
+	Underline bool
+}
+
+// Render implements Renderer.
+func (r MarkdownRenderer) Render(nodes []Node) string {
+	var b strings.Builder
+
+	Walk(nodes, func(n Node, entering bool) bool {
+		switch n := n.(type) {
+		case Text:
+			b.WriteString(EscapeMarkdown(string(n)))
+
+		case *Style:
+			switch n.Kind {
+			case Bold:
+				b.WriteString("**")
+			case Italic:
+				b.WriteString("*")
+			case Strike:
+				b.WriteString("~~")
+			case Underline:
+				if r.Underline {
+					b.WriteString("__")
+				}
+			}
+
+		case *URL:
+			if entering {
+				b.WriteByte('[')
+			} else {
+				b.WriteString("](")
+				b.WriteString(escapeMarkdownURL(n.Target()))
+				b.WriteByte(')')
+			}
+		}
+
+		return true
+	})
+
+	return b.String()
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"`", "\\`",
+	"[", `\[`,
+	"]", `\]`,
+	"(", `\(`,
+	")", `\)`,
+	"<", `\<`,
+	">", `\>`,
+	"#", `\#`,
+	"|", `\|`,
+)
+
+// EscapeMarkdown escapes the characters that Markdown could interpret as
+// formatting.
+func EscapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+var markdownURLEscaper = strings.NewReplacer(
+	" ", "%20",
+	"(", "%28",
+	")", "%29",
+	"<", "%3C",
+	">", "%3E",
+)
+
+func escapeMarkdownURL(url string) string {
+	return markdownURLEscaper.Replace(url)
+}
@@ -24,7 +24,7 @@ func TestMarkup(t *testing.T) {
 		autogold.Want("unknown c tag", `<span style="color:#FFE999">Rynero reset</span>`),
 	}, {
 		"[url=https://google.com]best website[/url]",
-		autogold.Want("hyperlink", `<a href="https://google.com">best website</a>`),
+		autogold.Want("hyperlink", `<a href="https://google.com" rel="nofollow noopener noreferrer">best website</a>`),
 	}}
 
 	for _, test := range tests {
@@ -33,3 +33,100 @@ func TestMarkup(t *testing.T) {
 		})
 	}
 }
+
+func TestToHTMLEscaping(t *testing.T) {
+	type test struct {
+		Input string
+		autogold.Value
+	}
+
+	var tests = []test{{
+		"[b]<script>",
+		autogold.Want("unclosed tail", `<b>&lt;script&gt;</b>`),
+	}, {
+		"a [/b] <b>",
+		autogold.Want("unmatched close tag", `a [/b] &lt;b&gt;`),
+	}, {
+		"[url=javascript:alert(1)]x[/url] [url=//example.com]y[/url]",
+		autogold.Want("disallowed url", `x y`),
+	}, {
+		`[url=https://example.com/"><script>]x[/url]`,
+		autogold.Want("url attribute", `<a href="https://example.com/%22%3E%3Cscript%3E" rel="nofollow noopener noreferrer">x</a>`),
+	}, {
+		"[url=https://a.com]a [url=https://b.com]b[/url] c[/url]",
+		autogold.Want("nested url", `<a href="https://a.com" rel="nofollow noopener noreferrer">a b c</a>`),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.Name(), func(t *testing.T) {
+			test.Value.Equal(t, ToHTML(test.Input))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	type test struct {
+		Input string
+		autogold.Value
+	}
+
+	var tests = []test{{
+		"[b]a[i]b[/b]c[/i]",
+		autogold.Want("misnested", []Node{
+			&Style{Kind: Bold, Children: []Node{
+				Text("a"),
+				&Style{Kind: Italic, Children: []Node{Text("b")}},
+			}},
+			&Style{Kind: Italic, Children: []Node{Text("c")}},
+		}),
+	}, {
+		"[FF0000][00FF00]green[-]red[-][-]",
+		autogold.Want("nested colors", []Node{
+			&Color{Hex: "FF0000", Children: []Node{
+				&Color{Hex: "00FF00", Children: []Node{Text("green")}},
+				Text("red"),
+			}},
+			Text("[-]"),
+		}),
+	}, {
+		"[url]https://example.com[/url][b][/b]",
+		autogold.Want("bare url and empty tag", []Node{
+			&URL{Children: []Node{Text("https://example.com")}},
+		}),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.Name(), func(t *testing.T) {
+			test.Value.Equal(t, Parse(test.Input))
+		})
+	}
+}
+
+func TestRenderers(t *testing.T) {
+	const input = "[FF0000]Red [b]bold[/b][-] [s]x_y[/s] [url=https://example.com/(a)]link[/url]\x1b[2J"
+
+	type test struct {
+		Renderer Renderer
+		autogold.Value
+	}
+
+	var tests = []test{{
+		PlainRenderer{},
+		autogold.Want("plain", "Red bold x_y link\x1b[2J"),
+	}, {
+		ANSIRenderer{},
+		autogold.Want("ansi", "\x1b[38;2;255;0;0mRed \x1b[1mbold\x1b[22m\x1b[39m \x1b[9mx_y\x1b[29m \x1b]8;;https://example.com/(a)\x1b\\link\x1b]8;;\x1b\\[2J"),
+	}, {
+		MarkdownRenderer{},
+		autogold.Want("markdown", "Red **bold** ~~x\\_y~~ [link](https://example.com/%28a%29)\x1b\\[2J"),
+	}, {
+		IRCRenderer{},
+		autogold.Want("irc", "\x0304Red \x02bold\x02\x0f \x1ex_y\x1e link <https://example.com/(a)>[2J"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.Name(), func(t *testing.T) {
+			test.Value.Equal(t, RenderString(test.Renderer, input))
+		})
+	}
+}
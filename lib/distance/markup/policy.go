@@ -0,0 +1,69 @@
+package markup
+
+import (
+	"net/url"
+	"strings"
+)
+
+// URLPolicy decides which links are rendered and how. Links that aren't
+// allowed are rendered as their text.
+type URLPolicy struct {
+	// Schemes is the list of allowed URL schemes, e.g. "https". Relative URLs
+	// are never allowed.
+	Schemes []string
+	// Rel is the rel attribute of links, e.g. "nofollow noopener". It is
+	// omitted if empty.
+	Rel string
+	// Target is the target attribute of links, e.g. "_blank". It is omitted if
+	// empty.
+	Target string
+	// Redirect, if not nil, rewrites the href of allowed links, e.g. to send
+	// them through an interstitial page.
+	Redirect func(u *url.URL) string
+}
+
+// DefaultURLPolicy only allows http and https links, which are marked as
+// untrusted.
+var DefaultURLPolicy = URLPolicy{
+	Schemes: []string{"http", "https"},
+	Rel:     "nofollow noopener noreferrer",
+}
+
+// Allow parses the given link target and returns it if it's allowed.
+func (p *URLPolicy) Allow(target string) (*url.URL, bool) {
+	u, err := url.Parse(strings.TrimSpace(target))
+	if err != nil || u.Scheme == "" || (hasHost(u.Scheme) && u.Host == "") {
+		return nil, false
+	}
+
+	for _, scheme := range p.Schemes {
+		if strings.EqualFold(scheme, u.Scheme) {
+			return u, true
+		}
+	}
+
+	return nil, false
+}
+
+// Href returns the href of the given link target, which might be redirected.
+// False is returned if the link isn't allowed.
+func (p *URLPolicy) Href(target string) (string, bool) {
+	u, ok := p.Allow(target)
+	if !ok {
+		return "", false
+	}
+	if p.Redirect != nil {
+		return p.Redirect(u), true
+	}
+	return u.String(), true
+}
+
+// hasHost returns true if URLs with the given scheme must have a host.
+func hasHost(scheme string) bool {
+	switch strings.ToLower(scheme) {
+	case "http", "https", "ftp", "ws", "wss":
+		return true
+	default:
+		return false
+	}
+}
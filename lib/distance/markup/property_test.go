@@ -0,0 +1,157 @@
+package markup
+
+import (
+	"io"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// markupFragments are the pieces that random chat input is made of. They are
+// biased towards tags and characters that matter to HTML.
+var markupFragments = []string{
+	"[b]", "[/b]", "[i]", "[/i]", "[u]", "[/u]", "[s]", "[/s]",
+	"[sub]", "[/sub]", "[sup]", "[/sup]", "[c]", "[/c]",
+	"[FF0000]", "[00ff00]", "[-]", "[url]", "[/url]",
+	"[url=", "[url=https://example.com]", "[url=javascript:alert(1)]",
+	"[url=data:text/html,<script>]", "[url= JaVaScRiPt:x]", "[url=//evil.com]",
+	"javascript:", "https://", "example.com", "]", "[", "[/",
+	"<", ">", "&", "\"", "'", "=", "/", " ", "\n", "\t", "\x00",
+	"<script>", "</script>", "<img src=x onerror=alert(1)>", "onclick=",
+	"&lt;", "&#x3c;", "style=", "a", "é", "💥", "\u200B",
+}
+
+func randomMarkup(rng *rand.Rand) string {
+	var b strings.Builder
+	n := rng.Intn(30)
+	for i := 0; i < n; i++ {
+		if rng.Intn(8) == 0 {
+			// Throw in some random runes too.
+			b.WriteRune(rune(rng.Intn(0x3000)))
+			continue
+		}
+		b.WriteString(markupFragments[rng.Intn(len(markupFragments))])
+	}
+	return b.String()
+}
+
+var (
+	allowedElements = map[string]bool{
+		"span": true, "b": true, "i": true, "u": true,
+		"del": true, "sub": true, "sup": true, "a": true,
+	}
+	colorStyleRegex = regexp.MustCompile(`^color:#[0-9A-Fa-f]{6}$`)
+)
+
+// checkHTML checks that the given HTML only has the elements and attributes
+// that HTMLRenderer may produce, that all elements are balanced, and that
+// links aren't nested.
+func checkHTML(t *testing.T, input, output string, policy *URLPolicy) {
+	t.Helper()
+
+	fail := func(format string, v ...interface{}) {
+		t.Helper()
+		t.Fatalf("input %q\noutput %q\n"+format, append([]interface{}{input, output}, v...)...)
+	}
+
+	var stack []string
+	z := html.NewTokenizer(strings.NewReader(output))
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				fail("tokenizer error: %v", z.Err())
+			}
+			if len(stack) > 0 {
+				fail("unclosed elements %v", stack)
+			}
+			return
+
+		case html.StartTagToken:
+			tok := z.Token()
+			if !allowedElements[tok.Data] {
+				fail("unexpected element <%s>", tok.Data)
+			}
+
+			for _, attr := range tok.Attr {
+				switch {
+				case tok.Data == "span" && attr.Key == "style":
+					if !colorStyleRegex.MatchString(attr.Val) {
+						fail("unexpected style %q", attr.Val)
+					}
+				case tok.Data == "a" && attr.Key == "href":
+					u, err := url.Parse(attr.Val)
+					if err != nil {
+						fail("invalid href %q: %v", attr.Val, err)
+					}
+					if _, ok := policy.Allow(u.String()); !ok {
+						fail("disallowed href %q", attr.Val)
+					}
+				case tok.Data == "a" && attr.Key == "rel" && attr.Val == policy.Rel:
+				case tok.Data == "a" && attr.Key == "target" && attr.Val == policy.Target:
+				default:
+					fail("unexpected attribute %s=%q on <%s>", attr.Key, attr.Val, tok.Data)
+				}
+			}
+
+			if tok.Data == "a" {
+				for _, open := range stack {
+					if open == "a" {
+						fail("nested <a>")
+					}
+				}
+			}
+
+			stack = append(stack, tok.Data)
+
+		case html.EndTagToken:
+			tok := z.Token()
+			if len(stack) == 0 || stack[len(stack)-1] != tok.Data {
+				fail("unbalanced </%s> with open elements %v", tok.Data, stack)
+			}
+			stack = stack[:len(stack)-1]
+
+		case html.SelfClosingTagToken, html.CommentToken, html.DoctypeToken:
+			fail("unexpected token %q", z.Token())
+		}
+	}
+}
+
+func TestHTMLProperties(t *testing.T) {
+	policies := []*URLPolicy{
+		&DefaultURLPolicy,
+		{
+			Schemes: []string{"https", "mailto"},
+			Target:  "_blank",
+			Redirect: func(u *url.URL) string {
+				return "https://front.example.com/out?u=" + url.QueryEscape(u.String())
+			},
+		},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		input := randomMarkup(rng)
+		policy := policies[i%len(policies)]
+
+		r := HTMLRenderer{
+			URLPolicy: policy,
+			// Also make sure that a misbehaving modifier can't inject styles.
+			ColorModifier: func(c string) string {
+				if c == "FF0000" {
+					return `000000;background:url(x)`
+				}
+				return c
+			},
+		}
+
+		checkHTML(t, input, RenderString(r, input), policy)
+		checkHTML(t, input, ToHTML(input), &DefaultURLPolicy)
+	}
+}
@@ -0,0 +1,160 @@
+package markup
+
+import (
+	"html"
+	"strings"
+)
+
+// Renderer renders parsed markup into another format.
+type Renderer interface {
+	Render(nodes []Node) string
+}
+
+// RenderString parses and renders the given markup.
+func RenderString(r Renderer, markup string) string {
+	return r.Render(Parse(markup))
+}
+
+// Walk walks the given nodes depth-first. fn is called with entering true
+// before a node's children and with entering false after them; Text nodes are
+// only entered. If fn returns false when entering, the node's children are
+// skipped, but fn is still called when exiting.
+func Walk(nodes []Node, fn func(n Node, entering bool) bool) {
+	for _, n := range nodes {
+		if _, ok := n.(Text); ok {
+			fn(n, true)
+			continue
+		}
+
+		if fn(n, true) {
+			Walk(Children(n), fn)
+		}
+		fn(n, false)
+	}
+}
+
+// PlainRenderer renders markup as plain text without any formatting.
+type PlainRenderer struct{}
+
+// Render implements Renderer.
+func (PlainRenderer) Render(nodes []Node) string {
+	return PlainText(nodes)
+}
+
+// PlainText returns the text of the given nodes without any formatting.
+func PlainText(nodes []Node) string {
+	var b strings.Builder
+
+	Walk(nodes, func(n Node, entering bool) bool {
+		if text, ok := n.(Text); ok {
+			b.WriteString(string(text))
+		}
+		return true
+	})
+
+	return b.String()
+}
+
+// HTMLRenderer renders markup as HTML. All text is escaped.
+type HTMLRenderer struct {
+	// ColorModifier modifies text colors. It takes and returns a hex color
+	// without the '#'; if it returns an empty string, then the text isn't
+	// colored. Nil leaves colors as-is.
+	ColorModifier func(string) string
+	// URLPolicy decides which links are rendered. Nil uses DefaultURLPolicy.
+	URLPolicy *URLPolicy
+}
+
+var htmlStyleTags = map[StyleKind]string{
+	Bold:        "b",
+	Italic:      "i",
+	Underline:   "u",
+	Strike:      "del",
+	Subscript:   "sub",
+	Superscript: "sup",
+}
+
+// Render implements Renderer.
+func (r HTMLRenderer) Render(nodes []Node) string {
+	var b strings.Builder
+	// link is the URL whose anchor is open. Anchors can't be nested, so links
+	// inside of it are rendered as their text.
+	var link *URL
+
+	Walk(nodes, func(n Node, entering bool) bool {
+		switch n := n.(type) {
+		case Text:
+			b.WriteString(html.EscapeString(string(n)))
+
+		case *Color:
+			color := n.Hex
+			if r.ColorModifier != nil {
+				// The modified color goes into the style attribute, so make
+				// sure that it's still a color.
+				color = hex(r.ColorModifier(color))
+			}
+			if color == "" {
+				break
+			}
+
+			if entering {
+				b.WriteString(`<span style="color:#`)
+				b.WriteString(color)
+				b.WriteString(`">`)
+			} else {
+				b.WriteString("</span>")
+			}
+
+		case *Style:
+			b.WriteByte('<')
+			if !entering {
+				b.WriteByte('/')
+			}
+			b.WriteString(htmlStyleTags[n.Kind])
+			b.WriteByte('>')
+
+		case *URL:
+			policy := r.URLPolicy
+			if policy == nil {
+				policy = &DefaultURLPolicy
+			}
+
+			// Links that aren't allowed are rendered as their text.
+			href, ok := policy.Href(n.Target())
+			if !ok {
+				break
+			}
+
+			if !entering {
+				if n == link {
+					b.WriteString("</a>")
+					link = nil
+				}
+				break
+			}
+			if link != nil {
+				break
+			}
+			link = n
+
+			b.WriteString(`<a href="`)
+			b.WriteString(html.EscapeString(href))
+			b.WriteByte('"')
+			if policy.Rel != "" {
+				b.WriteString(` rel="`)
+				b.WriteString(html.EscapeString(policy.Rel))
+				b.WriteByte('"')
+			}
+			if policy.Target != "" {
+				b.WriteString(` target="`)
+				b.WriteString(html.EscapeString(policy.Target))
+				b.WriteByte('"')
+			}
+			b.WriteByte('>')
+		}
+
+		return true
+	})
+
+	return b.String()
+}
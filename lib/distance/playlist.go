@@ -33,6 +33,12 @@ func (c *Client) AllPlaylist() (*PlaylistState, error) {
 		state.CurrentLevelIndex = s.CurrentLevelIndex
 		state.Playlist.Levels = append(state.Playlist.Levels, s.Playlist.Levels...)
 
+		// Guard against an empty page, which would otherwise loop forever or
+		// panic below.
+		if len(s.Playlist.Levels) == 0 {
+			break
+		}
+
 		last := state.Playlist.Levels[len(state.Playlist.Levels)-1]
 		state.Playlist.Start = last.Index + 1
 
@@ -0,0 +1,74 @@
+package distance
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy describes how idempotent requests are retried. Only GET requests
+// that fail with a transport error or a 429 or 5xx status code are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per request, including the
+	// first one. A value of 1 or less disables retrying.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It is doubled on each
+	// retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries. A value of 0 or less leaves it
+	// uncapped.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by NewClient.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    2 * time.Second,
+}
+
+// NoRetry disables retrying.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// delay returns the delay before the given retry, starting from 1. Full jitter
+// is applied, so the delay is anywhere between 0 and the exponential backoff.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	backoff := p.BaseDelay
+	// Stop doubling before it overflows, in case the delay is uncapped.
+	for i := 1; i < retry && (p.MaxDelay <= 0 || backoff < p.MaxDelay) && backoff <= math.MaxInt64/2; i++ {
+		backoff *= 2
+	}
+	if p.MaxDelay > 0 && backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// shouldRetry returns true if a request with the given method should be retried
+// after failing with the given status code, or with a transport error if the
+// status code is 0.
+func shouldRetry(method string, statusCode int) bool {
+	if method != "GET" {
+		return false
+	}
+	return statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// sleepContext sleeps for the given duration or until the context is done, in
+// which case the context's error is returned.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,38 @@
+package distance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		policy RetryPolicy
+		retry  int
+		max    time.Duration
+	}{
+		{RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}, 1, time.Millisecond},
+		{RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}, 5, 4 * time.Millisecond},
+		// Without MaxDelay, the delay keeps growing.
+		{RetryPolicy{BaseDelay: time.Millisecond}, 5, 16 * time.Millisecond},
+		{RetryPolicy{BaseDelay: time.Millisecond}, 100, 1<<63 - 1},
+	}
+
+	for _, test := range tests {
+		var longest time.Duration
+		for i := 0; i < 1000; i++ {
+			d := test.policy.delay(test.retry)
+			if d < 0 || d > test.max {
+				t.Fatalf("%+v retry %d: delay %v out of [0, %v]", test.policy, test.retry, d, test.max)
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+
+		// With full jitter, some delay should come close to the maximum.
+		if longest < test.max/2 {
+			t.Errorf("%+v retry %d: longest delay %v, expected up to %v", test.policy, test.retry, longest, test.max)
+		}
+	}
+}
@@ -1,84 +1,176 @@
 package main
 
 import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"time"
+	"strings"
+	"syscall"
 
+	"github.com/diamondburned/distant-front/internal/audit"
+	"github.com/diamondburned/distant-front/internal/config"
 	"github.com/diamondburned/distant-front/internal/frontend"
-	"github.com/diamondburned/distant-front/internal/frontend/index"
+	"github.com/diamondburned/distant-front/internal/frontend/outlink"
+	"github.com/diamondburned/distant-front/internal/metrics"
+	"github.com/diamondburned/distant-front/internal/session"
 	"github.com/diamondburned/distant-front/internal/workshopimg"
-	"github.com/diamondburned/distant-front/lib/distance"
 	"github.com/diamondburned/distant-front/lib/distance/markup"
 	"github.com/go-chi/chi"
 	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Fatalln("failed to load .env:", err)
+	configPath := flag.String("config", "", "path to the TOML config; the environment is used if empty")
+	hashPassword := flag.Bool("hash-password", false, "read a password from stdin and print its hash for admin.user")
+	flag.Parse()
+
+	if *hashPassword {
+		if err := printPasswordHash(); err != nil {
+			log.Fatalln(err)
+		}
+		return
 	}
 
-	var (
-		endpoint   = os.Getenv("DISTANCE_ENDPOINT")
-		siteName   = os.Getenv("DISTANCE_NAME")
-		privToken  = os.Getenv("DISTANCE_PRIVTOKEN")
-		listenAddr = os.Getenv("DISTANCE_LISTEN")
-		observeFq  = os.Getenv("DISTANCE_OBSERVEFQ")
-	)
+	if err := godotenv.Load(); err != nil && *configPath == "" {
+		log.Fatalln("failed to load .env:", err)
+	}
 
-	// Make all colors darker.
-	markup.ColorModifier = markup.Darken(+0.8, -0.2)
+	loadConfig := func() (*config.Config, error) {
+		if *configPath != "" {
+			return config.Load(*configPath)
+		}
+		return config.FromEnv()
+	}
 
-	distanceURL, err := url.Parse(endpoint)
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatalln("invalid $DISTANCE_ENDPOINT:", err)
+		log.Fatalln(err)
 	}
 
-	c, err := distance.NewClient(distanceURL.String())
-	if err != nil {
-		log.Fatalln("failed to crete Distance client:", err)
+	urlPolicy := &markup.URLPolicy{
+		Schemes: cfg.Markup.Links.Schemes,
+		Rel:     cfg.Markup.Links.Rel,
+		Target:  cfg.Markup.Links.Target,
+	}
+	if cfg.Markup.Links.Interstitial {
+		urlPolicy.Redirect = outlink.Href
 	}
 
-	if privToken != "" {
-		c.SetPrivateToken(privToken)
+	frontend.MarkupRenderer = markup.HTMLRenderer{
+		ColorModifier: markup.Darken(cfg.Markup.Saturate, cfg.Markup.Value),
+		URLPolicy:     urlPolicy,
 	}
 
-	imgRoute, err := workshopimg.Mount(workshopimg.CacheOpts{
-		CachePath: filepath.Join(os.TempDir(), "workshopimg.cache"),
-	})
+	cacheOpts := workshopimg.NoCache
+	if cfg.WorkshopCache != "" {
+		cacheOpts.CachePath = cfg.WorkshopCache
+	}
+
+	imgRoute, err := workshopimg.Mount(cacheOpts)
 	if err != nil {
 		log.Println("Warning: workshop cache load error:", err)
 	}
 
-	observeFreq := 500 * time.Millisecond
-	if observeFq != "" {
-		d, err := time.ParseDuration(observeFq)
+	auditLog, err := openAudit(cfg.Audit)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	sessions := session.NewStore()
+	if cfg.Session.Path != "" {
+		sessions, err = session.Open(cfg.Session.Path)
 		if err != nil {
-			log.Fatalln("invalid DISTANCE_OBSERVEFQ:", err)
+			log.Fatalln("failed to open sessions:", err)
 		}
-		observeFreq = d
 	}
 
-	rs := frontend.RenderState{
-		Client:      c,
-		Observer:    distance.NewObserver(c, observeFreq),
-		SiteName:    siteName,
-		DistanceURL: distanceURL,
+	reg := metrics.NewRegistry()
+
+	a := newApp(reg, auditLog, sessions)
+	if err := a.apply(cfg); err != nil {
+		log.Fatalln(err)
 	}
 
 	r := chi.NewRouter()
 	r.Mount("/workshopimg", imgRoute)
 	r.Mount("/static", frontend.MountStatic())
-	r.Mount("/", index.Mount(rs))
+	r.Mount("/metrics", metrics.Handler(reg))
+	siteName := cfg.Name
+	if siteName == "" && len(cfg.Servers) == 1 {
+		siteName = cfg.Servers[0].Name
+	}
+
+	r.Mount("/out", outlink.Mount(siteName, urlPolicy))
+	r.Mount("/", a)
 
-	if listenAddr == "" {
-		listenAddr = ":8081"
+	// Reload the configuration on SIGHUP. An invalid configuration is rejected
+	// and the current one is kept.
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	go func() {
+		for range sighup {
+			cfg, err := loadConfig()
+			if err == nil {
+				err = a.apply(cfg)
+			}
+			if err != nil {
+				log.Println("Error: config not reloaded:", err)
+				continue
+			}
+			log.Println("Reloaded config")
+		}
+	}()
+
+	log.Println("Listen and serve at", cfg.Listen)
+	log.Fatalln(http.ListenAndServe(cfg.Listen, r))
+}
+
+// auditMemory is the number of audit entries kept in memory for querying.
+const auditMemory = 10000
+
+// openAudit opens the audit log with the given configuration.
+func openAudit(cfg config.Audit) (*audit.Log, error) {
+	if cfg.Path == "" {
+		return audit.New(auditMemory), nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create audit log directory")
+	}
+
+	return audit.Open(cfg.Path, audit.FileOpts{
+		MaxSize:  int64(cfg.MaxSize) << 20,
+		MaxFiles: cfg.MaxFiles,
+	}, auditMemory)
+}
+
+// printPasswordHash reads a password from the first line of stdin and prints
+// its bcrypt hash.
+func printPasswordHash() error {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "failed to read password")
+	}
+
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("empty password")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "failed to hash password")
 	}
 
-	log.Println("Listen and serve at", listenAddr)
-	log.Fatalln(http.ListenAndServe(listenAddr, r))
+	fmt.Println(string(hash))
+	return nil
 }